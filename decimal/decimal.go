@@ -0,0 +1,137 @@
+// Package decimal implements the exact decimal arithmetic behind the mock's money fields
+//
+// 3Commas sends prices, amounts and profits as decimal strings, with up to 36
+// fractional digits in recorded deals. Values are parsed into big.Rat, computed
+// exactly and formatted back without exponent or trailing zeros, so a value
+// that round-trips through the mock compares equal as a string. Rounding to a
+// pair's precision goes through the pair's price or lot step
+package decimal
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxScale is the number of fractional digits kept when formatting
+// Recorded deals carry up to 36 fractional digits (bought_average_price)
+const MaxScale = 36
+
+// Parse parses a 3Commas decimal string
+// Empty or malformed values are treated as zero, like missing numbers in API payloads;
+// fractions and exponents such as "1/3" or "1e5" are malformed, 3Commas never sends them
+func Parse(s string) *big.Rat {
+	r, ok := parse(s)
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// Valid reports whether s is a well-formed decimal string
+// Parse treats anything else as zero
+func Valid(s string) bool {
+	_, ok := parse(s)
+	return ok
+}
+
+// parse parses s, rejecting fractions and exponents
+func parse(s string) (*big.Rat, bool) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "/eE") {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// FromFloat32 converts a float32 API field to the decimal it was written as
+// The shortest representation is used, so 1.1 stays 1.1 instead of the
+// float's binary expansion
+func FromFloat32(f float32) *big.Rat {
+	return Parse(strconv.FormatFloat(float64(f), 'f', -1, 32))
+}
+
+// FromFloat64 converts a float64 value (e.g. a decoded JSON number) to the decimal it was written as
+func FromFloat64(f float64) *big.Rat {
+	return Parse(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// Format formats r without exponent or trailing zeros, keeping up to MaxScale fractional digits
+func Format(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	s := r.FloatString(MaxScale)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// FormatFixed formats r with exactly places fractional digits, halves away from zero
+// Used for percentages, which 3Commas reports with two digits
+func FormatFixed(r *big.Rat, places int) string {
+	s := r.FloatString(places)
+	if strings.Trim(s, "-0.") == "" {
+		return strings.TrimPrefix(s, "-")
+	}
+	return s
+}
+
+// FormatStep rounds r to a multiple of step and formats it
+// This is how a value is shown at a pair's precision
+func FormatStep(r, step *big.Rat) string {
+	return Format(RoundToStep(r, step))
+}
+
+// Step returns the step of a precision in fractional digits: 10^-places
+func Step(places int) *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(1), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil))
+}
+
+// FloorToStep rounds v down to a multiple of step
+// A zero or negative step leaves v unchanged
+func FloorToStep(v, step *big.Rat) *big.Rat {
+	if step.Sign() <= 0 {
+		return new(big.Rat).Set(v)
+	}
+	q := new(big.Rat).Quo(v, step)
+	n := new(big.Int).Quo(q.Num(), q.Denom())
+	if q.Sign() < 0 && !q.IsInt() {
+		n.Sub(n, big.NewInt(1))
+	}
+	return new(big.Rat).Mul(new(big.Rat).SetInt(n), step)
+}
+
+// RoundToStep rounds v to the nearest multiple of step, halves away from zero
+// A zero or negative step leaves v unchanged
+func RoundToStep(v, step *big.Rat) *big.Rat {
+	if step.Sign() <= 0 {
+		return new(big.Rat).Set(v)
+	}
+	half := new(big.Rat).Quo(step, big.NewRat(2, 1))
+	if v.Sign() < 0 {
+		half.Neg(half)
+	}
+	shifted := new(big.Rat).Add(v, half)
+	q := new(big.Rat).Quo(shifted, step)
+	n := new(big.Int).Quo(q.Num(), q.Denom())
+	return new(big.Rat).Mul(new(big.Rat).SetInt(n), step)
+}
+
+// Compare compares two decimal strings exactly: -1, 0 or +1
+// Malformed values compare as zero
+func Compare(a, b string) int {
+	return Parse(a).Cmp(Parse(b))
+}
+
+// Sum adds decimal strings exactly
+func Sum(values ...string) *big.Rat {
+	total := new(big.Rat)
+	for _, v := range values {
+		total.Add(total, Parse(v))
+	}
+	return total
+}
@@ -0,0 +1,68 @@
+package decimal
+
+import "testing"
+
+func TestFormat_RoundTrip(t *testing.T) {
+	for _, s := range []string{"0", "50000", "-5.05", "0.00003922", "0.229622043473491773308957952468007313"} {
+		if got := Format(Parse(s)); got != s {
+			t.Errorf("expected %s to round-trip, got %s", s, got)
+		}
+	}
+	if got := Format(Parse("1.50000")); got != "1.5" {
+		t.Errorf("expected trailing zeros to be dropped, got %s", got)
+	}
+	for _, s := range []string{"not a number", "1/3", "1e5", "2.5E-3"} {
+		if got := Format(Parse(s)); got != "0" || Valid(s) {
+			t.Errorf("expected malformed input %q to be zero and invalid, got %s", s, got)
+		}
+	}
+	if !Valid(" -0.5 ") || !Valid("3") {
+		t.Error("expected plain decimals to be valid")
+	}
+}
+
+func TestFromFloat32(t *testing.T) {
+	if got := Format(FromFloat32(1.1)); got != "1.1" {
+		t.Errorf("expected 1.1 without float artifacts, got %s", got)
+	}
+	if got := Format(FromFloat64(0.3)); got != "0.3" {
+		t.Errorf("expected 0.3 without float artifacts, got %s", got)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	step := Step(2)
+	tests := []struct {
+		value, round, floor string
+	}{
+		{"1.005", "1.01", "1"},
+		{"-1.005", "-1.01", "-1.01"},
+		{"2.994", "2.99", "2.99"},
+	}
+	for _, tt := range tests {
+		if got := Format(RoundToStep(Parse(tt.value), step)); got != tt.round {
+			t.Errorf("RoundToStep(%s): expected %s, got %s", tt.value, tt.round, got)
+		}
+		if got := Format(FloorToStep(Parse(tt.value), step)); got != tt.floor {
+			t.Errorf("FloorToStep(%s): expected %s, got %s", tt.value, tt.floor, got)
+		}
+	}
+	if got := FormatStep(Parse("50000.004"), Parse("0.01")); got != "50000" {
+		t.Errorf("expected 50000 at a 0.01 price step, got %s", got)
+	}
+	if got := FormatFixed(Parse("-0.001"), 2); got != "0.00" {
+		t.Errorf("expected no negative zero, got %s", got)
+	}
+	if got := FormatFixed(Parse("-4.95049"), 2); got != "-4.95" {
+		t.Errorf("expected -4.95, got %s", got)
+	}
+}
+
+func TestCompareAndSum(t *testing.T) {
+	if Compare("0.1", "0.09999999999999999999") != 1 {
+		t.Error("expected an exact comparison")
+	}
+	if got := Format(Sum("0.1", "0.2", "-0.3")); got != "0" {
+		t.Errorf("expected an exact sum of 0, got %s", got)
+	}
+}
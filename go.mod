@@ -5,6 +5,7 @@ go 1.24
 tool github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen
 
 require (
+	github.com/coder/websocket v1.8.15
 	github.com/oapi-codegen/nullable v1.1.0
 	github.com/oapi-codegen/runtime v1.1.2
 	gopkg.in/dnaeon/go-vcr.v4 v4.0.5
@@ -0,0 +1,388 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Account is a connected exchange account as returned by GET /ver1/accounts
+// The generated client has no account model, so the mock defines the fields
+// 3Commas returns that clients commonly read
+type Account struct {
+	Id                   int       `json:"id"`
+	Name                 string    `json:"name"`
+	MarketCode           string    `json:"market_code"`
+	ExchangeName         string    `json:"exchange_name"`
+	MarketIcon           string    `json:"market_icon"`
+	AutoBalanceEnabled   bool      `json:"auto_balance_enabled"`
+	ApiKeysInvalid       bool      `json:"api_keys_invalid"`
+	SupportedMarketTypes []string  `json:"supported_market_types"`
+	BtcAmount            string    `json:"btc_amount"`
+	UsdAmount            string    `json:"usd_amount"`
+	DayProfitBtc         string    `json:"day_profit_btc"`
+	DayProfitUsd         string    `json:"day_profit_usd"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// AccountBalance is a currency row of POST /ver1/accounts/{account_id}/account_table_data
+// OnOrders is the part of Position locked by active deals
+type AccountBalance struct {
+	CurrencyCode string `json:"currency_code"`
+	Position     string `json:"position"`
+	OnOrders     string `json:"on_orders"`
+	Equity       string `json:"equity"`
+	Available    string `json:"available"`
+	UsdValue     string `json:"usd_value"`
+}
+
+// accountEntry is an account with its per-currency balances
+type accountEntry struct {
+	account  Account
+	balances map[string]*big.Rat
+}
+
+// DefaultMarketList is the exchange catalog served by GET /ver1/accounts/market_list
+var DefaultMarketList = []tcmock.MarketListItem{
+	marketListItem("binance", "Binance", "https://www.binance.com"),
+	marketListItem("bybit", "Bybit", "https://www.bybit.com"),
+	marketListItem("coinbase", "Coinbase", "https://www.coinbase.com"),
+	marketListItem("kraken", "Kraken", "https://www.kraken.com"),
+	marketListItem("paper_trading", "Paper Account", "https://3commas.io"),
+}
+
+// marketListItem returns an API-key based exchange entry
+func marketListItem(code, name, url string) tcmock.MarketListItem {
+	return tcmock.MarketListItem{
+		AvailableConnectionFlows: []tcmock.MarketListItemAvailableConnectionFlows{"api_keys"},
+		FormFields: tcmock.FormFields{
+			Required: []tcmock.FormField{
+				{Field: "api_key", LocalizedName: "API Key"},
+				{Field: "secret", LocalizedName: "Secret"},
+			},
+			Optional: []tcmock.FormField{},
+		},
+		HelpLink:            "https://help.3commas.io",
+		MarketCode:          code,
+		MarketIcon:          "https://3commas.io/img/exchanges/" + code + ".png",
+		MarketName:          name,
+		MarketUrl:           url,
+		TrustedIps:          []string{},
+		TrustedIpsInputType: "text",
+	}
+}
+
+// Account Management
+
+// AddAccount adds an exchange account to the mock
+// Bots and deals added afterwards take their account_name from it
+func (ts *TestServer) AddAccount(account Account) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.addAccountLocked(account)
+}
+
+// addAccountLocked adds an account without balances
+// The caller must hold ts.mu
+func (ts *TestServer) addAccountLocked(account Account) *accountEntry {
+	entry := &accountEntry{account: account, balances: make(map[string]*big.Rat)}
+	ts.accounts[account.Id] = entry
+	return entry
+}
+
+// GetAccount retrieves an account by ID
+func (ts *TestServer) GetAccount(accountID int) (Account, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		return Account{}, false
+	}
+	return entry.account, true
+}
+
+// GetAllAccounts returns all accounts ordered by ID
+func (ts *TestServer) GetAllAccounts() []Account {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	return ts.accountListLocked()
+}
+
+// RemoveAccount removes an account from the mock
+func (ts *TestServer) RemoveAccount(accountID int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	delete(ts.accounts, accountID)
+}
+
+// SetBalance sets the total amount of a currency held on an account
+func (ts *TestServer) SetBalance(accountID int, currency, amount string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		return fmt.Errorf("account %d not found", accountID)
+	}
+	entry.balances[strings.ToUpper(currency)] = decimal.Parse(amount)
+	return nil
+}
+
+// GetBalance returns an account's balance of a currency, including what active deals lock
+func (ts *TestServer) GetBalance(accountID int, currency string) (AccountBalance, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		return AccountBalance{}, false
+	}
+	currency = strings.ToUpper(currency)
+	if _, ok := entry.balances[currency]; !ok {
+		return AccountBalance{}, false
+	}
+	return ts.balanceLocked(entry, currency), true
+}
+
+// SetMarketList replaces the exchange catalog served by GET /ver1/accounts/market_list
+func (ts *TestServer) SetMarketList(items []tcmock.MarketListItem) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.marketList = items
+}
+
+// accountListLocked returns all accounts ordered by ID
+// The caller must hold ts.mu
+func (ts *TestServer) accountListLocked() []Account {
+	result := make([]Account, 0, len(ts.accounts))
+	for _, entry := range ts.accounts {
+		result = append(result, entry.account)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result
+}
+
+// lockedFundsLocked returns the funds of a currency locked by an account's active deals
+// Deals lock their entry volume (divided by the leverage on futures) in the
+// quote currency until they finish; open
+// smart trades lock the remaining position, in the quote currency for buys and
+// the base currency for sells
+// The caller must hold ts.mu
+func (ts *TestServer) lockedFundsLocked(accountID int, currency string) *big.Rat {
+	locked := new(big.Rat)
+	for _, deal := range ts.deals {
+		if deal.AccountId != accountID || deal.FromCurrency != currency || !isActiveDeal(deal) {
+			continue
+		}
+		locked.Add(locked, dealMargin(deal))
+	}
+	for _, entry := range ts.smartTrades {
+		if entry.trade.Account.Id != accountID || !entry.active() {
+			continue
+		}
+		quote, base := splitPair(entry.trade.Pair)
+		switch {
+		case entry.sign > 0 && currency == quote:
+			locked.Add(locked, new(big.Rat).Mul(entry.remaining(), entry.entryPrice))
+		case entry.sign < 0 && currency == base:
+			locked.Add(locked, entry.remaining())
+		}
+	}
+	return locked
+}
+
+// balanceLocked computes the balance row of a currency
+// The caller must hold ts.mu
+func (ts *TestServer) balanceLocked(entry *accountEntry, currency string) AccountBalance {
+	position := entry.balances[currency]
+	locked := ts.lockedFundsLocked(entry.account.Id, currency)
+	available := new(big.Rat).Sub(position, locked)
+
+	usdValue := "0"
+	if rate, ok := ts.usdRateLocked(entry.account.MarketCode, currency); ok {
+		usdValue = decimal.Format(new(big.Rat).Mul(position, rate))
+	}
+	return AccountBalance{
+		CurrencyCode: currency,
+		Position:     decimal.Format(position),
+		OnOrders:     decimal.Format(locked),
+		Equity:       decimal.Format(position),
+		Available:    decimal.Format(available),
+		UsdValue:     usdValue,
+	}
+}
+
+// checkFundsLocked verifies an account can spend volume of a currency
+// Accounts or currencies without a configured balance are not checked
+// field is the request attribute reported when funds are insufficient
+// The caller must hold ts.mu
+func (ts *TestServer) checkFundsLocked(accountID int, currency string, volume *big.Rat, field string) *orderError {
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		return nil
+	}
+	position, ok := entry.balances[currency]
+	if !ok {
+		return nil
+	}
+
+	available := new(big.Rat).Sub(position, ts.lockedFundsLocked(accountID, currency))
+	if volume.Cmp(available) > 0 {
+		return &orderError{Field: field, Message: fmt.Sprintf(
+			"Insufficient funds. Available: %s %s", decimal.Format(available), currency)}
+	}
+	return nil
+}
+
+// settleDealLocked books the final profit of a closed deal on its account's balance
+// of the profit currency: the quote currency, or the base currency for deals
+// taking profit in base
+// Accounts or currencies without a configured balance are left untouched
+// The caller must hold ts.mu
+func (ts *TestServer) settleDealLocked(deal *tcmock.Deal) {
+	entry, ok := ts.accounts[deal.AccountId]
+	if !ok {
+		return
+	}
+	currency := deal.FromCurrency
+	if profitInBase(deal) {
+		currency = deal.ToCurrency
+	}
+	position, ok := entry.balances[currency]
+	if !ok {
+		return
+	}
+	position.Add(position, decimal.Parse(deal.FinalProfit))
+}
+
+// accountNameLocked returns the name of a known account
+// The caller must hold ts.mu
+func (ts *TestServer) accountNameLocked(accountID int) (string, bool) {
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		return "", false
+	}
+	return entry.account.Name, true
+}
+
+// accountMarketLocked returns the market code of an account
+// Unknown accounts trade on the default market
+// The caller must hold ts.mu
+func (ts *TestServer) accountMarketLocked(accountID int) string {
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		return ""
+	}
+	return entry.account.MarketCode
+}
+
+// loadBalancesLocked refreshes an account's USD and BTC totals from its balances
+// The caller must hold ts.mu
+func (ts *TestServer) loadBalancesLocked(entry *accountEntry) {
+	usd := new(big.Rat)
+	for currency, amount := range entry.balances {
+		if rate, ok := ts.usdRateLocked(entry.account.MarketCode, currency); ok {
+			usd.Add(usd, new(big.Rat).Mul(amount, rate))
+		}
+	}
+	entry.account.UsdAmount = decimal.Format(usd)
+
+	entry.account.BtcAmount = "0"
+	if btc, ok := ts.usdRateLocked(entry.account.MarketCode, "BTC"); ok && btc.Sign() > 0 {
+		entry.account.BtcAmount = decimal.Format(decimal.RoundToStep(new(big.Rat).Quo(usd, btc), averagePriceStep))
+	}
+	entry.account.UpdatedAt = ts.nowLocked()
+}
+
+// lookupAccountLocked finds the account of an {account_id} request
+// Writes the error response and returns nil if the ID is invalid or unknown
+// The caller must hold ts.mu
+func (ts *TestServer) lookupAccountLocked(w http.ResponseWriter, r *http.Request) *accountEntry {
+	accountID, err := pathInt(r, "account_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return nil
+	}
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		writeError(w, http.StatusNotFound, errorNotFound, "Account not found", nil)
+		return nil
+	}
+	return entry
+}
+
+// handleListAccounts serves GET /ver1/accounts
+func (ts *TestServer) handleListAccounts(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, ts.accountListLocked())
+}
+
+// handleGetAccount serves GET /ver1/accounts/{account_id}
+func (ts *TestServer) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry := ts.lookupAccountLocked(w, r)
+	if entry == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, entry.account)
+}
+
+// handleLoadBalances serves POST /ver1/accounts/{account_id}/load_balances
+func (ts *TestServer) handleLoadBalances(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.lookupAccountLocked(w, r)
+	if entry == nil {
+		return
+	}
+	ts.loadBalancesLocked(entry)
+	writeJSON(w, http.StatusOK, entry.account)
+}
+
+// handleAccountTableData serves POST /ver1/accounts/{account_id}/account_table_data
+func (ts *TestServer) handleAccountTableData(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry := ts.lookupAccountLocked(w, r)
+	if entry == nil {
+		return
+	}
+
+	currencies := make([]string, 0, len(entry.balances))
+	for currency := range entry.balances {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	result := make([]AccountBalance, 0, len(currencies))
+	for _, currency := range currencies {
+		result = append(result, ts.balanceLocked(entry, currency))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleMarketList serves GET /ver1/accounts/market_list
+func (ts *TestServer) handleMarketList(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, ts.marketList)
+}
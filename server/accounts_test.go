@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestAccounts(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(2, "Kraken", "kraken"))
+	ts.AddAccount(NewAccount(1, "Main Binance", "binance"))
+
+	resp, err := http.Get(ts.URL() + "/ver1/accounts")
+	if err != nil {
+		t.Fatalf("failed to GET accounts: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var accounts []Account
+	if err := json.NewDecoder(resp.Body).Decode(&accounts); err != nil {
+		t.Fatalf("failed to decode accounts: %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].Id != 1 || accounts[1].Id != 2 {
+		t.Fatalf("expected accounts 1 and 2 in order, got %+v", accounts)
+	}
+
+	resp, err = http.Get(ts.URL() + "/ver1/accounts/1")
+	if err != nil {
+		t.Fatalf("failed to GET account: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var account Account
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode account: %v", err)
+	}
+	if account.Name != "Main Binance" || account.MarketCode != "binance" {
+		t.Fatalf("unexpected account: %+v", account)
+	}
+
+	resp, err = http.Get(ts.URL() + "/ver1/accounts/99")
+	if err != nil {
+		t.Fatalf("failed to GET account: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown account, got %d", resp.StatusCode)
+	}
+}
+
+func TestAccountName(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(1, "Main Binance", "binance"))
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	ts.AddDeal(NewDeal(100, 1, "USDT_BTC", "bought"))
+
+	bot, _ := ts.GetBot(1)
+	deal, _ := ts.GetDealByID(100)
+	if bot.AccountName != "Main Binance" || deal.AccountName != "Main Binance" {
+		t.Fatalf("expected account name from the account, got bot=%q deal=%q", bot.AccountName, deal.AccountName)
+	}
+}
+
+func TestMarketList(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL() + "/ver1/accounts/market_list")
+	if err != nil {
+		t.Fatalf("failed to GET market_list: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var items []tcmock.MarketListItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		t.Fatalf("failed to decode market list: %v", err)
+	}
+	if len(items) != len(DefaultMarketList) || items[0].MarketCode != "binance" {
+		t.Fatalf("expected the default market list, got %d items", len(items))
+	}
+	if len(items[0].FormFields.Required) != 2 {
+		t.Fatalf("expected api_key and secret form fields, got %+v", items[0].FormFields)
+	}
+}
+
+func TestBalances(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.AddAccount(NewAccount(1, "Main", "binance"))
+	ts.SetBalance(1, "USDT", "1000")
+	ts.SetBalance(1, "BTC", "0.01")
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+
+	deal := NewDeal(100, 1, "USDT_BTC", "bought")
+	deal.BoughtAmount = "0.008"
+	deal.BoughtVolume = "400"
+	ts.AddDeal(deal)
+
+	balance, _ := ts.GetBalance(1, "USDT")
+	if balance.OnOrders != "400" || balance.Available != "600" {
+		t.Fatalf("expected 400 locked and 600 available, got %+v", balance)
+	}
+
+	// Market add_funds beyond the available balance is rejected
+	resp, err := http.Post(ts.URL()+"/ver1/deals/100/add_funds", "application/json",
+		strings.NewReader(`{"quantity": "0.02", "is_market": true}`))
+	if err != nil {
+		t.Fatalf("failed to POST add_funds: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var errResp tcmock.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || (*errResp.ErrorAttributes)["quantity"][0] != "Insufficient funds. Available: 600 USDT" {
+		t.Fatalf("expected insufficient funds error, got %d %+v", resp.StatusCode, errResp)
+	}
+
+	// Closing the deal releases the funds and books the profit
+	ts.mu.Lock()
+	ts.deals[100].FinalProfit = "12.5"
+	ts.mu.Unlock()
+	ts.UpdateDealStatus(100, "completed")
+
+	balance, _ = ts.GetBalance(1, "USDT")
+	if balance.OnOrders != "0" || balance.Position != "1012.5" {
+		t.Fatalf("expected released funds and booked profit, got %+v", balance)
+	}
+
+	resp, err = http.Post(ts.URL()+"/ver1/accounts/1/load_balances", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed to POST load_balances: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var account Account
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode account: %v", err)
+	}
+	if account.UsdAmount != "1512.5" || account.BtcAmount != "0.03025" {
+		t.Fatalf("expected 1512.5 USD / 0.03025 BTC, got %s / %s", account.UsdAmount, account.BtcAmount)
+	}
+
+	resp, err = http.Post(ts.URL()+"/ver1/accounts/1/account_table_data", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed to POST account_table_data: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var rows []AccountBalance
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		t.Fatalf("failed to decode balances: %v", err)
+	}
+	if len(rows) != 2 || rows[0].CurrencyCode != "BTC" || rows[0].UsdValue != "500" {
+		t.Fatalf("unexpected balances: %+v", rows)
+	}
+}
+
+func TestCreateBot_UnknownAccount(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(1, "Main", "binance"))
+
+	resp, err := http.Post(ts.URL()+"/ver1/bots/create_bot", "application/json",
+		strings.NewReader(`{"account_id": 2, "pairs": ["USDT_BTC"], "base_order_volume": "10"}`))
+	if err != nil {
+		t.Fatalf("failed to POST create_bot: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an unknown account, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// handleCreateBot serves POST /ver1/bots/create_bot
+// The bot is created disabled, like on 3Commas, with an ID after the highest existing one
+// A bot without strategy_list starts deals asap (nonstop)
+func (ts *TestServer) handleCreateBot(w http.ResponseWriter, r *http.Request) {
+	var req tcmock.CreateBotRequest
+	body, ok := decodeJSONBody(w, r, &req)
+	if !ok {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	id := ts.nextBotIDLocked()
+	name := ""
+	if req.Name != nil {
+		name = *req.Name
+	}
+	bot := NewBot(id, name, req.AccountId, false)
+	bot.StrategyList = &[]tcmock.StrategyConfig{{Strategy: ptr(tcmock.Nonstop)}}
+	if accountName, ok := ts.accountNameLocked(req.AccountId); ok {
+		bot.AccountName = accountName
+	}
+	// BotEntity and Bot share their JSON field names, so the request overlays the defaults
+	if err := json.Unmarshal(body, &bot); err != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, err.Error(), nil)
+		return
+	}
+	bot.Id = id
+	bot.IsEnabled = false
+	bot.CreatedAt = ts.nowLocked()
+	bot.UpdatedAt = bot.CreatedAt
+
+	if attrs := ts.validateBotLocked(botEntity(bot)); len(attrs) > 0 {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", attrs)
+		return
+	}
+
+	ts.bots[id] = &bot
+	writeJSON(w, http.StatusCreated, bot)
+}
+
+// handleUpdateBot serves PATCH /ver1/bots/{bot_id}/update_bot
+// Fields present in the body replace the bot's settings; the result is
+// validated like a new bot before it is stored
+func (ts *TestServer) handleUpdateBot(w http.ResponseWriter, r *http.Request) {
+	botID, err := pathInt(r, "bot_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+	var req tcmock.UpdateBotRequest
+	body, ok := decodeJSONBody(w, r, &req)
+	if !ok {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.botErrors[botID]; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), "", nil)
+		return
+	}
+	existing, ok := ts.bots[botID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "bot not found", "", nil)
+		return
+	}
+
+	// Round-trip a copy first so the overlay cannot write through shared pointers
+	bot := copyBot(*existing)
+	if err := json.Unmarshal(body, &bot); err != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, err.Error(), nil)
+		return
+	}
+	bot.Id = existing.Id
+	bot.AccountId = existing.AccountId
+	bot.AccountName = existing.AccountName
+	bot.IsEnabled = existing.IsEnabled
+	bot.CreatedAt = existing.CreatedAt
+	bot.UpdatedAt = ts.nowLocked()
+
+	if attrs := ts.validateBotLocked(botEntity(bot)); len(attrs) > 0 {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", attrs)
+		return
+	}
+
+	ts.bots[botID] = &bot
+	writeJSON(w, http.StatusOK, bot)
+}
+
+// nextBotIDLocked returns the ID after the highest existing bot ID
+// The caller must hold ts.mu
+func (ts *TestServer) nextBotIDLocked() int {
+	id := 1
+	for existing := range ts.bots {
+		if existing >= id {
+			id = existing + 1
+		}
+	}
+	return id
+}
+
+// copyBot returns a deep copy of a bot
+func copyBot(bot tcmock.Bot) tcmock.Bot {
+	var result tcmock.Bot
+	data, _ := json.Marshal(bot)
+	json.Unmarshal(data, &result)
+	return result
+}
+
+// botEntity returns the settings of a bot as a BotEntity
+func botEntity(bot tcmock.Bot) tcmock.BotEntity {
+	var entity tcmock.BotEntity
+	data, _ := json.Marshal(bot)
+	json.Unmarshal(data, &entity)
+	return entity
+}
+
+// validateBotLocked checks a bot's settings against the strategy catalog and
+// the pairs' trading limits
+// The account is only checked once accounts were added with AddAccount
+// Returns the error_attributes of every violation
+// The caller must hold ts.mu
+func (ts *TestServer) validateBotLocked(req tcmock.BotEntity) map[string][]string {
+	attrs := map[string][]string{}
+	if _, ok := ts.accounts[req.AccountId]; !ok && len(ts.accounts) > 0 {
+		attrs["account_id"] = []string{"is invalid"}
+	}
+	if len(req.Pairs) == 0 {
+		attrs["pairs"] = []string{"can't be blank"}
+	}
+	if req.BaseOrderVolume == nil || *req.BaseOrderVolume == "" {
+		attrs["base_order_volume"] = []string{"can't be blank"}
+	}
+	ts.validateStrategiesLocked("strategy_list", req.StrategyList, attrs)
+	ts.validateStrategiesLocked("close_strategy_list", req.CloseStrategyList, attrs)
+	ts.validateStrategiesLocked("safety_strategy_list", req.SafetyStrategyList, attrs)
+	if len(attrs) > 0 {
+		return attrs
+	}
+
+	marketCode := ts.accountMarketLocked(req.AccountId)
+	baseType, safetyType := "", ""
+	if req.BaseOrderVolumeType != nil {
+		baseType = string(*req.BaseOrderVolumeType)
+	}
+	if req.SafetyOrderVolumeType != nil {
+		safetyType = string(*req.SafetyOrderVolumeType)
+	}
+	addError := func(err *orderError) {
+		for _, msg := range attrs[err.Field] {
+			if msg == err.Message {
+				return
+			}
+		}
+		attrs[err.Field] = append(attrs[err.Field], err.Message)
+	}
+
+	for _, pair := range req.Pairs {
+		limits := ts.orderLimitsLocked(marketCode, pair)
+		var price *big.Rat
+		if entry, ok := ts.lookupMarketLocked(marketCode, pair); ok {
+			price = decimal.Parse(entry.rates.Last)
+		}
+
+		if _, _, err := limits.checkTypedVolume("Base order", "base_order_volume", baseType, decimal.Parse(*req.BaseOrderVolume), price); err != nil {
+			addError(err)
+		}
+		if req.MaxSafetyOrders != nil && *req.MaxSafetyOrders > 0 && req.SafetyOrderVolume != nil {
+			if _, _, err := limits.checkTypedVolume("Safety order", "safety_order_volume", safetyType, decimal.Parse(*req.SafetyOrderVolume), price); err != nil {
+				addError(err)
+			}
+		}
+	}
+	return attrs
+}
+
+// decodeJSONBody reads a JSON request body into v and returns the raw body
+// Writes a 400 record_invalid error and returns false if the body is not valid JSON
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) ([]byte, bool) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid JSON body", nil)
+		return nil, false
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid JSON body", nil)
+		return nil, false
+	}
+	return raw, true
+}
@@ -0,0 +1,50 @@
+package server
+
+import "time"
+
+// SetClock freezes the mock clock at t
+// Price functions are re-evaluated and deals repriced at the new time
+func (ts *TestServer) SetClock(t time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.clock = &t
+	ts.clockChangedLocked()
+}
+
+// AdvanceClock moves the mock clock forward by d
+// A clock that was not frozen yet is frozen at the current time first
+func (ts *TestServer) AdvanceClock(d time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	next := ts.nowLocked().Add(d)
+	ts.clock = &next
+	ts.clockChangedLocked()
+}
+
+// Now returns the current mock time (wall-clock time unless SetClock or AdvanceClock was used)
+func (ts *TestServer) Now() time.Time {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	return ts.nowLocked()
+}
+
+// nowLocked returns the current mock time
+// The caller must hold ts.mu
+func (ts *TestServer) nowLocked() time.Time {
+	if ts.clock != nil {
+		return *ts.clock
+	}
+	return time.Now()
+}
+
+// clockChangedLocked refreshes everything that depends on the clock
+// The caller must hold ts.mu
+func (ts *TestServer) clockChangedLocked() {
+	ts.refreshAnimatedPricesLocked()
+	ts.tickStopLossTimeoutsLocked()
+	ts.tickCloseStrategiesLocked()
+	ts.runPendingDealStartsLocked()
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Close strategies
+//
+// With simulation enabled, deals carrying a close_strategy_list close as
+// completed once every strategy in the list signals, provided the profit meets
+// min_profit_percentage of the min_profit_type volume (base_order_volume or
+// total_bought_volume; no minimum when min_profit_type is null). This happens
+// independently of the take profit, so deals without one close on signal only
+//
+// Each strategy is decided by a CloseEvaluator. The built-in ones are rsi, which
+// compares the RSI-7 against the points option in the trigger_condition
+// direction, and trading_view, which closes on a sell or strong_sell signal
+// (strong_sell only with type strong). Indicator values come from
+// SetIndicatorValue or, for rsi, from the pair's price series on the mock clock
+
+// rsiPeriod is the number of intervals the RSI averages over, matching the RSI-7 strategy
+const rsiPeriod = 7
+
+// maxPriceHistory is the number of price changes kept per pair for computed indicators
+const maxPriceHistory = 10000
+
+// CloseInput is what a CloseEvaluator decides on
+type CloseInput struct {
+	Deal     tcmock.Deal
+	Strategy string
+	Options  map[string]interface{}
+	// Value is the indicator value fed with SetIndicatorValue, or the computed
+	// RSI for rsi; "" when unknown
+	Value string
+	Now   time.Time
+}
+
+// CloseEvaluator reports whether a close strategy signals its deal to close
+// Evaluators run while the server state is locked and must not call the TestServer
+type CloseEvaluator func(input CloseInput) bool
+
+// indicatorKey identifies an indicator value fed for a pair
+type indicatorKey struct {
+	Pair     string
+	Strategy string
+}
+
+// pricePoint is a recorded price of a pair
+type pricePoint struct {
+	at    time.Time
+	price *big.Rat
+}
+
+// builtinCloseEvaluators decide the close strategies of the default catalog
+var builtinCloseEvaluators = map[string]CloseEvaluator{
+	string(tcmock.Rsi):         evaluateRSIClose,
+	string(tcmock.TradingView): evaluateTradingViewClose,
+}
+
+// SetCloseEvaluator plugs in the evaluator for a close strategy
+// A nil fn restores the built-in evaluator, if any
+func (ts *TestServer) SetCloseEvaluator(strategy string, fn CloseEvaluator) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if fn == nil {
+		delete(ts.closeEvaluators, strategy)
+	} else {
+		ts.closeEvaluators[strategy] = fn
+	}
+	ts.tickCloseStrategiesLocked()
+}
+
+// SetIndicatorValue feeds the value of a strategy's indicator on a pair
+// (e.g. "rsi" → "75", "trading_view" → "strong_sell"); "" clears it
+// Open deals on the pair re-evaluate their close strategies
+func (ts *TestServer) SetIndicatorValue(pair, strategy, value string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	key := indicatorKey{Pair: pair, Strategy: strategy}
+	if value == "" {
+		delete(ts.indicators, key)
+	} else {
+		ts.indicators[key] = value
+	}
+	for _, deal := range ts.deals {
+		if deal.Pair == pair {
+			ts.tickDealLocked(deal)
+		}
+	}
+}
+
+// tickCloseStrategiesLocked re-evaluates the deals carrying close strategies
+// Computed indicators move with the mock clock even when prices don't
+// The caller must hold ts.mu
+func (ts *TestServer) tickCloseStrategiesLocked() {
+	if !ts.simulateDeals {
+		return
+	}
+	for _, deal := range ts.deals {
+		if isActiveDeal(deal) && len(deal.CloseStrategyList) > 0 {
+			ts.tickDealLocked(deal)
+		}
+	}
+}
+
+// simulateCloseStrategiesLocked closes a deal once all of its close strategies
+// signal and the minimum profit is reached
+// Returns whether the deal closed
+// The caller must hold ts.mu
+func (ts *TestServer) simulateCloseStrategiesLocked(deal *tcmock.Deal, price *big.Rat) bool {
+	if len(deal.CloseStrategyList) == 0 || !minProfitReached(deal, price) {
+		return false
+	}
+
+	names := make([]string, 0, len(deal.CloseStrategyList))
+	for _, config := range deal.CloseStrategyList {
+		strategy := fmt.Sprint(config["strategy"])
+		evaluate, ok := ts.closeEvaluators[strategy]
+		if !ok {
+			evaluate, ok = builtinCloseEvaluators[strategy]
+		}
+		if !ok {
+			return false
+		}
+		options, _ := config["options"].(map[string]interface{})
+		input := CloseInput{
+			Deal:     *deal,
+			Strategy: strategy,
+			Options:  options,
+			Value:    ts.indicatorValueLocked(ts.accountMarketLocked(deal.AccountId), deal.Pair, strategy, options),
+			Now:      ts.nowLocked(),
+		}
+		if !evaluate(input) {
+			return false
+		}
+		names = append(names, strategy)
+	}
+
+	ts.closeDealLocked(deal, price, "completed", fmt.Sprintf("Close condition met (%s).", strings.Join(names, ", ")))
+	return true
+}
+
+// minProfitReached reports whether a deal's profit at price meets its minimum profit
+// The minimum is min_profit_percentage of the base order volume or, with
+// total_bought_volume, of the entry volume; there is none when min_profit_type is null
+func minProfitReached(deal *tcmock.Deal, price *big.Rat) bool {
+	kind, err := deal.MinProfitType.Get()
+	if err != nil || kind == "" {
+		return true
+	}
+
+	basis := decimal.Parse(deal.BaseOrderVolume)
+	if kind == string(tcmock.BotMinProfitTypeTotalBoughtVolume) {
+		basis = entryFills(deal).filledVolume()
+	}
+	required := new(big.Rat).Mul(basis, decimal.Parse(deal.MinProfitPercentage))
+	required.Quo(required, big.NewRat(100, 1))
+	return dealProfit(deal, price).Cmp(required) >= 0
+}
+
+// indicatorValueLocked returns the value of a strategy's indicator on a pair
+// Fed values win; rsi is otherwise computed from the pair's price series on marketCode
+// The caller must hold ts.mu
+func (ts *TestServer) indicatorValueLocked(marketCode, pair, strategy string, options map[string]interface{}) string {
+	if value, ok := ts.indicators[indicatorKey{Pair: pair, Strategy: strategy}]; ok {
+		return value
+	}
+	if strategy != string(tcmock.Rsi) {
+		return ""
+	}
+
+	interval := strategyInterval(options)
+	samples, ok := ts.priceSamplesLocked(marketCode, pair, interval, rsiPeriod+1)
+	if !ok {
+		return ""
+	}
+	return decimal.FormatFixed(computeRSI(samples), 2)
+}
+
+// priceSamplesLocked returns n prices of a pair, interval apart and ending now, oldest first
+// Animated pairs evaluate their PriceFunc; others use the recorded price changes
+// False when the history doesn't reach back far enough
+// The caller must hold ts.mu
+func (ts *TestServer) priceSamplesLocked(marketCode, pair string, interval time.Duration, n int) ([]*big.Rat, bool) {
+	entry, ok := ts.lookupMarketLocked(marketCode, pair)
+	if !ok {
+		return nil, false
+	}
+
+	now := ts.nowLocked()
+	samples := make([]*big.Rat, 0, n)
+	for k := n - 1; k >= 0; k-- {
+		at := now.Add(-time.Duration(k) * interval)
+		if entry.priceFunc != nil {
+			samples = append(samples, decimal.Parse(entry.priceFunc(at)))
+			continue
+		}
+		i := sort.Search(len(entry.history), func(i int) bool { return entry.history[i].at.After(at) })
+		if i == 0 {
+			return nil, false
+		}
+		samples = append(samples, entry.history[i-1].price)
+	}
+	return samples, true
+}
+
+// recordPriceLocked appends the current price of a market entry to its history
+// The caller must hold ts.mu
+func (ts *TestServer) recordPriceLocked(entry *marketEntry) {
+	// A rewound clock rewrites the history from the new time on
+	point := pricePoint{at: ts.nowLocked(), price: decimal.Parse(entry.rates.Last)}
+	i := sort.Search(len(entry.history), func(i int) bool { return !entry.history[i].at.Before(point.at) })
+	entry.history = append(entry.history[:i], point)
+	if len(entry.history) > maxPriceHistory {
+		entry.history = entry.history[len(entry.history)-maxPriceHistory:]
+	}
+}
+
+// computeRSI returns the relative strength index of a price series
+// Gains and losses are averaged over the series; a series without losses is
+// 100 and a flat one 50
+func computeRSI(prices []*big.Rat) *big.Rat {
+	gain, loss := new(big.Rat), new(big.Rat)
+	for i := 1; i < len(prices); i++ {
+		change := new(big.Rat).Sub(prices[i], prices[i-1])
+		if change.Sign() > 0 {
+			gain.Add(gain, change)
+		} else {
+			loss.Sub(loss, change)
+		}
+	}
+	switch {
+	case gain.Sign() == 0 && loss.Sign() == 0:
+		return big.NewRat(50, 1)
+	case loss.Sign() == 0:
+		return big.NewRat(100, 1)
+	}
+	// 100 - 100/(1+gain/loss) = 100*gain/(gain+loss)
+	rsi := new(big.Rat).Mul(gain, big.NewRat(100, 1))
+	return rsi.Quo(rsi, new(big.Rat).Add(gain, loss))
+}
+
+// evaluateRSIClose signals when the RSI is beyond points in the trigger_condition
+// direction: less (the catalog default) or greater
+func evaluateRSIClose(input CloseInput) bool {
+	if input.Value == "" {
+		return false
+	}
+	value := decimal.Parse(input.Value)
+	points := decimal.Parse(strategyOption(input.Options, "points", "30"))
+	if strategyOption(input.Options, "trigger_condition", "less") == "greater" {
+		return value.Cmp(points) > 0
+	}
+	return value.Cmp(points) < 0
+}
+
+// evaluateTradingViewClose signals on a sell or strong_sell TradingView signal
+// With type strong only strong_sell counts
+func evaluateTradingViewClose(input CloseInput) bool {
+	switch input.Value {
+	case "strong_sell":
+		return true
+	case "sell":
+		return strategyOption(input.Options, "type", "") != "strong"
+	}
+	return false
+}
+
+// strategyOption returns a strategy option as a string, or def when it is missing
+func strategyOption(options map[string]interface{}, key, def string) string {
+	if value, ok := options[key]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return def
+}
+
+// strategyInterval returns the indicator interval of a strategy's time option, 5m by default
+func strategyInterval(options map[string]interface{}) time.Duration {
+	value := strategyOption(options, "time", "5m")
+	if value == "1d" {
+		return 24 * time.Hour
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// strategyMaps converts a bot's strategy list to the deal representation
+func strategyMaps(list []tcmock.StrategyConfig) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(list))
+	for _, config := range list {
+		entry := map[string]interface{}{}
+		if config.Strategy != nil {
+			entry["strategy"] = string(*config.Strategy)
+		}
+		if config.Options != nil {
+			entry["options"] = maps.Clone(*config.Options)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// closeStrategy builds a close strategy config
+func closeStrategy(strategy tcmock.StrategyConfigStrategy, options map[string]interface{}) tcmock.StrategyConfig {
+	return tcmock.StrategyConfig{Strategy: ptr(strategy), Options: &options}
+}
+
+func TestCloseStrategy_RSIWithMinProfit(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	profitBot(t, ts,
+		WithBot(func(bot *tcmock.Bot) { bot.TakeProfit = nil }),
+		WithCloseStrategies(closeStrategy(tcmock.Rsi, map[string]interface{}{"points": "70", "trigger_condition": "greater"})),
+		WithMinProfit("1", tcmock.BotMinProfitTypeBaseOrderVolume),
+	)
+	sendSignal(t, ts, startSignal)
+
+	// Without a take profit only the close strategy ends the deal
+	ts.SetMarketPrice("USDT_BTC", "51000")
+	ts.SetIndicatorValue("USDT_BTC", "rsi", "65")
+	if deal, _ := ts.GetDealByID(1); deal.Status != "bought" {
+		t.Fatalf("expected the deal to stay open, got %s", deal.Status)
+	}
+
+	// A profit of 0.6 is below 1% of the base order
+	ts.SetMarketPrice("USDT_BTC", "50300")
+	ts.SetIndicatorValue("USDT_BTC", "rsi", "75")
+	if deal, _ := ts.GetDealByID(1); deal.Status != "bought" {
+		t.Fatalf("expected the min profit to hold the deal open, got %s", deal.Status)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "50600")
+	deal, _ := ts.GetDealByID(1)
+	if deal.Status != "completed" || deal.FinalProfit != "1.2" {
+		t.Fatalf("expected the deal to close on the signal with profit 1.2, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if msg := lastBotEvent(t, ts, 1); !strings.HasPrefix(msg, "Close condition met (rsi). Price: 50600 USDT") {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+}
+
+func TestCloseStrategy_ComputedRSI(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	// The price alternates before start and climbs 100 a minute after it
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ts.SetClock(start)
+	ts.SetPriceFunc("USDT_BTC", func(now time.Time) string {
+		m := int(now.Sub(start) / time.Minute)
+		if m <= 0 {
+			if m%2 != 0 {
+				return "50100"
+			}
+			return "50000"
+		}
+		return strconv.Itoa(50000 + 100*m)
+	})
+	ts.SetDealSimulation(true)
+	profitBot(t, ts, WithCloseStrategies(
+		closeStrategy(tcmock.Rsi, map[string]interface{}{"time": "1m", "points": "70", "trigger_condition": "greater"}),
+	))
+	sendSignal(t, ts, startSignal)
+
+	// RSI-7 is 42.86 at the start and 57.14 after two minutes
+	ts.AdvanceClock(2 * time.Minute)
+	if deal, _ := ts.GetDealByID(1); deal.Status != "bought" {
+		t.Fatalf("expected the deal to stay open, got %s", deal.Status)
+	}
+
+	// 71.43 after three minutes
+	ts.AdvanceClock(time.Minute)
+	deal, _ := ts.GetDealByID(1)
+	if deal.Status != "completed" || deal.SoldAveragePrice != "50300" {
+		t.Fatalf("expected the computed RSI to close the deal at 50300, got %s %s", deal.Status, deal.SoldAveragePrice)
+	}
+}
+
+func TestCloseStrategy_CustomEvaluator(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	ts.SetCloseEvaluator("tv_custom_signal", func(input CloseInput) bool {
+		return input.Value == "exit"
+	})
+	profitBot(t, ts, WithCloseStrategies(
+		closeStrategy(tcmock.TradingView, map[string]interface{}{"type": "strong"}),
+		closeStrategy("tv_custom_signal", nil),
+	))
+	sendSignal(t, ts, startSignal)
+
+	// Every strategy has to signal
+	ts.SetIndicatorValue("USDT_BTC", "trading_view", "sell")
+	ts.SetIndicatorValue("USDT_BTC", "tv_custom_signal", "exit")
+	if deal, _ := ts.GetDealByID(1); deal.Status != "bought" {
+		t.Fatalf("expected a plain sell not to satisfy type strong, got %s", deal.Status)
+	}
+
+	ts.SetIndicatorValue("USDT_BTC", "trading_view", "strong_sell")
+	deal, _ := ts.GetDealByID(1)
+	if deal.Status != "completed" || deal.FinalProfit != "0" {
+		t.Fatalf("expected the deal to close without min profit, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if msg := lastBotEvent(t, ts, 1); !strings.HasPrefix(msg, "Close condition met (trading_view, tv_custom_signal).") {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// copyBotRequest is the body of POST /ver1/bots/copy_and_create
+// Amount shadows the generated float32 field so it compares exactly against
+// the required amount; account_id is optional and defaults to the account of
+// the shared bot
+type copyBotRequest struct {
+	tcmock.CopyBotRequest
+	Amount    json.Number `json:"amount"`
+	AccountId *int        `json:"account_id,omitempty"`
+}
+
+// ShareBot generates a share secret for a bot
+// The secret is what 3Commas puts in the url_secret parameter of a share link
+// and is accepted by POST /ver1/bots/copy_and_create; earlier secrets stay valid
+func (ts *TestServer) ShareBot(botID int) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.bots[botID]; !ok {
+		return "", fmt.Errorf("bot %d not found", botID)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(buf)
+	ts.botSecrets[secret] = botID
+	return secret, nil
+}
+
+// botRequiredAmount returns the quote funds a bot needs to run all its deals to the last safety order
+// Per deal: base order + sum of safety orders scaled by the martingale volume coefficient
+// base_currency volumes are valued at price; false if they need a price that is unknown
+func botRequiredAmount(bot tcmock.Bot, price *big.Rat) (*big.Rat, bool) {
+	perDeal := new(big.Rat)
+	if bot.BaseOrderVolume != nil {
+		volumeType := ""
+		if bot.BaseOrderVolumeType != nil {
+			volumeType = string(*bot.BaseOrderVolumeType)
+		}
+		volume, ok := quoteVolume(volumeType, decimal.Parse(*bot.BaseOrderVolume), price)
+		if !ok {
+			return nil, false
+		}
+		perDeal.Add(perDeal, volume)
+	}
+	if bot.SafetyOrderVolume != nil && bot.MaxSafetyOrders != nil {
+		volumeType := ""
+		if bot.SafetyOrderVolumeType != nil {
+			volumeType = string(*bot.SafetyOrderVolumeType)
+		}
+		volume, ok := quoteVolume(volumeType, decimal.Parse(*bot.SafetyOrderVolume), price)
+		if !ok && *bot.MaxSafetyOrders > 0 {
+			return nil, false
+		}
+		coef := big.NewRat(1, 1)
+		if bot.MartingaleVolumeCoefficient != nil {
+			coef = decimal.Parse(*bot.MartingaleVolumeCoefficient)
+		}
+		for i := 0; i < *bot.MaxSafetyOrders; i++ {
+			perDeal.Add(perDeal, volume)
+			volume = new(big.Rat).Mul(volume, coef)
+		}
+	}
+
+	deals := 1
+	if bot.MaxActiveDeals != nil && *bot.MaxActiveDeals > 1 {
+		deals = *bot.MaxActiveDeals
+	}
+	return perDeal.Mul(perDeal, big.NewRat(int64(deals), 1)), true
+}
+
+// handleCopyAndCreate serves POST /ver1/bots/copy_and_create
+// Clones the bot shared under secret into a new disabled bot
+// amount must cover the bot's required amount and, if a balance is configured,
+// be available on the target account
+func (ts *TestServer) handleCopyAndCreate(w http.ResponseWriter, r *http.Request) {
+	var req copyBotRequest
+	if _, ok := decodeJSONBody(w, r, &req); !ok {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	attrs := map[string][]string{}
+	if req.Name == "" {
+		attrs["name"] = []string{"can't be blank"}
+	}
+	source := ts.bots[ts.botSecrets[req.Secret]]
+	if _, shared := ts.botSecrets[req.Secret]; !shared || source == nil {
+		attrs["secret"] = []string{"is invalid"}
+	}
+	if len(attrs) > 0 {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", attrs)
+		return
+	}
+
+	bot := copyBot(*source)
+	amount := decimal.Parse(req.Amount.String())
+
+	accountID := bot.AccountId
+	if req.AccountId != nil {
+		accountID = *req.AccountId
+	}
+	if _, known := ts.accounts[accountID]; !known && len(ts.accounts) > 0 {
+		attrs["account_id"] = []string{"is invalid"}
+	}
+	pair, price := "", (*big.Rat)(nil)
+	if len(bot.Pairs) > 0 {
+		pair = bot.Pairs[0]
+		price, _ = ts.marketPriceLocked(ts.accountMarketLocked(accountID), pair)
+	}
+	required, priced := botRequiredAmount(bot, price)
+	if !priced {
+		attrs["pairs"] = []string{"Market price is unknown for " + pair}
+	} else if amount.Cmp(required) < 0 {
+		attrs["amount"] = []string{fmt.Sprintf("must be greater than or equal to %s", decimal.Format(required))}
+	} else if pair != "" {
+		quote, _ := splitPair(pair)
+		if err := ts.checkFundsLocked(accountID, quote, amount, "amount"); err != nil {
+			attrs[err.Field] = []string{err.Message}
+		}
+	}
+	if len(attrs) > 0 {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", attrs)
+		return
+	}
+
+	now := ts.nowLocked()
+	bot.Id = ts.nextBotIDLocked()
+	bot.Name = ptr(req.Name)
+	bot.AccountId = accountID
+	if name, ok := ts.accountNameLocked(accountID); ok {
+		bot.AccountName = name
+	}
+	bot.IsEnabled = false
+	bot.ActiveDeals = []tcmock.Deal{}
+	bot.ActiveDealsCount = 0
+	bot.ActiveDealsBtcProfit = "0"
+	bot.ActiveDealsUsdProfit = "0"
+	bot.BtcFundsLockedInActiveDeals = "0"
+	bot.FundsLockedInActiveDeals = "0"
+	bot.FinishedDealsCount = "0"
+	bot.FinishedDealsProfitUsd = "0"
+	bot.CreatedAt = now
+	bot.UpdatedAt = now
+	ts.bots[bot.Id] = &bot
+
+	// The generated response uses float32 for both fields
+	requiredAmount, _ := required.Float32()
+	writeJSON(w, http.StatusCreated, tcmock.CopyBotResponse{
+		BotId:             ptr(float32(bot.Id)),
+		BotRequiredAmount: &requiredAmount,
+	})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// templateBot returns a bot needing 3 x (10 + 20 + 40) = 210 USDT
+func templateBot() tcmock.Bot {
+	bot := NewBot(1, "Template", 1, true)
+	bot.Pairs = tcmock.Pairs{"USDT_BTC"}
+	bot.BaseOrderVolume = ptr("10")
+	bot.SafetyOrderVolume = ptr("20")
+	bot.MaxSafetyOrders = ptr(2)
+	bot.MartingaleVolumeCoefficient = ptr("2")
+	bot.MaxActiveDeals = ptr(3)
+	bot.TakeProfit = ptr("1.5")
+	return bot
+}
+
+func copyAndCreate(t *testing.T, ts *TestServer, body string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Post(ts.URL()+"/ver1/bots/copy_and_create", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to POST copy_and_create: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var raw json.RawMessage
+	json.NewDecoder(resp.Body).Decode(&raw)
+	return resp, raw
+}
+
+func TestCopyAndCreate(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(1, "Template Account", "binance"))
+	ts.AddAccount(NewAccount(2, "Customer", "binance"))
+	ts.AddBot(templateBot())
+	secret, err := ts.ShareBot(1)
+	if err != nil {
+		t.Fatalf("failed to share bot: %v", err)
+	}
+
+	resp, body := copyAndCreate(t, ts, `{"name": "Copy", "secret": "`+secret+`", "amount": 210, "account_id": 2}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var copied tcmock.CopyBotResponse
+	if err := json.Unmarshal(body, &copied); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if *copied.BotId != 2 || *copied.BotRequiredAmount != 210 {
+		t.Fatalf("expected bot 2 requiring 210, got %v / %v", *copied.BotId, *copied.BotRequiredAmount)
+	}
+
+	bot, ok := ts.GetBot(2)
+	if !ok {
+		t.Fatal("copied bot should be stored")
+	}
+	if *bot.Name != "Copy" || bot.AccountId != 2 || bot.AccountName != "Customer" || bot.IsEnabled {
+		t.Fatalf("unexpected copy: name=%s account=%d/%s enabled=%v", *bot.Name, bot.AccountId, bot.AccountName, bot.IsEnabled)
+	}
+	if *bot.TakeProfit != "1.5" || *bot.SafetyOrderVolume != "20" {
+		t.Fatal("copy should keep the template settings")
+	}
+}
+
+func TestCopyAndCreate_Errors(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(1, "Main", "binance"))
+	ts.SetBalance(1, "USDT", "100")
+	ts.AddBot(templateBot())
+	secret, _ := ts.ShareBot(1)
+
+	tests := []struct {
+		body  string
+		field string
+		want  string
+	}{
+		{body: `{"name": "Copy", "secret": "nope", "amount": 500}`, field: "secret", want: "is invalid"},
+		{body: `{"secret": "` + secret + `", "amount": 500}`, field: "name", want: "can't be blank"},
+		{body: `{"name": "Copy", "secret": "` + secret + `", "amount": 209.99}`, field: "amount", want: "must be greater than or equal to 210"},
+		{body: `{"name": "Copy", "secret": "` + secret + `", "amount": 250}`, field: "amount", want: "Insufficient funds. Available: 100 USDT"},
+		{body: `{"name": "Copy", "secret": "` + secret + `", "amount": 210, "account_id": 9}`, field: "account_id", want: "is invalid"},
+	}
+	for _, tt := range tests {
+		resp, body := copyAndCreate(t, ts, tt.body)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", tt.body, resp.StatusCode)
+			continue
+		}
+		var errResp tcmock.ErrorResponse
+		json.Unmarshal(body, &errResp)
+		if got := (*errResp.ErrorAttributes)[tt.field]; len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: expected %s %q, got %v", tt.body, tt.field, tt.want, *errResp.ErrorAttributes)
+		}
+	}
+	if len(ts.GetAllBots()) != 1 {
+		t.Fatal("failed copies should not create bots")
+	}
+}
+
+func TestBotRequiredAmount_BaseCurrency(t *testing.T) {
+	bot := NewBot(1, "Bot", 1, true,
+		WithBaseOrder("0.001", "base_currency"),
+		WithSafetyOrders(SafetyOrders{Max: 2, Volume: "0.002", VolumeType: "base_currency", StepPercentage: "1"}),
+	)
+	bot.MartingaleVolumeCoefficient = ptr("1")
+
+	// (0.001 + 2 * 0.002) BTC at 50000
+	if required, ok := botRequiredAmount(bot, decimal.Parse("50000")); !ok || decimal.Format(required) != "250" {
+		t.Fatalf("expected 250 USDT required, got %v (%v)", required, ok)
+	}
+	if _, ok := botRequiredAmount(bot, nil); ok {
+		t.Fatal("expected base currency volumes to need a price")
+	}
+}
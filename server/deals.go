@@ -0,0 +1,382 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// addFundsRequest is the body of POST /ver1/deals/{deal_id}/add_funds
+// Numbers may be sent as JSON numbers or strings
+type addFundsRequest struct {
+	Quantity     json.Number `json:"quantity"`
+	IsMarket     bool        `json:"is_market"`
+	Rate         json.Number `json:"rate"`
+	ResponseType string      `json:"response_type"`
+}
+
+// dealUpdateRequest is the body of PATCH /ver1/deals/{deal_id}/update_deal
+// The percentages shadow the generated float32 fields so they keep their
+// exact decimal value
+type dealUpdateRequest struct {
+	tcmock.DealUpdateRequest
+	TakeProfit          *json.Number `json:"take_profit,omitempty"`
+	StopLossPercentage  *json.Number `json:"stop_loss_percentage,omitempty"`
+	MinProfitPercentage *json.Number `json:"min_profit_percentage,omitempty"`
+	TrailingDeviation   *json.Number `json:"trailing_deviation,omitempty"`
+}
+
+// lookupDealLocked finds the deal of a {deal_id} request
+// Writes the error response and returns nil if the ID is invalid, an error is
+// configured for the deal or the deal does not exist
+// The caller must hold ts.mu
+func (ts *TestServer) lookupDealLocked(w http.ResponseWriter, r *http.Request) *tcmock.Deal {
+	dealID, err := pathInt(r, "deal_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return nil
+	}
+	if err := ts.dealErrors[dealID]; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), "", nil)
+		return nil
+	}
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "deal not found", "", nil)
+		return nil
+	}
+	return deal
+}
+
+// handleDataForAddingFunds serves GET /ver1/deals/{deal_id}/data_for_adding_funds
+func (ts *TestServer) handleDataForAddingFunds(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	deal := ts.lookupDealLocked(w, r)
+	if deal == nil {
+		return
+	}
+
+	limits := tcmock.ExchangeTradingLimits{}
+	orderbookPrice := deal.CurrentPrice
+	if entry, ok := ts.dealMarketLocked(deal); ok {
+		limits = entry.limits
+		orderbookPrice = entry.rates.OrderbookAsk
+	}
+	if limits.LotStep == nil {
+		limits.LotStep = ptr("0.00000001")
+	}
+	if limits.PriceStep == nil {
+		limits.PriceStep = ptr("0.00000001")
+	}
+
+	writeJSON(w, http.StatusOK, tcmock.DealDataForAddingFundsResponse{
+		AccountId:              &deal.AccountId,
+		AddingFundsCurrency:    &deal.FromCurrency,
+		BaseCurrency:           &deal.ToCurrency,
+		QuoteCurrency:          &deal.FromCurrency,
+		DealType:               ptr(deal.Type),
+		IsContract:             ptr(deal.MarketType == "futures"),
+		LeverageType:           ptr(deal.LeverageType),
+		LeverageCustomValue:    deal.LeverageCustomValue,
+		Limits:                 &limits,
+		MarketBuyMinTotal:      limits.MarketBuyMinTotal,
+		MarketSupported:        ptr(true),
+		MinLotSize:             limits.MinLotSize,
+		OrderbookPrice:         &orderbookPrice,
+		OrderbookPriceCurrency: &deal.OrderbookPriceCurrency,
+		Pair:                   &deal.Pair,
+		TakeProfitPrice:        &deal.TakeProfitPrice,
+		StopLossPrice:          nullable.NewNullableWithValue(deal.StopLossPrice),
+	})
+}
+
+// handleAddFunds serves POST /ver1/deals/{deal_id}/add_funds
+// quantity is in base currency; market orders fill at the current price, limit
+// orders at rate are placed as active manual safety orders
+// The order is rounded to the pair's lot and price steps and validated against
+// its trading limits
+func (ts *TestServer) handleAddFunds(w http.ResponseWriter, r *http.Request) {
+	var req addFundsRequest
+	if _, ok := decodeJSONBody(w, r, &req); !ok {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal := ts.lookupDealLocked(w, r)
+	if deal == nil {
+		return
+	}
+	if !isActiveDeal(deal) {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Deal is not active", nil)
+		return
+	}
+	if req.Quantity == "" {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", map[string][]string{
+			"quantity": {"is missing"},
+		})
+		return
+	}
+
+	var price *big.Rat
+	if req.IsMarket {
+		price = decimal.Parse(deal.CurrentPrice)
+		if entry, ok := ts.dealMarketLocked(deal); ok {
+			price = decimal.Parse(entry.rates.Last)
+		}
+		if price.Sign() <= 0 {
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, "Market price is unknown for "+deal.Pair, nil)
+			return
+		}
+	} else {
+		if req.Rate == "" {
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", map[string][]string{
+				"rate": {"is missing"},
+			})
+			return
+		}
+		price = decimal.Parse(req.Rate.String())
+	}
+
+	limits := ts.dealLimitsLocked(deal)
+	amount, price, orderErr := limits.checkOrder("Order", "quantity", decimal.Parse(req.Quantity.String()), price)
+	if orderErr != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", orderErr.attributes())
+		return
+	}
+	total := new(big.Rat).Mul(amount, price)
+	if fundsErr := ts.checkFundsLocked(deal.AccountId, deal.FromCurrency, orderMargin(deal, total), "quantity"); fundsErr != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", fundsErr.attributes())
+		return
+	}
+
+	now := ts.nowLocked()
+	size := orderSize(deal, amount, price)
+	status := tcmock.Active
+	if req.IsMarket {
+		entryFills(deal).add(amount, price)
+		deal.CompletedManualSafetyOrdersCount++
+		addBotEventAt(deal, "Manual averaging order executed. "+size, now)
+		status = tcmock.Filled
+		ts.updateActualProfitLocked(deal, decimal.Parse(deal.CurrentPrice))
+	} else {
+		deal.ActiveManualSafetyOrders++
+		addBotEventAt(deal, "Placing manual averaging order. "+size, now)
+	}
+	deal.UpdatedAt = now
+	ts.dealChangedLocked(DealBotEventAdded, deal, "")
+
+	if req.ResponseType == "market_order" {
+		remaining := "0"
+		if !req.IsMarket {
+			remaining = decimal.Format(amount)
+		}
+		writeJSON(w, http.StatusOK, tcmock.MarketOrder{
+			OrderId:           strconv.Itoa(len(deal.BotEvents)),
+			OrderType:         tcmock.BUY,
+			DealOrderType:     tcmock.MarketOrderDealOrderTypeManualSafety,
+			StatusString:      status,
+			Quantity:          decimal.Format(amount),
+			QuantityRemaining: remaining,
+			Rate:              decimal.Format(price),
+			AveragePrice:      decimal.Format(price),
+			Total:             decimal.Format(total),
+			Cancellable:       !req.IsMarket,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, ts.dealResponseLocked(deal))
+}
+
+// handleUpdateDeal serves PATCH /ver1/deals/{deal_id}/update_deal
+// Only the fields present in the request change; take_profit_steps replace
+// the pending steps and take_profit must then be 0
+func (ts *TestServer) handleUpdateDeal(w http.ResponseWriter, r *http.Request) {
+	var req dealUpdateRequest
+	if _, ok := decodeJSONBody(w, r, &req); !ok {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal := ts.lookupDealLocked(w, r)
+	if deal == nil {
+		return
+	}
+	if !isActiveDeal(deal) {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Deal is not active", nil)
+		return
+	}
+
+	attrs := map[string][]string{}
+	usesSteps := req.TakeProfitSteps != nil && len(*req.TakeProfitSteps) > 0
+	var takeProfit *big.Rat
+	if req.TakeProfit != nil {
+		takeProfit = decimal.Parse(req.TakeProfit.String())
+		if usesSteps && takeProfit.Sign() != 0 {
+			attrs["take_profit"] = []string{"must be 0 when take_profit_steps are used"}
+		}
+	}
+	if req.MaxSafetyOrders != nil && *req.MaxSafetyOrders < deal.CompletedSafetyOrdersCount {
+		attrs["max_safety_orders"] = []string{fmt.Sprintf("must be greater than or equal to %d", deal.CompletedSafetyOrdersCount)}
+	}
+	if len(attrs) > 0 {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", attrs)
+		return
+	}
+	if usesSteps {
+		if err := ts.setTakeProfitStepsLocked(deal, *req.TakeProfitSteps); err != nil {
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", err.attributes())
+			return
+		}
+	} else if takeProfit != nil && takeProfit.Sign() > 0 {
+		// A single take profit replaces the pending steps
+		kept := []dealTakeProfitStep{}
+		for _, step := range deal.TakeProfitSteps {
+			if step.Status != nil && *step.Status == takeProfitStepFinished {
+				kept = append(kept, step)
+			}
+		}
+		deal.TakeProfitSteps = kept
+		deal.TakeProfit = nullable.NewNullableWithValue(decimal.Format(takeProfit))
+	}
+
+	if req.TakeProfitType != "" {
+		deal.TakeProfitType = tcmock.DealTakeProfitType(req.TakeProfitType)
+	}
+	if req.MaxSafetyOrders != nil {
+		deal.MaxSafetyOrders = *req.MaxSafetyOrders
+	}
+	if req.ActiveSafetyOrdersCount != nil {
+		deal.ActiveSafetyOrdersCount = *req.ActiveSafetyOrdersCount
+	}
+	if req.StopLossPercentage != nil {
+		deal.StopLossPercentage = decimal.Format(decimal.Parse(req.StopLossPercentage.String()))
+	}
+	if req.StopLossType != nil {
+		deal.StopLossType = string(*req.StopLossType)
+	}
+	if req.StopLossTimeoutEnabled != nil {
+		deal.StopLossTimeoutEnabled = *req.StopLossTimeoutEnabled
+	}
+	if req.StopLossTimeoutInSeconds != nil {
+		deal.StopLossTimeoutInSeconds = *req.StopLossTimeoutInSeconds
+	}
+	if req.SlToBreakevenEnabled != nil {
+		deal.SlToBreakevenEnabled = *req.SlToBreakevenEnabled
+	}
+	if req.SlToBreakevenData != nil && req.SlToBreakevenData.UpperBreakevenLimit != nil {
+		deal.SlToBreakevenData = nullable.NewNullableWithValue(map[string]interface{}{
+			"upper_breakeven_limit": *req.SlToBreakevenData.UpperBreakevenLimit,
+		})
+	}
+	if req.TrailingEnabled != nil {
+		deal.TrailingEnabled = *req.TrailingEnabled
+	}
+	if req.TrailingDeviation != nil {
+		ts.dealExtensionLocked(deal).TrailingDeviation = decimal.Format(decimal.Parse(req.TrailingDeviation.String()))
+	}
+	if req.TslEnabled != nil {
+		deal.TslEnabled = *req.TslEnabled
+	}
+	if req.MinProfitPercentage != nil {
+		deal.MinProfitPercentage = decimal.Format(decimal.Parse(req.MinProfitPercentage.String()))
+	}
+	if req.ProfitCurrency != nil {
+		deal.ProfitCurrency = string(*req.ProfitCurrency)
+	}
+	if req.Note != nil {
+		deal.Note = nullable.NewNullableWithValue(*req.Note)
+	}
+
+	deal.UpdatedAt = ts.nowLocked()
+	ts.updateTakeProfitPriceLocked(deal)
+	ts.dealChangedLocked(DealUpdated, deal, "")
+	if price, ok := ts.repriceDealLocked(deal); ok && ts.simulateDeals {
+		ts.simulateDealLocked(deal, price)
+	}
+	writeJSON(w, http.StatusOK, ts.dealResponseLocked(deal))
+}
+
+// averagePriceStep is the precision of computed average prices
+var averagePriceStep = big.NewRat(1, 100000000)
+
+// orderSize describes an order the way 3Commas bot events do
+// Example: "Price: 25000 USDT Size: 10 USDT (0.0004 BTC)"
+func orderSize(deal *tcmock.Deal, amount, price *big.Rat) string {
+	return fmt.Sprintf("Price: %s %s Size: %s %s (%s %s)",
+		decimal.Format(price), deal.FromCurrency,
+		decimal.Format(new(big.Rat).Mul(amount, price)), deal.FromCurrency,
+		decimal.Format(amount), deal.ToCurrency)
+}
+
+// sortDeals orders listed deals by order (created_at by default) in direction (DESC by default)
+// Profits compare as exact decimals: final_profit for finished deals, actual_profit
+// for open ones; ties fall back to the deal ID
+func sortDeals(deals []DealResponse, order *tcmock.ListDealsParamsOrder, direction *tcmock.ListDealsParamsOrderDirection) {
+	by := tcmock.ListDealsParamsOrderCreatedAt
+	if order != nil {
+		by = *order
+	}
+	desc := direction == nil || *direction != tcmock.ListDealsParamsOrderDirectionASC
+
+	closedAt := func(deal *tcmock.Deal) time.Time {
+		at, _ := deal.ClosedAt.Get()
+		return at
+	}
+	cmp := func(a, b *tcmock.Deal) int {
+		switch by {
+		case tcmock.ListDealsParamsOrderUpdatedAt:
+			return a.UpdatedAt.Compare(b.UpdatedAt)
+		case tcmock.ListDealsParamsOrderClosedAt:
+			return closedAt(a).Compare(closedAt(b))
+		case tcmock.ListDealsParamsOrderProfit:
+			return decimal.Compare(listedProfit(a), listedProfit(b))
+		case tcmock.ListDealsParamsOrderProfitPercentage:
+			return decimal.Compare(listedProfitPercentage(a), listedProfitPercentage(b))
+		}
+		return a.CreatedAt.Compare(b.CreatedAt)
+	}
+
+	slices.SortStableFunc(deals, func(a, b DealResponse) int {
+		c := cmp(&a.Deal, &b.Deal)
+		if c == 0 {
+			c = a.Id - b.Id
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+// listedProfit returns the profit a deal is ordered by
+func listedProfit(deal *tcmock.Deal) string {
+	if isActiveDeal(deal) {
+		profit, _ := deal.ActualProfit.Get()
+		return profit
+	}
+	return deal.FinalProfit
+}
+
+// listedProfitPercentage returns the profit percentage a deal is ordered by
+func listedProfitPercentage(deal *tcmock.Deal) string {
+	if isActiveDeal(deal) {
+		return deal.ActualProfitPercentage
+	}
+	return deal.FinalProfitPercentage
+}
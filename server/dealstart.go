@@ -0,0 +1,288 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Deal-start engine
+//
+// Before a bot opens a deal on a pair, canStartDealLocked checks, in order: the
+// bot is enabled, max_active_deals, allowed_deals_on_same_pair, the per-pair
+// cooldown since the last closed deal, min_volume_btc_24h against the pair's 24h
+// BTC volume, min_price/max_price against the last price and
+// min_price_percentage/max_price_percentage against the 24h price change
+//
+// Bots with deal_start_delay_seconds schedule the start instead; it counts as an
+// active deal and opens once the mock clock reaches it, if the checks still pass.
+// Bots with disable_after_deals_count count it down for every deal they start and
+// disable themselves when it reaches zero
+
+// pendingDealStart is a deal start waiting out its bot's deal_start_delay_seconds
+type pendingDealStart struct {
+	botID int
+	pair  string
+	at    time.Time
+}
+
+// SetMarketVolume sets the 24h volume of a pair in BTC, checked against min_volume_btc_24h
+// Pairs without a volume pass the check
+func (ts *TestServer) SetMarketVolume(pair, volumeBtc string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.marketEntryLocked("", pair).volumeBtc24h = volumeBtc
+}
+
+// SetMarketDayOpen sets the price of a pair 24 hours ago, the base of the 24h price change
+// Without it, animated pairs evaluate their PriceFunc 24 hours back on the mock
+// clock; other pairs pass the min/max_price_percentage checks
+func (ts *TestServer) SetMarketDayOpen(pair, price string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.marketEntryLocked("", pair).dayOpen = price
+}
+
+// CanStartDeal reports whether a bot may open a new deal on pair right now
+// Returns why the deal can't start, or "" if it can
+func (ts *TestServer) CanStartDeal(botID int, pair string) (string, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	bot, ok := ts.bots[botID]
+	if !ok {
+		return "", fmt.Errorf("bot %d not found", botID)
+	}
+	return ts.canStartDealLocked(bot, pair), nil
+}
+
+// StartDeal asks a bot to open a deal on pair at the market price, as a start condition firing would
+// Returns the deal, or false when the start was scheduled after deal_start_delay_seconds
+// Refused starts return an error carrying the reason
+func (ts *TestServer) StartDeal(botID int, pair string) (tcmock.Deal, bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	bot, ok := ts.bots[botID]
+	if !ok {
+		return tcmock.Deal{}, false, fmt.Errorf("bot %d not found", botID)
+	}
+	deal, _, err := ts.requestDealStartLocked(bot, pair)
+	if err != nil {
+		return tcmock.Deal{}, false, fmt.Errorf("%s", err.Message)
+	}
+	if deal == nil {
+		return tcmock.Deal{}, false, nil
+	}
+	return *deal, true, nil
+}
+
+// requestDealStartLocked starts a deal for a bot on pair through the engine
+// Returns the opened deal, or the time a delayed start is scheduled for
+// The caller must hold ts.mu
+func (ts *TestServer) requestDealStartLocked(bot *tcmock.Bot, pair string) (*tcmock.Deal, time.Time, *orderError) {
+	if reason := ts.canStartDealLocked(bot, pair); reason != "" {
+		return nil, time.Time{}, &orderError{Message: reason}
+	}
+	price, ok := ts.marketPriceLocked(ts.accountMarketLocked(bot.AccountId), pair)
+	if !ok {
+		return nil, time.Time{}, &orderError{Field: "pair", Message: "Market price is unknown for " + pair}
+	}
+
+	if bot.DealStartDelaySeconds != nil && *bot.DealStartDelaySeconds > 0 {
+		at := ts.nowLocked().Add(time.Duration(*bot.DealStartDelaySeconds) * time.Second)
+		ts.pendingStarts = append(ts.pendingStarts, pendingDealStart{botID: bot.Id, pair: pair, at: at})
+		return nil, at, nil
+	}
+	deal, err := ts.startDealLocked(bot, pair, price)
+	return deal, time.Time{}, err
+}
+
+// startDealLocked opens a deal and counts it against the bot's disable_after_deals_count
+// The caller must hold ts.mu
+func (ts *TestServer) startDealLocked(bot *tcmock.Bot, pair string, price *big.Rat) (*tcmock.Deal, *orderError) {
+	deal, err := ts.openDealLocked(bot, pair, price)
+	if err != nil {
+		return nil, err
+	}
+
+	if bot.DisableAfterDealsCount != nil && *bot.DisableAfterDealsCount > 0 {
+		left := *bot.DisableAfterDealsCount - 1
+		bot.DisableAfterDealsCount = &left
+		if left == 0 {
+			bot.IsEnabled = false
+			addBotEventAt(deal, "Bot disabled after reaching the deals limit.", ts.nowLocked())
+			ts.dealChangedLocked(DealBotEventAdded, deal, "")
+		}
+		bot.UpdatedAt = ts.nowLocked()
+	}
+	return deal, nil
+}
+
+// runPendingDealStartsLocked opens the delayed deal starts the mock clock has reached
+// Each start is checked again; refused ones are dropped
+// The caller must hold ts.mu
+func (ts *TestServer) runPendingDealStartsLocked() {
+	now := ts.nowLocked()
+	for i := 0; i < len(ts.pendingStarts); {
+		start := ts.pendingStarts[i]
+		if start.at.After(now) {
+			i++
+			continue
+		}
+		ts.pendingStarts = append(ts.pendingStarts[:i], ts.pendingStarts[i+1:]...)
+
+		bot, ok := ts.bots[start.botID]
+		if !ok || ts.canStartDealLocked(bot, start.pair) != "" {
+			continue
+		}
+		if price, ok := ts.marketPriceLocked(ts.accountMarketLocked(bot.AccountId), start.pair); ok {
+			ts.startDealLocked(bot, start.pair, price)
+		}
+	}
+}
+
+// canStartDealLocked checks the deal-start conditions of a bot for a new deal on pair
+// Returns why the deal can't start, or "" if it can
+// The caller must hold ts.mu
+func (ts *TestServer) canStartDealLocked(bot *tcmock.Bot, pair string) string {
+	if !bot.IsEnabled {
+		return "Bot is disabled"
+	}
+	if reason := ts.checkDealLimitsLocked(bot, pair); reason != "" {
+		return reason
+	}
+	return ts.checkMarketConditionsLocked(bot, pair)
+}
+
+// checkDealLimitsLocked checks a bot's deal limits and cooldown for a new deal on pair
+// Scheduled starts count as active deals
+// The caller must hold ts.mu
+func (ts *TestServer) checkDealLimitsLocked(bot *tcmock.Bot, pair string) string {
+	maxActive := 1
+	if bot.MaxActiveDeals != nil {
+		maxActive = *bot.MaxActiveDeals
+	}
+	maxOnPair := 1
+	if bot.AllowedDealsOnSamePair != nil {
+		maxOnPair = *bot.AllowedDealsOnSamePair
+	}
+	cooldown := time.Duration(0)
+	if bot.Cooldown != nil {
+		if seconds, err := strconv.Atoi(*bot.Cooldown); err == nil {
+			cooldown = time.Duration(seconds) * time.Second
+		}
+	}
+
+	active, onPair := 0, 0
+	var lastClosed time.Time
+	for _, deal := range ts.deals {
+		if deal.BotId != bot.Id {
+			continue
+		}
+		if isActiveDeal(deal) {
+			active++
+			if deal.Pair == pair {
+				onPair++
+			}
+			continue
+		}
+		if closedAt, err := deal.ClosedAt.Get(); err == nil && deal.Pair == pair && closedAt.After(lastClosed) {
+			lastClosed = closedAt
+		}
+	}
+	for _, start := range ts.pendingStarts {
+		if start.botID == bot.Id {
+			active++
+			if start.pair == pair {
+				onPair++
+			}
+		}
+	}
+
+	switch {
+	case active >= maxActive:
+		return fmt.Sprintf("Max active deals limit reached (%d)", maxActive)
+	case onPair >= maxOnPair:
+		return fmt.Sprintf("Max deals on the same pair reached (%d)", maxOnPair)
+	case cooldown > 0 && !lastClosed.IsZero() && ts.nowLocked().Before(lastClosed.Add(cooldown)):
+		return fmt.Sprintf("Cooldown between deals is active until %s", lastClosed.Add(cooldown).UTC().Format(time.RFC3339))
+	}
+	return ""
+}
+
+// checkMarketConditionsLocked checks a bot's volume and price conditions against the market data of pair
+// The caller must hold ts.mu
+func (ts *TestServer) checkMarketConditionsLocked(bot *tcmock.Bot, pair string) string {
+	entry, ok := ts.lookupMarketLocked(ts.accountMarketLocked(bot.AccountId), pair)
+	if !ok {
+		return ""
+	}
+	volumeBtc24h, dayOpen := entry.volumeBtc24h, entry.dayOpen
+	if base, ok := ts.markets[marketKey{Pair: pair}]; ok {
+		// SetMarketVolume and SetMarketDayOpen fill in for exchange-specific entries
+		volumeBtc24h, dayOpen = cmp.Or(volumeBtc24h, base.volumeBtc24h), cmp.Or(dayOpen, base.dayOpen)
+	}
+
+	if bot.MinVolumeBtc24h != nil && volumeBtc24h != "" {
+		minVolume, volume := decimal.Parse(*bot.MinVolumeBtc24h), decimal.Parse(volumeBtc24h)
+		if minVolume.Sign() > 0 && volume.Cmp(minVolume) < 0 {
+			return fmt.Sprintf("24h volume %s BTC is below the minimum of %s BTC", decimal.Format(volume), decimal.Format(minVolume))
+		}
+	}
+
+	last := decimal.Parse(entry.rates.Last)
+	if bot.MinPrice != nil && *bot.MinPrice > 0 {
+		if minPrice := decimal.FromFloat32(*bot.MinPrice); last.Cmp(minPrice) < 0 {
+			return fmt.Sprintf("Price %s is below the min price %s", decimal.Format(last), decimal.Format(minPrice))
+		}
+	}
+	if bot.MaxPrice != nil && *bot.MaxPrice > 0 {
+		if maxPrice := decimal.FromFloat32(*bot.MaxPrice); last.Cmp(maxPrice) > 0 {
+			return fmt.Sprintf("Price %s is above the max price %s", decimal.Format(last), decimal.Format(maxPrice))
+		}
+	}
+
+	if bot.MinPricePercentage == nil && bot.MaxPricePercentage == nil {
+		return ""
+	}
+	change, ok := ts.dayChangeLocked(entry, dayOpen)
+	if !ok {
+		return ""
+	}
+	if bot.MinPricePercentage != nil {
+		if minChange := decimal.FromFloat32(*bot.MinPricePercentage); change.Cmp(minChange) < 0 {
+			return fmt.Sprintf("24h price change %s%% is below the min of %s%%", decimal.FormatFixed(change, 2), decimal.Format(minChange))
+		}
+	}
+	if bot.MaxPricePercentage != nil {
+		if maxChange := decimal.FromFloat32(*bot.MaxPricePercentage); change.Cmp(maxChange) > 0 {
+			return fmt.Sprintf("24h price change %s%% is above the max of %s%%", decimal.FormatFixed(change, 2), decimal.Format(maxChange))
+		}
+	}
+	return ""
+}
+
+// dayChangeLocked returns the 24h price change of a market entry from dayOpen in percent
+// False when the price 24 hours ago is unknown
+// The caller must hold ts.mu
+func (ts *TestServer) dayChangeLocked(entry *marketEntry, dayOpen string) (*big.Rat, bool) {
+	open := decimal.Parse(dayOpen)
+	if dayOpen == "" && entry.priceFunc != nil {
+		open = decimal.Parse(entry.priceFunc(ts.nowLocked().Add(-24 * time.Hour)))
+	}
+	last := decimal.Parse(entry.rates.Last)
+	if open.Sign() <= 0 || last.Sign() <= 0 {
+		return nil, false
+	}
+	change := new(big.Rat).Sub(last, open)
+	change.Quo(change, open)
+	return change.Mul(change, big.NewRat(100, 1)), true
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDealStart_MarketConditions(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	profitBot(t, ts, WithDealStart(DealStart{
+		MinVolumeBtc24h:    "100",
+		MinPrice:           ptr(float32(45000)),
+		MaxPrice:           ptr(float32(55000)),
+		MinPricePercentage: ptr(float32(-5)),
+		MaxPricePercentage: ptr(float32(10)),
+	}))
+
+	checks := []struct {
+		name   string
+		setup  func()
+		reason string
+	}{
+		{"low volume", func() { ts.SetMarketVolume("USDT_BTC", "50") }, "24h volume 50 BTC is below the minimum of 100 BTC"},
+		{"enough volume", func() { ts.SetMarketVolume("USDT_BTC", "250.5") }, ""},
+		{"above max price", func() { ts.SetMarketPrice("USDT_BTC", "56000") }, "Price 56000 is above the max price 55000"},
+		{"below min price", func() { ts.SetMarketPrice("USDT_BTC", "44000") }, "Price 44000 is below the min price 45000"},
+		{"rallied", func() {
+			ts.SetMarketPrice("USDT_BTC", "50000")
+			ts.SetMarketDayOpen("USDT_BTC", "45000")
+		}, "24h price change 11.11% is above the max of 10%"},
+		{"dropped", func() { ts.SetMarketDayOpen("USDT_BTC", "55000") }, "24h price change -9.09% is below the min of -5%"},
+		{"within range", func() { ts.SetMarketDayOpen("USDT_BTC", "48000") }, ""},
+	}
+	for _, check := range checks {
+		check.setup()
+		if reason, err := ts.CanStartDeal(1, "USDT_BTC"); err != nil || reason != check.reason {
+			t.Fatalf("%s: expected %q, got %q (%v)", check.name, check.reason, reason, err)
+		}
+	}
+
+	// Signals are refused with the same reason
+	ts.SetMarketPrice("USDT_BTC", "56000")
+	resp, _, errResp := sendSignal(t, ts, startSignal)
+	if resp.StatusCode != http.StatusBadRequest || *errResp.ErrorDescription != "Price 56000 is above the max price 55000" {
+		t.Fatalf("expected the signal to be refused, got %d", resp.StatusCode)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	deal, opened, err := ts.StartDeal(1, "USDT_BTC")
+	if err != nil || !opened || deal.Pair != "USDT_BTC" {
+		t.Fatalf("expected a deal to start, got %v (%v)", opened, err)
+	}
+	if _, _, err := ts.StartDeal(1, "USDT_BTC"); err == nil || !strings.HasPrefix(err.Error(), "Max active deals limit reached") {
+		t.Fatalf("expected the deal limit to refuse a second start, got %v", err)
+	}
+}
+
+func TestDealStart_AnimatedDayChange(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	ts.SetClock(start)
+	ts.SetPriceFunc("USDT_BTC", func(now time.Time) string {
+		if now.Before(start) {
+			return "40000"
+		}
+		return "50000"
+	})
+	profitBot(t, ts, WithDealStart(DealStart{MaxPricePercentage: ptr(float32(20))}))
+
+	if reason, _ := ts.CanStartDeal(1, "USDT_BTC"); reason != "24h price change 25.00% is above the max of 20%" {
+		t.Fatalf("expected the 24h change from the price function to refuse, got %q", reason)
+	}
+	ts.AdvanceClock(24 * time.Hour)
+	if reason, _ := ts.CanStartDeal(1, "USDT_BTC"); reason != "" {
+		t.Fatalf("expected a flat day to pass, got %q", reason)
+	}
+}
+
+func TestDealStart_DelayAndDisableAfterDeals(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ts.SetClock(start)
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	profitBot(t, ts, WithDealStart(DealStart{StartDelaySeconds: 60, DisableAfterDealsCount: 1}))
+
+	resp, _, _ := sendSignal(t, ts, startSignal)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected the start to be scheduled, got %d", resp.StatusCode)
+	}
+	if _, ok := ts.GetDealByID(1); ok {
+		t.Fatal("expected no deal before the delay")
+	}
+	// The scheduled start counts as an active deal
+	if reason, _ := ts.CanStartDeal(1, "USDT_BTC"); reason != "Max active deals limit reached (1)" {
+		t.Fatalf("expected the scheduled start to count, got %q", reason)
+	}
+
+	ts.AdvanceClock(59 * time.Second)
+	if _, ok := ts.GetDealByID(1); ok {
+		t.Fatal("expected no deal before the delay")
+	}
+	ts.AdvanceClock(time.Second)
+	deal, ok := ts.GetDealByID(1)
+	if !ok || deal.CreatedAt != start.Add(time.Minute) {
+		t.Fatalf("expected the deal to open after 60s, got %v", deal.CreatedAt)
+	}
+
+	bot, _ := ts.GetBot(1)
+	if bot.IsEnabled || bot.DisableAfterDealsCount == nil || *bot.DisableAfterDealsCount != 0 {
+		t.Fatalf("expected the bot to disable itself after one deal, got enabled=%v", bot.IsEnabled)
+	}
+	if msg := lastBotEvent(t, ts, 1); msg != "Bot disabled after reaching the deals limit." {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+	if reason, _ := ts.CanStartDeal(1, "USDT_BTC"); reason != "Bot is disabled" {
+		t.Fatalf("expected the disabled bot to refuse, got %q", reason)
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Deal state machine
+//
+// UpdateDealStatus moves a deal along the 3Commas deal lifecycle: a deal is
+// created, places its base order and is bought; while bought it may wait on
+// safety or take profit orders, trail its take profit, or go through the
+// panic sell, cancel and stop loss flows, and it ends in one of the finished
+// statuses. Finished deals don't move again. Every move keeps finished,
+// closed_at, cancellable, panic_sellable, add_fundable and localized_status in
+// sync with the new status
+//
+// Deals whose status isn't a 3Commas status, like "active" fixtures, may move
+// to any known status. UpdateDealStatusRaw skips the state machine entirely
+
+// dealStatusInfo describes a 3Commas deal status
+type dealStatusInfo struct {
+	localized   string
+	finished    bool
+	cancellable bool
+	bought      bool // panic sellable and add fundable
+}
+
+// dealStatuses lists the 3Commas deal statuses
+var dealStatuses = map[tcmock.DealStatus]dealStatusInfo{
+	"created":                    {localized: "Created", cancellable: true},
+	"base_order_placed":          {localized: "Base order placed", cancellable: true},
+	"bought":                     {localized: "Bought", cancellable: true, bought: true},
+	"bought_safety_pending":      {localized: "Bought, safety pending", cancellable: true, bought: true},
+	"bought_take_profit_pending": {localized: "Bought, take profit pending", cancellable: true, bought: true},
+	"ttp_activated":              {localized: "Trailing take profit activated", cancellable: true, bought: true},
+	"ttp_order_placed":           {localized: "Trailing take profit order placed", cancellable: true, bought: true},
+	"stop_loss_pending":          {localized: "Stop loss pending", cancellable: true, bought: true},
+	"stop_loss_order_placed":     {localized: "Stop loss order placed"},
+	"panic_sell_pending":         {localized: "Panic sell pending"},
+	"panic_sell_order_placed":    {localized: "Panic sell order placed"},
+	"cancel_pending":             {localized: "Cancel pending"},
+	"switched_take_profit":       {localized: "Switched take profit", cancellable: true, bought: true},
+	"completed":                  {localized: "Completed", finished: true},
+	"cancelled":                  {localized: "Cancelled", finished: true},
+	"failed":                     {localized: "Failed", finished: true},
+	"panic_sold":                 {localized: "Panic sold", finished: true},
+	"stop_loss_finished":         {localized: "Stop loss finished", finished: true},
+	"switched":                   {localized: "Switched", finished: true},
+	"liquidated":                 {localized: "Liquidated", finished: true},
+}
+
+// boughtExits are the statuses a bought deal can leave to
+var boughtExits = []tcmock.DealStatus{
+	"bought", "bought_safety_pending", "bought_take_profit_pending", "ttp_activated", "ttp_order_placed",
+	"stop_loss_pending", "stop_loss_order_placed", "stop_loss_finished",
+	"panic_sell_pending", "panic_sell_order_placed", "panic_sold",
+	"cancel_pending", "cancelled", "completed", "failed", "switched", "switched_take_profit", "liquidated",
+}
+
+// dealTransitions lists the statuses each open status can move to
+var dealTransitions = map[tcmock.DealStatus][]tcmock.DealStatus{
+	"created":                    {"base_order_placed", "bought", "cancel_pending", "cancelled", "failed"},
+	"base_order_placed":          {"bought", "cancel_pending", "cancelled", "failed"},
+	"bought":                     boughtExits,
+	"bought_safety_pending":      boughtExits,
+	"bought_take_profit_pending": boughtExits,
+	"ttp_activated":              boughtExits,
+	"ttp_order_placed":           boughtExits,
+	"stop_loss_pending":          boughtExits,
+	"switched_take_profit":       boughtExits,
+	"stop_loss_order_placed":     {"stop_loss_finished", "bought", "failed"},
+	"panic_sell_pending":         {"panic_sell_order_placed", "panic_sold", "bought", "failed"},
+	"panic_sell_order_placed":    {"panic_sold", "failed"},
+	"cancel_pending":             {"cancelled", "bought", "failed"},
+}
+
+// checkDealTransition reports why a deal can't move from one status to another, or nil if it can
+func checkDealTransition(from, to tcmock.DealStatus) error {
+	if _, ok := dealStatuses[to]; !ok {
+		return fmt.Errorf("unknown deal status %q", to)
+	}
+	if from == to {
+		return nil
+	}
+	info, known := dealStatuses[from]
+	if !known {
+		return nil
+	}
+	if info.finished {
+		return fmt.Errorf("deal is already %s", from)
+	}
+	for _, next := range dealTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("deal can't move from %s to %s", from, to)
+}
+
+// setDealStatus sets a deal's status and the fields derived from it
+// Deals entering a finished status are closed at now
+func setDealStatus(deal *tcmock.Deal, status tcmock.DealStatus, now time.Time) {
+	deal.Status = status
+	deal.UpdatedAt = now
+	info, ok := dealStatuses[status]
+	if !ok {
+		return
+	}
+	deal.LocalizedStatus = info.localized
+	deal.Finished = info.finished
+	deal.Cancellable = info.cancellable
+	deal.PanicSellable = info.bought
+	deal.AddFundable = info.bought
+	if info.finished {
+		deal.SmartTradeConvertable = false
+		if !deal.ClosedAt.IsSpecified() || deal.ClosedAt.IsNull() {
+			deal.ClosedAt = nullable.NewNullableWithValue(now)
+		}
+	} else if deal.ClosedAt.IsSpecified() && !deal.ClosedAt.IsNull() {
+		deal.ClosedAt.SetNull()
+	}
+}
+
+// UpdateDealStatus moves a deal to a new status through the 3Commas deal state machine
+// Unknown statuses and illegal transitions are rejected; derived fields follow the status
+// Closing a deal releases its locked funds and books its final profit on the account
+func (ts *TestServer) UpdateDealStatus(dealID int, status string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		return fmt.Errorf("deal %d not found", dealID)
+	}
+	if err := checkDealTransition(deal.Status, tcmock.DealStatus(status)); err != nil {
+		return fmt.Errorf("deal %d: %w", dealID, err)
+	}
+	if deal.Status == tcmock.DealStatus(status) {
+		return nil
+	}
+
+	wasActive := isActiveDeal(deal)
+	previous := deal.Status
+	setDealStatus(deal, tcmock.DealStatus(status), ts.nowLocked())
+	if wasActive && isProfitRealized(deal) {
+		ts.settleDealLocked(deal)
+	}
+	ts.dealChangedLocked(DealStatusChanged, deal, previous)
+	return nil
+}
+
+// UpdateDealStatusRaw sets a deal's status as given, bypassing the state machine
+// Any string is accepted and nothing else changes, for deliberately odd fixtures:
+// derived fields and updated_at keep their values and closing the deal books no profit
+func (ts *TestServer) UpdateDealStatusRaw(dealID int, status string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		return fmt.Errorf("deal %d not found", dealID)
+	}
+
+	previous := deal.Status
+	deal.Status = tcmock.DealStatus(status)
+	ts.dealChangedLocked(DealStatusChanged, deal, previous)
+	return nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestDealStatus_StateMachine(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	ts.SetClock(now)
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	ts.AddDeal(NewDeal(1, 1, "USDT_BTC", "created"))
+
+	for _, status := range []string{"base_order_placed", "bought"} {
+		if err := ts.UpdateDealStatus(1, status); err != nil {
+			t.Fatalf("failed to move to %s: %v", status, err)
+		}
+	}
+	deal, _ := ts.GetDealByID(1)
+	if deal.LocalizedStatus != "Bought" || !deal.Cancellable || !deal.PanicSellable || !deal.AddFundable || deal.Finished {
+		t.Fatalf("unexpected bought deal %q cancellable=%v panic_sellable=%v finished=%v",
+			deal.LocalizedStatus, deal.Cancellable, deal.PanicSellable, deal.Finished)
+	}
+
+	if err := ts.UpdateDealStatus(1, "base_order_placed"); err == nil || !strings.Contains(err.Error(), "can't move from bought to base_order_placed") {
+		t.Fatalf("expected the backwards move to be rejected, got %v", err)
+	}
+	if err := ts.UpdateDealStatus(1, "sold"); err == nil || !strings.Contains(err.Error(), `unknown deal status "sold"`) {
+		t.Fatalf("expected the unknown status to be rejected, got %v", err)
+	}
+
+	ts.UpdateDealStatus(1, "panic_sell_pending")
+	if deal, _ = ts.GetDealByID(1); deal.Cancellable || deal.PanicSellable {
+		t.Fatal("expected a pending panic sell to be neither cancellable nor panic sellable")
+	}
+	ts.AdvanceClock(time.Minute)
+	if err := ts.UpdateDealStatus(1, "panic_sold"); err != nil {
+		t.Fatalf("failed to panic sell: %v", err)
+	}
+	deal, _ = ts.GetDealByID(1)
+	if closedAt, err := deal.ClosedAt.Get(); err != nil || !closedAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the deal closed at the mock time, got %v (%v)", closedAt, err)
+	}
+	if !deal.Finished || deal.Cancellable || deal.PanicSellable || deal.LocalizedStatus != "Panic sold" {
+		t.Fatalf("unexpected panic sold deal %q finished=%v", deal.LocalizedStatus, deal.Finished)
+	}
+	if err := ts.UpdateDealStatus(1, "bought"); err == nil || !strings.Contains(err.Error(), "already panic_sold") {
+		t.Fatalf("expected a finished deal to stay finished, got %v", err)
+	}
+}
+
+func TestDealStatus_Raw(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	ts.AddDeal(NewDeal(1, 1, "USDT_BTC", "completed"))
+
+	if err := ts.UpdateDealStatusRaw(1, "half_sold"); err != nil {
+		t.Fatalf("expected the raw update to accept any status, got %v", err)
+	}
+	deal, _ := ts.GetDealByID(1)
+	if deal.Status != "half_sold" || deal.LocalizedStatus != "Completed" || !deal.Finished {
+		t.Fatalf("expected only the status to change, got %s %q finished=%v", deal.Status, deal.LocalizedStatus, deal.Finished)
+	}
+
+	// Fixture statuses outside the state machine may move to any known status
+	if err := ts.UpdateDealStatus(1, "completed"); err != nil {
+		t.Fatalf("expected the fixture status to move, got %v", err)
+	}
+
+	// Closing a deal raw books no profit and keeps updated_at
+	ts.AddAccount(NewAccount(1, "Main", "binance"))
+	ts.SetBalance(1, "USDT", "1000")
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts.AddDeal(NewDeal(2, 1, "USDT_BTC", "bought", WithTimes(updated, updated), WithDeal(func(deal *tcmock.Deal) {
+		deal.FinalProfit = "5"
+	})))
+	if err := ts.UpdateDealStatusRaw(2, "completed"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	deal, _ = ts.GetDealByID(2)
+	if balance, _ := ts.GetBalance(1, "USDT"); balance.Position != "1000" || !deal.UpdatedAt.Equal(updated) || deal.Finished {
+		t.Fatalf("expected only the status to change, got balance=%s updated=%v finished=%v", balance.Position, deal.UpdatedAt, deal.Finished)
+	}
+}
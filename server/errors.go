@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Error codes used in 3Commas ErrorResponse bodies
+const (
+	errorRecordInvalid = "record_invalid"
+	errorNotFound      = "not_found"
+)
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a 3Commas ErrorResponse
+// description and attributes are omitted from the body when empty
+func writeError(w http.ResponseWriter, status int, code, description string, attributes map[string][]string) {
+	resp := tcmock.ErrorResponse{Error: code}
+	if description != "" {
+		resp.ErrorDescription = &description
+	}
+	if len(attributes) > 0 {
+		resp.ErrorAttributes = &attributes
+	}
+	writeJSON(w, status, resp)
+}
+
+// handleParamError is the ErrorHandlerFunc for the generated wrappers
+// Parameter binding failures are reported the way 3Commas reports invalid
+// input: a 400 record_invalid error with the offending parameter listed in
+// error_attributes
+func (ts *TestServer) handleParamError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		invalidFormat *tcmock.InvalidParamFormatError
+		required      *tcmock.RequiredParamError
+		requiredHdr   *tcmock.RequiredHeaderError
+		unmarshaling  *tcmock.UnmarshalingParamError
+		unescaped     *tcmock.UnescapedCookieParamError
+		tooMany       *tcmock.TooManyValuesForParamError
+	)
+
+	var param, problem string
+	switch {
+	case errors.As(err, &invalidFormat):
+		param, problem = invalidFormat.ParamName, "is invalid"
+	case errors.As(err, &required):
+		param, problem = required.ParamName, "is missing"
+	case errors.As(err, &requiredHdr):
+		param, problem = requiredHdr.ParamName, "is missing"
+	case errors.As(err, &unmarshaling):
+		param, problem = unmarshaling.ParamName, "is invalid"
+	case errors.As(err, &unescaped):
+		param, problem = unescaped.ParamName, "is invalid"
+	case errors.As(err, &tooMany):
+		param, problem = tooMany.ParamName, "must have a single value"
+	default:
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, err.Error(), nil)
+		return
+	}
+
+	writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", map[string][]string{
+		param: {problem},
+	})
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestParamError_InvalidFormat(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL() + "/ver1/deals?bot_id=abc")
+	if err != nil {
+		t.Fatalf("failed to GET deals: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON content type, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	var errResp tcmock.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if errResp.Error != "record_invalid" {
+		t.Fatalf("expected error record_invalid, got %s", errResp.Error)
+	}
+	if errResp.ErrorDescription == nil || *errResp.ErrorDescription != "Invalid parameters" {
+		t.Fatalf("expected description 'Invalid parameters', got %v", errResp.ErrorDescription)
+	}
+	if errResp.ErrorAttributes == nil {
+		t.Fatal("expected error_attributes")
+	}
+	attrs := (*errResp.ErrorAttributes)["bot_id"]
+	if len(attrs) != 1 || attrs[0] != "is invalid" {
+		t.Fatalf("expected bot_id to be invalid, got %v", *errResp.ErrorAttributes)
+	}
+}
+
+func TestParamError_InvalidPathParam(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL() + "/ver1/deals/abc/show")
+	if err != nil {
+		t.Fatalf("failed to GET deal: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", resp.StatusCode)
+	}
+
+	var errResp tcmock.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if errResp.ErrorAttributes == nil || len((*errResp.ErrorAttributes)["deal_id"]) == 0 {
+		t.Fatalf("expected deal_id in error_attributes, got %+v", errResp)
+	}
+}
+
+func TestGetDeal_ErrorsAreJSON(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+	ts.SetDealError(101, errors.New("deal locked"))
+
+	tests := []struct {
+		url    string
+		status int
+		error  string
+	}{
+		{url: "/ver1/deals/101/show", status: http.StatusInternalServerError, error: "deal locked"},
+		{url: "/ver1/deals/999/show", status: http.StatusNotFound, error: "deal not found"},
+	}
+
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL() + tt.url)
+		if err != nil {
+			t.Fatalf("failed to GET %s: %v", tt.url, err)
+		}
+
+		var errResp tcmock.ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&errResp)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode error for %s: %v", tt.url, err)
+		}
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.url, tt.status, resp.StatusCode)
+		}
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected JSON content type, got %s", tt.url, resp.Header.Get("Content-Type"))
+		}
+		if errResp.Error != tt.error {
+			t.Errorf("%s: expected error %q, got %q", tt.url, tt.error, errResp.Error)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Event-driven deal financials
+//
+// With SetEventDrivenFinancials enabled, order events added to a deal update
+// its financial fields instead of leaving them as written. Events are read
+// from their message, in the vocabulary the mock's own bot events use:
+//
+//	Placing base order. Price: 50000 USDT Size: 100 USDT (0.002 BTC)
+//	Base order executed. Price: 50000 USDT Size: 100 USDT (0.002 BTC)
+//	Placing averaging order (1 out of 3). Price: ...
+//	Averaging order (1 out of 3) executed. Price: ...
+//	Averaging order (1 out of 3) cancelled. Price: ...
+//	Placing manual averaging order. Price: ...
+//	Manual averaging order executed. Price: ...
+//	Take profit executed. Price: ...
+//
+// Executed base and (manual) averaging orders are entries; any other executed
+// or triggered order with a size is an exit. Placed averaging orders count as
+// active safety orders and reserve their volume in reserved_base_coin until
+// they execute or are cancelled. AddDeal replays the events a fixture deal
+// carries, so its amounts, averages, counts and final profit add up
+
+// BotEvent is a structured order event of a deal
+// It is added to the deal as the equivalent bot event message
+type BotEvent struct {
+	CreatedAt     string `json:"created_at"`     // ISO 8601 timestamp; the mock time when empty
+	Action        string `json:"action"`         // "place", "cancel", "cancelled", "modify"
+	Coin          string `json:"coin"`           // "BTC", "ETH", etc.
+	Type          string `json:"type"`           // "buy", "sell"
+	Status        string `json:"status"`         // "active", "filled", "cancelled"
+	Price         string `json:"price"`          // Decimal string
+	Size          string `json:"size"`           // Decimal string, in Coin
+	OrderType     string `json:"order_type"`     // "base", "safety", "manual_safety", "take_profit", "stop_loss"
+	OrderSize     int    `json:"order_size"`     // Order size category
+	OrderPosition int    `json:"order_position"` // Safety order position
+	IsMarket      bool   `json:"is_market"`      // Market vs limit order
+}
+
+// reservedOrder is a placed averaging order holding part of reserved_base_coin
+type reservedOrder struct {
+	key    string
+	volume *big.Rat
+}
+
+var (
+	eventSizePattern   = regexp.MustCompile(`Price: (\S+) \S+ Size: (\S+) \S+ \((\S+) \S+\)`)
+	eventSafetyPattern = regexp.MustCompile(`[Aa]veraging order \((\d+) out of \d+\)`)
+)
+
+// SetEventDrivenFinancials enables or disables deriving deal financials from order events
+// When enabled, AddBotEventToDeal and AddOrderEventToDeal update the deal's bought,
+// sold, average price, safety order and reserved fields, and AddDeal replays the
+// events of the added deal
+func (ts *TestServer) SetEventDrivenFinancials(enabled bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.eventFinancials = enabled
+}
+
+// AddOrderEventToDeal adds a structured order event to a deal
+// The event is recorded as its bot event message and, with event-driven
+// financials, applied to the deal
+func (ts *TestServer) AddOrderEventToDeal(dealID int, event BotEvent) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		return fmt.Errorf("deal %d not found", dealID)
+	}
+	message, err := event.message(deal)
+	if err != nil {
+		return err
+	}
+	at := ts.nowLocked()
+	if event.CreatedAt != "" {
+		if at, err = time.Parse(time.RFC3339, event.CreatedAt); err != nil {
+			return fmt.Errorf("invalid created_at: %w", err)
+		}
+	}
+
+	addBotEventAt(deal, message, at)
+	ts.applyBotEventLocked(deal, message)
+	ts.dealChangedLocked(DealBotEventAdded, deal, "")
+	return nil
+}
+
+// message renders a structured event as a bot event message
+func (e BotEvent) message(deal *tcmock.Deal) (string, error) {
+	amount, price := decimal.Parse(e.Size), decimal.Parse(e.Price)
+	if amount.Sign() <= 0 || price.Sign() <= 0 {
+		return "", fmt.Errorf("order event needs a positive price and size")
+	}
+	size := orderSize(deal, amount, price)
+
+	status := e.Status
+	if e.Action == "cancel" || e.Action == "cancelled" {
+		status = "cancelled"
+	}
+	position := fmt.Sprintf("(%d out of %d)", e.OrderPosition, deal.MaxSafetyOrders)
+
+	switch {
+	case e.OrderType == "base" && status == "filled":
+		return "Base order executed. " + size, nil
+	case e.OrderType == "base":
+		return "Placing base order. " + size, nil
+	case e.OrderType == "safety" && status == "filled":
+		return "Averaging order " + position + " executed. " + size, nil
+	case e.OrderType == "safety" && status == "cancelled":
+		return "Averaging order " + position + " cancelled. " + size, nil
+	case e.OrderType == "safety":
+		return "Placing averaging order " + position + ". " + size, nil
+	case e.OrderType == "manual_safety" && status == "filled":
+		return "Manual averaging order executed. " + size, nil
+	case e.OrderType == "manual_safety" && status == "cancelled":
+		return "Manual averaging order cancelled. " + size, nil
+	case e.OrderType == "manual_safety":
+		return "Placing manual averaging order. " + size, nil
+	case e.OrderType == "take_profit" && status == "filled":
+		return "Take profit executed. " + size, nil
+	case e.OrderType == "take_profit":
+		return "Placing take profit order. " + size, nil
+	case e.OrderType == "stop_loss" && status == "filled":
+		return "Stop Loss triggered. " + size, nil
+	case status == "filled":
+		return "Order executed. " + size, nil
+	}
+	return "Placing order. " + size, nil
+}
+
+// replayBotEventsLocked recomputes a deal's financials from its bot events
+// The caller must hold ts.mu
+func (ts *TestServer) replayBotEventsLocked(deal *tcmock.Deal) {
+	for _, field := range []*string{
+		&deal.BoughtAmount, &deal.BoughtVolume, &deal.BoughtAveragePrice,
+		&deal.SoldAmount, &deal.SoldVolume, &deal.SoldAveragePrice,
+	} {
+		*field = "0"
+	}
+	deal.CompletedSafetyOrdersCount = 0
+	deal.CompletedManualSafetyOrdersCount = 0
+	deal.CurrentActiveSafetyOrdersCount = 0
+	deal.CurrentActiveSafetyOrders = 0
+	deal.ActiveManualSafetyOrders = 0
+	ext := ts.dealExtensionLocked(deal)
+	ext.ReservedBaseCoin = "0"
+	ext.reservedOrders = nil
+
+	for _, event := range deal.BotEvents {
+		if event.Message != nil {
+			ts.applyBotEventLocked(deal, *event.Message)
+		}
+	}
+}
+
+// applyBotEventLocked applies one bot event message to a deal's financials
+// Messages without an order size, and every message while event-driven
+// financials are off, leave the deal unchanged
+// The caller must hold ts.mu
+func (ts *TestServer) applyBotEventLocked(deal *tcmock.Deal, message string) {
+	match := eventSizePattern.FindStringSubmatch(message)
+	if !ts.eventFinancials || match == nil {
+		return
+	}
+	price, volume, amount := decimal.Parse(match[1]), decimal.Parse(match[2]), decimal.Parse(match[3])
+
+	key := ""
+	switch {
+	case strings.Contains(message, "Manual averaging order") || strings.Contains(message, "manual averaging order"):
+		key = "manual"
+	case eventSafetyPattern.MatchString(message):
+		key = "safety " + eventSafetyPattern.FindStringSubmatch(message)[1]
+	case strings.Contains(message, "Base order") || strings.Contains(message, "base order"):
+		key = "base"
+	}
+
+	ext := ts.dealExtensionLocked(deal)
+	switch {
+	case strings.HasPrefix(message, "Placing"):
+		if key == "manual" || strings.HasPrefix(key, "safety") {
+			ext.reservedOrders = append(ext.reservedOrders, reservedOrder{key: key, volume: volume})
+			ts.countActiveSafetyOrderLocked(deal, key, 1)
+		}
+	case strings.Contains(message, "cancelled"):
+		ts.countActiveSafetyOrderLocked(deal, key, -1)
+	case key == "base":
+		entryFills(deal).add(amount, price)
+		deal.BaseOrderAveragePrice = decimal.Format(price)
+	case key == "manual":
+		entryFills(deal).add(amount, price)
+		deal.CompletedManualSafetyOrdersCount++
+		ts.countActiveSafetyOrderLocked(deal, key, -1)
+	case strings.HasPrefix(key, "safety"):
+		entryFills(deal).add(amount, price)
+		deal.CompletedSafetyOrdersCount++
+		ts.countActiveSafetyOrderLocked(deal, key, -1)
+	default:
+		exitFills(deal).add(amount, price)
+	}
+	ts.updateEventProfitLocked(deal, price)
+}
+
+// countActiveSafetyOrderLocked moves a deal's active safety order counts by delta
+// Orders leaving the active set release their reserved volume
+// The caller must hold ts.mu
+func (ts *TestServer) countActiveSafetyOrderLocked(deal *tcmock.Deal, key string, delta int) {
+	ext := ts.dealExtensionLocked(deal)
+	if delta < 0 {
+		found := false
+		for i, order := range ext.reservedOrders {
+			if order.key == key {
+				ext.reservedOrders = append(ext.reservedOrders[:i], ext.reservedOrders[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+
+	if key == "manual" {
+		deal.ActiveManualSafetyOrders += delta
+	} else {
+		deal.CurrentActiveSafetyOrders += delta
+	}
+	deal.CurrentActiveSafetyOrdersCount += delta
+
+	reserved := new(big.Rat)
+	for _, order := range ext.reservedOrders {
+		reserved.Add(reserved, order.volume)
+	}
+	ext.ReservedBaseCoin = decimal.Format(reserved)
+}
+
+// updateEventProfitLocked refreshes the profit fields after an applied event
+// Open deals follow the market; finished deals get their final profit from
+// the recorded fills, valuing any position left at the exit price
+// The caller must hold ts.mu
+func (ts *TestServer) updateEventProfitLocked(deal *tcmock.Deal, price *big.Rat) {
+	if isActiveDeal(deal) {
+		ts.updateTakeProfitPriceLocked(deal)
+		ts.repriceDealLocked(deal)
+		return
+	}
+	exitPrice := exitFills(deal).averagePrice()
+	if exitPrice.Sign() == 0 {
+		return
+	}
+	profit := dealProfit(deal, exitPrice)
+	deal.FinalProfit = decimal.Format(profitInCurrency(deal, profit, exitPrice))
+	deal.FinalProfitPercentage = dealProfitPercentage(deal, profit)
+	if usd, ok := ts.usdRateLocked(ts.accountMarketLocked(deal.AccountId), deal.FromCurrency); ok {
+		deal.UsdFinalProfit = decimal.Format(new(big.Rat).Mul(profit, usd))
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import "testing"
+
+func TestEventFinancials_ReplayFixture(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetEventDrivenFinancials(true)
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	deal := NewDeal(1, 1, "USDT_BTC", "completed")
+	AddBotEvent(&deal, "Base order executed. Price: 50000 USDT Size: 100 USDT (0.002 BTC)")
+	AddBotEvent(&deal, "Placing averaging order (1 out of 2). Price: 49000 USDT Size: 98 USDT (0.002 BTC)")
+	AddBotEvent(&deal, "Placing averaging order (2 out of 2). Price: 48000 USDT Size: 96 USDT (0.002 BTC)")
+	AddBotEvent(&deal, "Averaging order (1 out of 2) executed. Price: 49000 USDT Size: 98 USDT (0.002 BTC)")
+	AddBotEvent(&deal, "Averaging order (2 out of 2) cancelled. Price: 48000 USDT Size: 96 USDT (0.002 BTC)")
+	AddBotEvent(&deal, "Take profit executed. Price: 50490 USDT Size: 201.96 USDT (0.004 BTC)")
+	if err := ts.AddDeal(deal); err != nil {
+		t.Fatalf("failed to add deal: %v", err)
+	}
+
+	got, _ := ts.GetDealByID(1)
+	if got.BoughtAmount != "0.004" || got.BoughtVolume != "198" || got.BoughtAveragePrice != "49500" || got.BaseOrderAveragePrice != "50000" {
+		t.Fatalf("unexpected entry %s/%s @ %s (base %s)", got.BoughtAmount, got.BoughtVolume, got.BoughtAveragePrice, got.BaseOrderAveragePrice)
+	}
+	if got.SoldAmount != "0.004" || got.SoldVolume != "201.96" || got.SoldAveragePrice != "50490" {
+		t.Fatalf("unexpected exit %s/%s @ %s", got.SoldAmount, got.SoldVolume, got.SoldAveragePrice)
+	}
+	if got.CompletedSafetyOrdersCount != 1 || got.CurrentActiveSafetyOrdersCount != 0 {
+		t.Fatalf("expected 1 completed and no active safety orders, got %d/%d", got.CompletedSafetyOrdersCount, got.CurrentActiveSafetyOrdersCount)
+	}
+	if got.FinalProfit != "3.96" || got.FinalProfitPercentage != "2.00" {
+		t.Fatalf("expected a final profit of 3.96 (2%%), got %s (%s%%)", got.FinalProfit, got.FinalProfitPercentage)
+	}
+	if ext, _ := ts.GetDealExtension(1); ext.ReservedBaseCoin != "0" {
+		t.Fatalf("expected nothing reserved, got %s", ext.ReservedBaseCoin)
+	}
+}
+
+func TestEventFinancials_Incremental(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	deal := NewDeal(1, 1, "USDT_BTC", "bought")
+	deal.MaxSafetyOrders = 2
+	ts.AddDeal(deal)
+
+	// Events are plain text until the mode is enabled
+	ts.AddBotEventToDeal(1, "Base order executed. Price: 50000 USDT Size: 100 USDT (0.002 BTC)")
+	if got, _ := ts.GetDealByID(1); got.BoughtAmount != "0" {
+		t.Fatalf("expected the deal to be unchanged, got %s", got.BoughtAmount)
+	}
+
+	ts.SetEventDrivenFinancials(true)
+	events := []BotEvent{
+		{Status: "filled", OrderType: "base", Price: "50000", Size: "0.002"},
+		{Action: "place", Status: "active", OrderType: "safety", OrderPosition: 1, Price: "49000", Size: "0.002"},
+	}
+	for _, event := range events {
+		if err := ts.AddOrderEventToDeal(1, event); err != nil {
+			t.Fatalf("failed to add event: %v", err)
+		}
+	}
+	ts.AddBotEventToDeal(1, "Placing manual averaging order. Price: 48000 USDT Size: 48 USDT (0.001 BTC)")
+
+	got, _ := ts.GetDealByID(1)
+	if got.BoughtAmount != "0.002" || got.CurrentActiveSafetyOrdersCount != 2 || got.CurrentActiveSafetyOrders != 1 || got.ActiveManualSafetyOrders != 1 {
+		t.Fatalf("unexpected deal bought=%s active=%d/%d/%d", got.BoughtAmount, got.CurrentActiveSafetyOrdersCount,
+			got.CurrentActiveSafetyOrders, got.ActiveManualSafetyOrders)
+	}
+	if ext, _ := ts.GetDealExtension(1); ext.ReservedBaseCoin != "146" {
+		t.Fatalf("expected 146 reserved, got %s", ext.ReservedBaseCoin)
+	}
+
+	ts.AddOrderEventToDeal(1, BotEvent{Status: "filled", OrderType: "safety", OrderPosition: 1, Price: "49000", Size: "0.002"})
+	got, _ = ts.GetDealByID(1)
+	if got.BoughtAmount != "0.004" || got.BoughtAveragePrice != "49500" || got.CompletedSafetyOrdersCount != 1 || got.CurrentActiveSafetyOrdersCount != 1 {
+		t.Fatalf("unexpected deal after the fill bought=%s avg=%s completed=%d active=%d",
+			got.BoughtAmount, got.BoughtAveragePrice, got.CompletedSafetyOrdersCount, got.CurrentActiveSafetyOrdersCount)
+	}
+	if msg := lastBotEvent(t, ts, 1); msg != "Averaging order (1 out of 2) executed. Price: 49000 USDT Size: 98 USDT (0.002 BTC)" {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+	if ext, _ := ts.GetDealExtension(1); ext.ReservedBaseCoin != "48" {
+		t.Fatalf("expected 48 reserved, got %s", ext.ReservedBaseCoin)
+	}
+
+	if err := ts.AddOrderEventToDeal(1, BotEvent{Status: "filled", OrderType: "base"}); err == nil {
+		t.Fatal("expected an event without price and size to be rejected")
+	}
+}
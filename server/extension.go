@@ -0,0 +1,93 @@
+package server
+
+import (
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// DealExtension holds the deal fields 3Commas returns that the generated model lacks
+// They are kept per deal and merged into every deal the mock serves
+type DealExtension struct {
+	// TrailingDeviation is the trailing take profit deviation in percent
+	TrailingDeviation string `json:"trailing_deviation"`
+	// TrailingMaxPrice is the highest price since trailing take profit activated
+	TrailingMaxPrice *string `json:"trailing_max_price"`
+	// TslMaxPrice is the highest price the trailing stop loss follows
+	TslMaxPrice *string `json:"tsl_max_price"`
+	// LastKnownPositionInfo is the exchange position of a futures deal; nil for spot deals
+	LastKnownPositionInfo *DealPositionInfo `json:"last_known_position_info"`
+	// ReservedBaseCoin is the quote volume held by placed, unfilled averaging orders
+	ReservedBaseCoin string `json:"reserved_base_coin"`
+
+	// stopLossBreachedAt is when the price fell to the stop loss of a deal waiting
+	// out its stop loss timeout; nil while the price is above the stop loss
+	stopLossBreachedAt *time.Time
+	// reservedOrders are the placed averaging orders making up ReservedBaseCoin
+	reservedOrders []reservedOrder
+}
+
+// DealResponse is a deal as served by the mock: the generated model plus its extension
+// It decodes into tcmock.Deal; the extension fields are ignored there
+type DealResponse struct {
+	tcmock.Deal
+	DealExtension
+}
+
+// GetDealExtension returns the extension fields of a deal
+func (ts *TestServer) GetDealExtension(dealID int) (DealExtension, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		return DealExtension{}, false
+	}
+	return ts.dealResponseLocked(deal).DealExtension, true
+}
+
+// newDealExtension returns the extension of a new deal of bot
+// bot may be nil
+func newDealExtension(bot *tcmock.Bot) *DealExtension {
+	ext := &DealExtension{TrailingDeviation: "0", ReservedBaseCoin: "0"}
+	if bot != nil && bot.TrailingDeviation != nil {
+		ext.TrailingDeviation = *bot.TrailingDeviation
+	}
+	return ext
+}
+
+// dealExtensionLocked returns the extension of a deal for editing, creating it from
+// the deal's bot on first use
+// The caller must hold ts.mu for writing
+func (ts *TestServer) dealExtensionLocked(deal *tcmock.Deal) *DealExtension {
+	ext, ok := ts.dealExtensions[deal.Id]
+	if !ok {
+		ext = newDealExtension(ts.bots[deal.BotId])
+		ts.dealExtensions[deal.Id] = ext
+	}
+	return ext
+}
+
+// dealResponseLocked returns a deal merged with a copy of its extension
+// The caller must hold ts.mu
+func (ts *TestServer) dealResponseLocked(deal *tcmock.Deal) DealResponse {
+	ext, ok := ts.dealExtensions[deal.Id]
+	if !ok {
+		ext = newDealExtension(ts.bots[deal.BotId])
+	}
+	resp := DealResponse{Deal: *deal, DealExtension: *ext}
+	if ext.TrailingMaxPrice != nil {
+		resp.TrailingMaxPrice = ptr(*ext.TrailingMaxPrice)
+	}
+	if ext.TslMaxPrice != nil {
+		resp.TslMaxPrice = ptr(*ext.TslMaxPrice)
+	}
+	if ext.LastKnownPositionInfo != nil {
+		info := *ext.LastKnownPositionInfo
+		if info.LiquidationPrice != nil {
+			info.LiquidationPrice = ptr(*info.LiquidationPrice)
+		}
+		resp.LastKnownPositionInfo = &info
+	}
+	return resp
+}
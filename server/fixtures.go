@@ -0,0 +1,499 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Fixture options
+//
+// NewBot and NewDeal take options that fill the fields tests otherwise set by
+// hand, including the inline struct types of take_profit_steps and
+// bot_events. Shared options work for both: WithSafetyOrders sets the bot's
+// safety order settings or the deal's. Bot-only options are BotOptions and
+// deal-only options DealOptions, so passing WithNote to NewBot doesn't
+// compile. Options apply in order, and WithBot/WithDeal reach any field the
+// others don't
+
+// BotOption configures a bot built by NewBot
+type BotOption interface {
+	applyBot(bot *tcmock.Bot)
+}
+
+// DealOption configures a deal built by NewDeal or added by AddDeal
+type DealOption interface {
+	applyDeal(deal *tcmock.Deal)
+}
+
+// FixtureOption configures both bots and deals
+type FixtureOption struct {
+	bot  func(bot *tcmock.Bot)
+	deal func(deal *tcmock.Deal)
+	// extension sets the deal extension; only AddDeal applies it
+	extension func(ext *DealExtension)
+}
+
+func (o FixtureOption) applyBot(bot *tcmock.Bot)    { o.bot(bot) }
+func (o FixtureOption) applyDeal(deal *tcmock.Deal) { o.deal(deal) }
+
+// applyExtension sets the deal extension fields of the option, if any
+func (o FixtureOption) applyExtension(ext *DealExtension) {
+	if o.extension != nil {
+		o.extension(ext)
+	}
+}
+
+// extensionOption is a DealOption that also sets the deal extension
+type extensionOption interface {
+	applyExtension(ext *DealExtension)
+}
+
+// botOption is a BotOption that doesn't apply to deals
+type botOption func(bot *tcmock.Bot)
+
+func (o botOption) applyBot(bot *tcmock.Bot) { o(bot) }
+
+// dealOption is a DealOption that doesn't apply to bots
+type dealOption func(deal *tcmock.Deal)
+
+func (o dealOption) applyDeal(deal *tcmock.Deal) { o(deal) }
+
+// SafetyOrders configures the safety orders of a bot or deal
+// Empty strings keep the default
+type SafetyOrders struct {
+	Max                         int
+	Volume                      string
+	VolumeType                  string // "quote_currency", "base_currency", "percent", "xbt"
+	StepPercentage              string
+	MartingaleVolumeCoefficient string
+	MartingaleStepCoefficient   string
+	Active                      int // active_safety_orders_count
+	Completed                   int // Deals only
+}
+
+// StopLoss configures the stop loss of a bot or deal
+type StopLoss struct {
+	Percentage     string
+	Type           string // "stop_loss" or "stop_loss_and_disable_bot"
+	TimeoutSeconds int    // Enables the timeout when positive
+	Trailing       bool   // tsl_enabled
+	ToBreakeven    bool   // sl_to_breakeven_enabled
+}
+
+// TakeProfitStep is a take profit step of a bot or deal
+// Bots store whole percentages, so fractions are rounded for them
+type TakeProfitStep struct {
+	ProfitPercentage float32
+	AmountPercentage float32
+	Price            string // Deals only
+	Status           string // Deals only; "active" when empty
+}
+
+// DealStart configures the deal-start conditions of a bot
+// Nil pointers and zero values leave a condition off
+type DealStart struct {
+	MaxActiveDeals         int
+	AllowedDealsOnSamePair int
+	Cooldown               string // Seconds
+	StartDelaySeconds      int
+	DisableAfterDealsCount int
+	MinVolumeBtc24h        string
+	MinPrice               *float32
+	MaxPrice               *float32
+	MinPricePercentage     *float32
+	MaxPricePercentage     *float32
+}
+
+// WithName sets a bot's name, or the bot name of a deal
+func WithName(name string) FixtureOption {
+	return FixtureOption{
+		bot:  func(bot *tcmock.Bot) { bot.Name = ptr(name) },
+		deal: func(deal *tcmock.Deal) { deal.BotName = name },
+	}
+}
+
+// WithAccount sets the exchange account of a bot or deal
+func WithAccount(id int, name string) FixtureOption {
+	return FixtureOption{
+		bot:  func(bot *tcmock.Bot) { bot.AccountId, bot.AccountName = id, name },
+		deal: func(deal *tcmock.Deal) { deal.AccountId, deal.AccountName = id, name },
+	}
+}
+
+// WithPairs sets a bot's pairs
+// Deals take the first pair, with its quote and base currencies
+func WithPairs(pairs ...string) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) { bot.Pairs = append(tcmock.Pairs{}, pairs...) },
+		deal: func(deal *tcmock.Deal) {
+			if len(pairs) == 0 {
+				return
+			}
+			quote, base := splitPair(pairs[0])
+			deal.Pair = pairs[0]
+			deal.FromCurrency, deal.OrderbookPriceCurrency, deal.ToCurrency = quote, quote, base
+		},
+	}
+}
+
+// WithStrategy sets a bot's strategy, long or short
+// Deals of a short strategy get the short deal type
+func WithStrategy(strategy tcmock.BotStrategy) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) { bot.Strategy = ptr(strategy) },
+		deal: func(deal *tcmock.Deal) {
+			if strategy == tcmock.BotStrategyShort {
+				deal.Type = shortDealType
+			} else {
+				deal.Type = "simple"
+			}
+		},
+	}
+}
+
+// WithBaseOrder sets the base order volume and its type
+func WithBaseOrder(volume, volumeType string) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			bot.BaseOrderVolume = ptr(volume)
+			bot.BaseOrderVolumeType = ptr(tcmock.BotBaseOrderVolumeType(volumeType))
+		},
+		deal: func(deal *tcmock.Deal) { deal.BaseOrderVolume, deal.BaseOrderVolumeType = volume, volumeType },
+	}
+}
+
+// WithSafetyOrders sets the safety order settings of a bot or deal
+func WithSafetyOrders(so SafetyOrders) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			bot.MaxSafetyOrders = ptr(so.Max)
+			bot.ActiveSafetyOrdersCount = ptr(so.Active)
+			setIfNotEmpty(&bot.SafetyOrderVolume, so.Volume)
+			if so.VolumeType != "" {
+				bot.SafetyOrderVolumeType = ptr(tcmock.BotSafetyOrderVolumeType(so.VolumeType))
+			}
+			setIfNotEmpty(&bot.SafetyOrderStepPercentage, so.StepPercentage)
+			setIfNotEmpty(&bot.MartingaleVolumeCoefficient, so.MartingaleVolumeCoefficient)
+			setIfNotEmpty(&bot.MartingaleStepCoefficient, so.MartingaleStepCoefficient)
+		},
+		deal: func(deal *tcmock.Deal) {
+			deal.MaxSafetyOrders = so.Max
+			deal.ActiveSafetyOrdersCount = so.Active
+			deal.CompletedSafetyOrdersCount = so.Completed
+			for dst, src := range map[*string]string{
+				&deal.SafetyOrderVolume:           so.Volume,
+				&deal.SafetyOrderVolumeType:       so.VolumeType,
+				&deal.SafetyOrderStepPercentage:   so.StepPercentage,
+				&deal.MartingaleVolumeCoefficient: so.MartingaleVolumeCoefficient,
+				&deal.MartingaleStepCoefficient:   so.MartingaleStepCoefficient,
+			} {
+				if src != "" {
+					*dst = src
+				}
+			}
+		},
+	}
+}
+
+// setIfNotEmpty points dst at v unless v is empty
+func setIfNotEmpty(dst **string, v string) {
+	if v != "" {
+		*dst = ptr(v)
+	}
+}
+
+// WithTakeProfit sets the take profit percentage and its type, "base" or "total"
+func WithTakeProfit(percentage string, takeProfitType tcmock.BotTakeProfitType) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			bot.TakeProfit = ptr(percentage)
+			bot.TakeProfitType = ptr(takeProfitType)
+		},
+		deal: func(deal *tcmock.Deal) {
+			deal.TakeProfit = nullable.NewNullableWithValue(percentage)
+			deal.TakeProfitType = tcmock.DealTakeProfitType(takeProfitType)
+		},
+	}
+}
+
+// WithTakeProfitSteps sets the take profit steps of a bot or deal, numbered from 1
+func WithTakeProfitSteps(steps ...TakeProfitStep) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			botSteps := make([]tcmock.TakeProfitStep, len(steps))
+			for i, step := range steps {
+				botSteps[i] = tcmock.TakeProfitStep{
+					Id:               ptr(i + 1),
+					ProfitPercentage: ptr(int(math.Round(float64(step.ProfitPercentage)))),
+					AmountPercentage: ptr(int(math.Round(float64(step.AmountPercentage)))),
+				}
+			}
+			bot.TakeProfitSteps = &botSteps
+		},
+		deal: func(deal *tcmock.Deal) {
+			deal.TakeProfitSteps = make([]dealTakeProfitStep, len(steps))
+			for i, step := range steps {
+				status := step.Status
+				if status == "" {
+					status = takeProfitStepActive
+				}
+				deal.TakeProfitSteps[i] = dealTakeProfitStep{
+					Id:               ptr(i + 1),
+					ProfitPercentage: ptr(step.ProfitPercentage),
+					AmountPercentage: ptr(step.AmountPercentage),
+					Status:           ptr(status),
+					Editable:         ptr(status == takeProfitStepActive),
+					PanicSellable:    ptr(status == takeProfitStepActive),
+				}
+				if step.Price != "" {
+					deal.TakeProfitSteps[i].Price = ptr(step.Price)
+				}
+			}
+		},
+	}
+}
+
+// WithTrailing enables trailing take profit with a deviation in percent
+// Deals keep their deviation in the deal extension, which AddDeal sets; a deal
+// added without the option takes the deviation of its bot
+func WithTrailing(deviation string) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			bot.TrailingEnabled = ptr(true)
+			bot.TrailingDeviation = ptr(deviation)
+		},
+		deal:      func(deal *tcmock.Deal) { deal.TrailingEnabled = true },
+		extension: func(ext *DealExtension) { ext.TrailingDeviation = decimal.Format(decimal.Parse(deviation)) },
+	}
+}
+
+// WithStopLoss sets the stop loss of a bot or deal
+// An empty percentage keeps the default
+func WithStopLoss(sl StopLoss) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			setIfNotEmpty(&bot.StopLossPercentage, sl.Percentage)
+			if sl.Type != "" {
+				bot.StopLossType = ptr(tcmock.BotStopLossType(sl.Type))
+			}
+			bot.StopLossTimeoutEnabled = ptr(sl.TimeoutSeconds > 0)
+			bot.StopLossTimeoutInSeconds = ptr(sl.TimeoutSeconds)
+			bot.TslEnabled = ptr(sl.Trailing)
+			bot.SlToBreakevenEnabled = ptr(sl.ToBreakeven)
+		},
+		deal: func(deal *tcmock.Deal) {
+			if sl.Percentage != "" {
+				deal.StopLossPercentage = sl.Percentage
+			}
+			if sl.Type != "" {
+				deal.StopLossType = sl.Type
+			}
+			deal.StopLossTimeoutEnabled = sl.TimeoutSeconds > 0
+			deal.StopLossTimeoutInSeconds = sl.TimeoutSeconds
+			deal.TslEnabled = sl.Trailing
+			deal.SlToBreakevenEnabled = sl.ToBreakeven
+		},
+	}
+}
+
+// WithMinProfit sets the min profit percentage and its type, checked by close strategies
+func WithMinProfit(percentage string, minProfitType tcmock.BotMinProfitType) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			bot.MinProfitPercentage = ptr(percentage)
+			bot.MinProfitType = ptr(minProfitType)
+		},
+		deal: func(deal *tcmock.Deal) {
+			deal.MinProfitPercentage = percentage
+			deal.MinProfitType = nullable.NewNullableWithValue(string(minProfitType))
+		},
+	}
+}
+
+// WithSafetyStrategies sets the safety strategies of a bot or deal
+func WithSafetyStrategies(strategies ...tcmock.StrategyConfig) FixtureOption {
+	return FixtureOption{
+		bot:  func(bot *tcmock.Bot) { bot.SafetyStrategyList = ptr(append([]tcmock.StrategyConfig{}, strategies...)) },
+		deal: func(deal *tcmock.Deal) { deal.SafetyStrategyList = strategyMaps(strategies) },
+	}
+}
+
+// WithCloseStrategies sets the close strategies of a bot or deal
+func WithCloseStrategies(strategies ...tcmock.StrategyConfig) FixtureOption {
+	return FixtureOption{
+		bot:  func(bot *tcmock.Bot) { bot.CloseStrategyList = ptr(append([]tcmock.StrategyConfig{}, strategies...)) },
+		deal: func(deal *tcmock.Deal) { deal.CloseStrategyList = strategyMaps(strategies) },
+	}
+}
+
+// WithStartStrategies sets the deal start strategies of a bot
+func WithStartStrategies(strategies ...tcmock.StrategyConfig) BotOption {
+	return botOption(func(bot *tcmock.Bot) {
+		bot.StrategyList = ptr(append([]tcmock.StrategyConfig{}, strategies...))
+	})
+}
+
+// WithDealStart sets the deal-start conditions of a bot
+func WithDealStart(start DealStart) BotOption {
+	return botOption(func(bot *tcmock.Bot) {
+		for dst, src := range map[**int]int{
+			&bot.MaxActiveDeals:         start.MaxActiveDeals,
+			&bot.AllowedDealsOnSamePair: start.AllowedDealsOnSamePair,
+			&bot.DealStartDelaySeconds:  start.StartDelaySeconds,
+			&bot.DisableAfterDealsCount: start.DisableAfterDealsCount,
+		} {
+			if src > 0 {
+				*dst = ptr(src)
+			}
+		}
+		setIfNotEmpty(&bot.Cooldown, start.Cooldown)
+		setIfNotEmpty(&bot.MinVolumeBtc24h, start.MinVolumeBtc24h)
+		bot.MinPrice, bot.MaxPrice = start.MinPrice, start.MaxPrice
+		bot.MinPricePercentage, bot.MaxPricePercentage = start.MinPricePercentage, start.MaxPricePercentage
+	})
+}
+
+// WithProfitCurrency sets whether profit is taken in the quote or the base currency
+func WithProfitCurrency(currency tcmock.BotProfitCurrency) FixtureOption {
+	return FixtureOption{
+		bot:  func(bot *tcmock.Bot) { bot.ProfitCurrency = ptr(currency) },
+		deal: func(deal *tcmock.Deal) { deal.ProfitCurrency = string(currency) },
+	}
+}
+
+// WithReinvestment sets a bot's reinvesting and risk reduction percentages
+func WithReinvestment(reinvesting, riskReduction string) BotOption {
+	return botOption(func(bot *tcmock.Bot) {
+		setIfNotEmpty(&bot.ReinvestingPercentage, reinvesting)
+		setIfNotEmpty(&bot.RiskReductionPercentage, riskReduction)
+	})
+}
+
+// WithLeverage sets the leverage type ("custom", "cross", "isolated") and value of a bot or deal
+func WithLeverage(leverageType string, value float32) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) {
+			bot.LeverageType = ptr(tcmock.BotLeverageType(leverageType))
+			bot.LeverageCustomValue = ptr(value)
+		},
+		deal: func(deal *tcmock.Deal) {
+			deal.LeverageType = leverageType
+			deal.LeverageCustomValue = nullable.NewNullableWithValue(decimal.Format(decimal.FromFloat32(value)))
+		},
+	}
+}
+
+// WithTimes sets the created_at and updated_at of a bot or deal
+func WithTimes(createdAt, updatedAt time.Time) FixtureOption {
+	return FixtureOption{
+		bot:  func(bot *tcmock.Bot) { bot.CreatedAt, bot.UpdatedAt = createdAt, updatedAt },
+		deal: func(deal *tcmock.Deal) { deal.CreatedAt, deal.UpdatedAt = createdAt, updatedAt },
+	}
+}
+
+// WithClosedAt sets the closed_at of a deal
+func WithClosedAt(closedAt time.Time) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		deal.ClosedAt = nullable.NewNullableWithValue(closedAt)
+	})
+}
+
+// WithEvents adds bot events to a deal, stamped at its created_at
+// Use WithEventAt for events at other times
+func WithEvents(messages ...string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		for _, message := range messages {
+			addBotEventAt(deal, message, deal.CreatedAt)
+		}
+	})
+}
+
+// WithEventAt adds a bot event to a deal at the given time
+func WithEventAt(at time.Time, message string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		addBotEventAt(deal, message, at)
+	})
+}
+
+// WithBought sets the bought amount, volume and average price of a deal
+func WithBought(amount, volume, averagePrice string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		deal.BoughtAmount, deal.BoughtVolume, deal.BoughtAveragePrice = amount, volume, averagePrice
+	})
+}
+
+// WithSold sets the sold amount, volume and average price of a deal
+func WithSold(amount, volume, averagePrice string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		deal.SoldAmount, deal.SoldVolume, deal.SoldAveragePrice = amount, volume, averagePrice
+	})
+}
+
+// WithPrices sets the current, take profit and stop loss prices of a deal
+// Empty prices are left unchanged
+func WithPrices(current, takeProfit, stopLoss string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		for dst, src := range map[*string]string{
+			&deal.CurrentPrice:    current,
+			&deal.TakeProfitPrice: takeProfit,
+			&deal.StopLossPrice:   stopLoss,
+		} {
+			if src != "" {
+				*dst = src
+			}
+		}
+	})
+}
+
+// WithProfit sets a deal's profit, its percentage and its USD value
+// Finished deals get them as final and actual profit, open deals as actual profit;
+// bots take the USD value as finished_deals_profit_usd
+func WithProfit(profit, percentage, usd string) FixtureOption {
+	return FixtureOption{
+		bot: func(bot *tcmock.Bot) { bot.FinishedDealsProfitUsd = usd },
+		deal: func(deal *tcmock.Deal) {
+			deal.ActualProfit = nullable.NewNullableWithValue(profit)
+			deal.ActualProfitPercentage = percentage
+			deal.ActualUsdProfit = nullable.NewNullableWithValue(usd)
+			if !isActiveDeal(deal) {
+				deal.FinalProfit, deal.FinalProfitPercentage, deal.UsdFinalProfit = profit, percentage, usd
+			}
+		},
+	}
+}
+
+// WithNote sets a deal's note
+func WithNote(note string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		deal.Note = nullable.NewNullableWithValue(note)
+	})
+}
+
+// WithError marks a deal as failing with an error message
+func WithError(message string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		deal.DealHasError = true
+		deal.ErrorMessage = nullable.NewNullableWithValue(message)
+	})
+}
+
+// WithMarketType sets a deal's market type, "spot" or "futures"
+func WithMarketType(marketType string) DealOption {
+	return dealOption(func(deal *tcmock.Deal) {
+		deal.MarketType = marketType
+	})
+}
+
+// WithBot edits a bot directly, for fields no other option covers
+func WithBot(edit func(*tcmock.Bot)) BotOption {
+	return botOption(edit)
+}
+
+// WithDeal edits a deal directly, for fields no other option covers
+func WithDeal(edit func(*tcmock.Deal)) DealOption {
+	return dealOption(edit)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestFixtures_BotOptions(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(1, "Main", "binance"))
+	ts.SetBalance(1, "USDT", "1000")
+	ts.SetMarketPrice("USDT_ETH", "2000")
+	ts.AddBot(NewBot(1, "Short Bot", 1, true,
+		WithPairs("USDT_ETH"),
+		WithStrategy(tcmock.BotStrategyShort),
+		WithBaseOrder("100", "quote_currency"),
+		WithSafetyOrders(SafetyOrders{Max: 3, Volume: "50", StepPercentage: "1.5"}),
+		WithTakeProfitSteps(TakeProfitStep{ProfitPercentage: 1, AmountPercentage: 50}, TakeProfitStep{ProfitPercentage: 2.4, AmountPercentage: 50}),
+		WithStopLoss(StopLoss{Percentage: "5", TimeoutSeconds: 60}),
+		WithDealStart(DealStart{MaxActiveDeals: 2}),
+	))
+
+	bot, _ := ts.GetBot(1)
+	if len(bot.Pairs) != 1 || *bot.Strategy != tcmock.BotStrategyShort || *bot.MaxSafetyOrders != 3 || *bot.SafetyOrderVolume != "50" {
+		t.Fatalf("unexpected bot %v %s %d %s", bot.Pairs, *bot.Strategy, *bot.MaxSafetyOrders, *bot.SafetyOrderVolume)
+	}
+	if steps := *bot.TakeProfitSteps; len(steps) != 2 || *steps[1].Id != 2 || *steps[1].ProfitPercentage != 2 {
+		t.Fatalf("unexpected take profit steps %+v", steps)
+	}
+	if !*bot.StopLossTimeoutEnabled || *bot.MaxActiveDeals != 2 {
+		t.Fatal("expected the stop loss timeout and deal limit to be set")
+	}
+
+	// Deals started by the bot take its settings
+	deal, opened, err := ts.StartDeal(1, "USDT_ETH")
+	if err != nil || !opened {
+		t.Fatalf("expected a deal to start, got %v", err)
+	}
+	if !isShortDeal(&deal) || deal.MaxSafetyOrders != 3 || deal.StopLossPercentage != "5" {
+		t.Fatalf("unexpected deal type=%s so=%d sl=%s", deal.Type, deal.MaxSafetyOrders, deal.StopLossPercentage)
+	}
+}
+
+func TestFixtures_DealOptions(t *testing.T) {
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	closed := created.Add(3 * time.Hour)
+	deal := NewDeal(7, 1, "USDT_BTC", "completed",
+		WithPairs("BUSD_ETH"),
+		WithTimes(created, closed),
+		WithClosedAt(closed),
+		WithTakeProfitSteps(TakeProfitStep{ProfitPercentage: 1.5, AmountPercentage: 100, Price: "2030", Status: takeProfitStepFinished}),
+		WithEvents("Placing base order.", "Base order executed."),
+		WithEventAt(closed, "Take profit executed."),
+		WithBought("0.05", "100", "2000"),
+		WithSold("0.05", "101.5", "2030"),
+		WithProfit("1.5", "1.50", "1.5"),
+		WithNote("fixture"),
+	)
+
+	if deal.Pair != "BUSD_ETH" || deal.FromCurrency != "BUSD" || deal.ToCurrency != "ETH" {
+		t.Fatalf("unexpected pair %s %s/%s", deal.Pair, deal.FromCurrency, deal.ToCurrency)
+	}
+	if step := deal.TakeProfitSteps[0]; *step.ProfitPercentage != 1.5 || *step.Price != "2030" || *step.Editable {
+		t.Fatalf("unexpected take profit step %+v", step)
+	}
+	if len(deal.BotEvents) != 3 || !deal.BotEvents[0].CreatedAt.Equal(created) || !deal.BotEvents[2].CreatedAt.Equal(closed) {
+		t.Fatalf("unexpected bot events %d", len(deal.BotEvents))
+	}
+	if deal.FinalProfit != "1.5" || deal.FinalProfitPercentage != "1.50" || deal.UsdFinalProfit != "1.5" {
+		t.Fatalf("expected the final profit to be set on a finished deal, got %s", deal.FinalProfit)
+	}
+	if note, _ := deal.Note.Get(); note != "fixture" || deal.SoldVolume != "101.5" {
+		t.Fatalf("unexpected note %q sold=%s", note, deal.SoldVolume)
+	}
+
+	if closedAt, _ := deal.ClosedAt.Get(); !deal.Finished || deal.LocalizedStatus != "Completed" || !closedAt.Equal(closed) {
+		t.Fatalf("expected the status to set the derived fields, got %q finished=%v closed=%v", deal.LocalizedStatus, deal.Finished, closedAt)
+	}
+
+	open := NewDeal(8, 1, "USDT_BTC", "bought", WithProfit("-2", "-2.00", "-2"))
+	if profit, _ := open.ActualProfit.Get(); profit != "-2" || open.FinalProfit != "0" {
+		t.Fatalf("expected only the actual profit on an open deal, got %s/%s", profit, open.FinalProfit)
+	}
+}
+
+func TestFixtures_ExecuteTakeProfitStep(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	ts.AddDeal(NewDeal(1, 1, "USDT_BTC", "bought",
+		WithBought("0.01", "500", "50000"),
+		WithTakeProfitSteps(TakeProfitStep{ProfitPercentage: 1, AmountPercentage: 40}, TakeProfitStep{ProfitPercentage: 2, AmountPercentage: 60}),
+	))
+
+	if err := ts.ExecuteTakeProfitStep(1); err != nil {
+		t.Fatalf("failed to execute step: %v", err)
+	}
+	deal, _ := ts.GetDealByID(1)
+	if deal.SoldAmount != "0.004" || deal.SoldVolume != "202" || *deal.TakeProfitSteps[1].Price != "51000" {
+		t.Fatalf("expected the first step to sell 0.004 at 50500, got %s/%s", deal.SoldAmount, deal.SoldVolume)
+	}
+	if err := ts.ExecuteTakeProfitStep(1); err != nil {
+		t.Fatalf("failed to execute step: %v", err)
+	}
+	if deal, _ = ts.GetDealByID(1); deal.Status != "completed" || deal.SoldAmount != "0.01" {
+		t.Fatalf("expected the last step to complete the deal, got %s sold=%s", deal.Status, deal.SoldAmount)
+	}
+}
+
+func TestFixtures_TrailingAndStopLoss(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	bot := NewBot(1, "Bot", 1, true, WithTrailing("1"), WithStopLoss(StopLoss{Type: "stop_loss_and_disable_bot"}))
+	if bot.StopLossPercentage != nil || *bot.StopLossType != "stop_loss_and_disable_bot" {
+		t.Fatalf("expected an empty stop loss percentage to stay unset, got %v", bot.StopLossPercentage)
+	}
+	ts.AddBot(bot)
+
+	// The deal's own deviation wins over its bot's
+	if err := ts.AddDeal(NewDeal(1, 1, "USDT_BTC", "bought"), WithTrailing("2.5")); err != nil {
+		t.Fatalf("failed to add deal: %v", err)
+	}
+	deal, _ := ts.GetDealByID(1)
+	ext, _ := ts.GetDealExtension(1)
+	if !deal.TrailingEnabled || ext.TrailingDeviation != "2.5" {
+		t.Fatalf("expected trailing with a 2.5%% deviation, got %v %s", deal.TrailingEnabled, ext.TrailingDeviation)
+	}
+
+	ts.AddDeal(NewDeal(2, 1, "USDT_BTC", "bought", WithTrailing("2.5")))
+	if ext, _ = ts.GetDealExtension(2); ext.TrailingDeviation != "1" {
+		t.Fatalf("expected a deal added without the option to take its bot's deviation, got %s", ext.TrailingDeviation)
+	}
+}
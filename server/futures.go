@@ -0,0 +1,238 @@
+package server
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Short and futures deals
+//
+// Short deals sell on entry and buy back on exit: their entry orders are recorded
+// in the sold_* fields, their exits in the bought_* fields, and every price
+// threshold mirrors the long one. Futures deals hold a leveraged position: they
+// lock volume/leverage of margin, report profit percentages against that margin,
+// carry last_known_position_info and are liquidated at the liquidation price
+
+// shortDealType is the type of deals opened by short bots
+const shortDealType = "Deal::ShortDeal"
+
+// futuresMarketType is the market type of deals on futures accounts
+const futuresMarketType = "futures"
+
+// dealStatusLiquidated is the status of a futures deal closed by liquidation
+const dealStatusLiquidated tcmock.DealStatus = "liquidated"
+
+// DealPositionInfo is the exchange position of a futures deal
+type DealPositionInfo struct {
+	Side             string    `json:"side"`
+	Amount           string    `json:"amount"`
+	EntryPrice       string    `json:"entry_price"`
+	MarkPrice        string    `json:"mark_price"`
+	Leverage         string    `json:"leverage"`
+	Margin           string    `json:"margin"`
+	UnrealizedPnl    string    `json:"unrealized_pnl"`
+	LiquidationPrice *string   `json:"liquidation_price"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// isShortDeal reports whether a deal sells on entry
+func isShortDeal(deal *tcmock.Deal) bool {
+	return deal.Type == shortDealType
+}
+
+// dealSide returns the position side of a deal: long or short
+func dealSide(deal *tcmock.Deal) string {
+	if isShortDeal(deal) {
+		return string(tcmock.BotStrategyShort)
+	}
+	return string(tcmock.BotStrategyLong)
+}
+
+// dealCmp compares two prices in a deal's profit direction
+// It is a.Cmp(b) for long deals and b.Cmp(a) for short ones, so a result > 0
+// means a is the more profitable price
+func dealCmp(deal *tcmock.Deal, a, b *big.Rat) int {
+	if isShortDeal(deal) {
+		return b.Cmp(a)
+	}
+	return a.Cmp(b)
+}
+
+// dealPercentFactor returns the factor moving a price pct percent in a deal's
+// profit direction: 1 + pct/100 for long deals, 1 - pct/100 for short ones
+func dealPercentFactor(deal *tcmock.Deal, pct *big.Rat) *big.Rat {
+	if isShortDeal(deal) {
+		return percentFactor(new(big.Rat).Neg(pct))
+	}
+	return percentFactor(pct)
+}
+
+// dealFills points at the amount, volume and average price fields recording one
+// side of a deal's orders
+type dealFills struct {
+	amount, volume, average *string
+}
+
+// entryFills returns the fields recording a deal's entry orders: buys for long deals, sells for short ones
+func entryFills(deal *tcmock.Deal) dealFills {
+	if isShortDeal(deal) {
+		return dealFills{&deal.SoldAmount, &deal.SoldVolume, &deal.SoldAveragePrice}
+	}
+	return dealFills{&deal.BoughtAmount, &deal.BoughtVolume, &deal.BoughtAveragePrice}
+}
+
+// exitFills returns the fields recording a deal's exit orders: sells for long deals, buys for short ones
+func exitFills(deal *tcmock.Deal) dealFills {
+	if isShortDeal(deal) {
+		return dealFills{&deal.BoughtAmount, &deal.BoughtVolume, &deal.BoughtAveragePrice}
+	}
+	return dealFills{&deal.SoldAmount, &deal.SoldVolume, &deal.SoldAveragePrice}
+}
+
+// add records a filled order and recomputes the average price
+func (f dealFills) add(amount, price *big.Rat) {
+	total := new(big.Rat).Add(decimal.Parse(*f.amount), amount)
+	volume := new(big.Rat).Add(decimal.Parse(*f.volume), new(big.Rat).Mul(amount, price))
+
+	*f.amount = decimal.Format(total)
+	*f.volume = decimal.Format(volume)
+	if total.Sign() != 0 {
+		*f.average = decimal.Format(decimal.RoundToStep(new(big.Rat).Quo(volume, total), averagePriceStep))
+	}
+}
+
+// filledAmount returns the filled amount
+func (f dealFills) filledAmount() *big.Rat {
+	return decimal.Parse(*f.amount)
+}
+
+// filledVolume returns the filled volume
+func (f dealFills) filledVolume() *big.Rat {
+	return decimal.Parse(*f.volume)
+}
+
+// averagePrice returns the average fill price
+func (f dealFills) averagePrice() *big.Rat {
+	return decimal.Parse(*f.average)
+}
+
+// openAmount returns the part of a deal's position not exited yet
+func openAmount(deal *tcmock.Deal) *big.Rat {
+	return new(big.Rat).Sub(entryFills(deal).filledAmount(), exitFills(deal).filledAmount())
+}
+
+// dealProfit returns the profit of a deal with its open position valued at price
+func dealProfit(deal *tcmock.Deal, price *big.Rat) *big.Rat {
+	entry, exit := entryFills(deal), exitFills(deal)
+	profit := new(big.Rat).Mul(openAmount(deal), price)
+	profit.Add(profit, exit.filledVolume())
+	profit.Sub(profit, entry.filledVolume())
+	if isShortDeal(deal) {
+		profit.Neg(profit)
+	}
+	return profit
+}
+
+// dealLeverage returns the leverage of a deal: leverage_custom_value on futures, 1 otherwise
+func dealLeverage(deal *tcmock.Deal) *big.Rat {
+	if deal.MarketType != futuresMarketType {
+		return big.NewRat(1, 1)
+	}
+	if value, err := deal.LeverageCustomValue.Get(); err == nil {
+		if leverage := decimal.Parse(value); leverage.Sign() > 0 {
+			return leverage
+		}
+	}
+	return big.NewRat(1, 1)
+}
+
+// orderMargin returns the funds a deal's order of volume locks: the volume divided by the leverage
+func orderMargin(deal *tcmock.Deal, volume *big.Rat) *big.Rat {
+	return new(big.Rat).Quo(volume, dealLeverage(deal))
+}
+
+// dealMargin returns the funds a deal's entry orders lock
+func dealMargin(deal *tcmock.Deal) *big.Rat {
+	return orderMargin(deal, entryFills(deal).filledVolume())
+}
+
+// dealProfitPercentage returns profit as a percentage of a deal's margin
+func dealProfitPercentage(deal *tcmock.Deal, profit *big.Rat) string {
+	margin := dealMargin(deal)
+	if margin.Sign() == 0 {
+		return "0"
+	}
+	pct := new(big.Rat).Quo(profit, margin)
+	return decimal.FormatFixed(pct.Mul(pct, big.NewRat(100, 1)), 2)
+}
+
+// liquidationPrice returns the price at which a futures deal loses its whole margin
+// That is the average entry price moved 100/leverage percent against the deal;
+// false for spot deals and unleveraged longs
+func liquidationPrice(deal *tcmock.Deal, priceStep *big.Rat) (*big.Rat, bool) {
+	avg := entryFills(deal).averagePrice()
+	if deal.MarketType != futuresMarketType || avg.Sign() <= 0 {
+		return nil, false
+	}
+	move := new(big.Rat).Quo(big.NewRat(-100, 1), dealLeverage(deal))
+	price := decimal.RoundToStep(new(big.Rat).Mul(avg, dealPercentFactor(deal, move)), priceStep)
+	return price, price.Sign() > 0
+}
+
+// simulateLiquidationLocked closes a futures deal as liquidated once the price reaches its liquidation price
+// The position is closed at the liquidation price, losing the margin
+// Returns whether the deal closed
+// The caller must hold ts.mu
+func (ts *TestServer) simulateLiquidationLocked(deal *tcmock.Deal, price *big.Rat) bool {
+	liquidation, ok := liquidationPrice(deal, ts.dealLimitsLocked(deal).priceStep)
+	if !ok || dealCmp(deal, price, liquidation) > 0 {
+		return false
+	}
+	ts.closeDealLocked(deal, liquidation, dealStatusLiquidated, "Position liquidated.")
+	return true
+}
+
+// updatePositionInfoLocked records the exchange position of a futures deal at price
+// The caller must hold ts.mu
+func (ts *TestServer) updatePositionInfoLocked(deal *tcmock.Deal, price *big.Rat) {
+	if deal.MarketType != futuresMarketType {
+		return
+	}
+
+	info := &DealPositionInfo{
+		Side:          dealSide(deal),
+		Amount:        decimal.Format(openAmount(deal)),
+		EntryPrice:    decimal.Format(entryFills(deal).averagePrice()),
+		MarkPrice:     decimal.Format(price),
+		Leverage:      decimal.Format(dealLeverage(deal)),
+		Margin:        decimal.Format(dealMargin(deal)),
+		UnrealizedPnl: decimal.Format(dealProfit(deal, price)),
+		UpdatedAt:     ts.nowLocked(),
+	}
+	if liquidation, ok := liquidationPrice(deal, ts.dealLimitsLocked(deal).priceStep); ok {
+		info.LiquidationPrice = ptr(decimal.Format(liquidation))
+	}
+	ts.dealExtensionLocked(deal).LastKnownPositionInfo = info
+}
+
+// accountMarketTypeLocked returns the market type of deals on an account
+// Accounts supporting futures but not spot trade futures
+// The caller must hold ts.mu
+func (ts *TestServer) accountMarketTypeLocked(accountID int) string {
+	entry, ok := ts.accounts[accountID]
+	if !ok {
+		return "spot"
+	}
+	futures, spot := false, false
+	for _, marketType := range entry.account.SupportedMarketTypes {
+		futures = futures || marketType == futuresMarketType
+		spot = spot || marketType == "spot"
+	}
+	if futures && !spot {
+		return futuresMarketType
+	}
+	return "spot"
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// futuresBot adds a futures account with 100 USDT and a 5x short signal bot trading USDT_BTC on it
+// opts apply after the defaults
+func futuresBot(t *testing.T, ts *TestServer, opts ...BotOption) {
+	t.Helper()
+
+	account := NewAccount(1, "Binance Futures", "binance_futures")
+	account.SupportedMarketTypes = []string{"futures"}
+	addSignalBot(t, ts, &account, "100", "Futures Bot", append([]BotOption{
+		WithPairs("USDT_BTC"),
+		WithStrategy(tcmock.BotStrategyShort),
+		WithLeverage(string(tcmock.BotLeverageTypeIsolated), 5),
+		WithBaseOrder("100", "quote_currency"),
+		WithSafetyOrders(SafetyOrders{Max: 1, Volume: "102", StepPercentage: "2"}),
+		WithTakeProfit("2", tcmock.BotTakeProfitTypeTotal),
+	}, opts...)...)
+}
+
+// getDealResponse fetches a deal with its extension fields over HTTP
+func getDealResponse(t *testing.T, ts *TestServer, dealID string) DealResponse {
+	t.Helper()
+
+	resp, err := http.Get(ts.URL() + "/ver1/deals/" + dealID + "/show")
+	if err != nil {
+		t.Fatalf("failed to get deal: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var deal DealResponse
+	if err := json.NewDecoder(resp.Body).Decode(&deal); err != nil {
+		t.Fatalf("failed to decode deal: %v", err)
+	}
+	return deal
+}
+
+func TestFutures_ShortDeal(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	futuresBot(t, ts)
+
+	resp, deal, _ := sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if deal.Type != "Deal::ShortDeal" || deal.MarketType != "futures" || deal.SoldAmount != "0.002" || deal.BoughtAmount != "0" {
+		t.Fatalf("expected a short futures entry, got %s %s sold=%s", deal.Type, deal.MarketType, deal.SoldAmount)
+	}
+	if deal.TakeProfitPrice != "49000" {
+		t.Fatalf("expected take profit below the entry, got %s", deal.TakeProfitPrice)
+	}
+	if balance, _ := ts.GetBalance(1, "USDT"); balance.OnOrders != "20" {
+		t.Fatalf("expected 20 USDT of margin on orders, got %s", balance.OnOrders)
+	}
+
+	// The safety order sells higher
+	ts.SetMarketPrice("USDT_BTC", "51000")
+	got := getDealResponse(t, ts, "1")
+	if got.SoldAmount != "0.004" || got.SoldAveragePrice != "50500" || got.TakeProfitPrice != "49490" {
+		t.Fatalf("expected the safety order to fill, got sold=%s avg=%s tp=%s", got.SoldAmount, got.SoldAveragePrice, got.TakeProfitPrice)
+	}
+	if profit, _ := got.ActualProfit.Get(); profit != "-2" || got.ActualProfitPercentage != "-4.95" {
+		t.Fatalf("expected a loss of 2 (-4.95%% of margin), got %s (%s%%)", profit, got.ActualProfitPercentage)
+	}
+	info := got.LastKnownPositionInfo
+	if info == nil || info.Side != "short" || info.Amount != "0.004" || info.Margin != "40.4" ||
+		info.UnrealizedPnl != "-2" || info.LiquidationPrice == nil || *info.LiquidationPrice != "60600" {
+		t.Fatalf("unexpected position info %+v", info)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "49400")
+	final, _ := ts.GetDealByID(1)
+	if final.Status != "completed" || final.BoughtAmount != "0.004" || final.FinalProfit != "4.4" || final.FinalProfitPercentage != "10.89" {
+		t.Fatalf("expected a completed short with profit 4.4 (10.89%%), got %s %s (%s%%)", final.Status, final.FinalProfit, final.FinalProfitPercentage)
+	}
+	if balance, _ := ts.GetBalance(1, "USDT"); balance.Position != "104.4" || balance.OnOrders != "0" {
+		t.Fatalf("expected the profit to be booked, got %s/%s", balance.Position, balance.OnOrders)
+	}
+}
+
+func TestFutures_Liquidation(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	futuresBot(t, ts,
+		WithStrategy(tcmock.BotStrategyLong),
+		WithLeverage(string(tcmock.BotLeverageTypeIsolated), 10),
+		WithSafetyOrders(SafetyOrders{Max: 0}),
+	)
+	sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`)
+
+	ts.SetMarketPrice("USDT_BTC", "45500")
+	got := getDealResponse(t, ts, "1")
+	if got.Status != "bought" || got.LastKnownPositionInfo == nil || *got.LastKnownPositionInfo.LiquidationPrice != "45000" {
+		t.Fatalf("expected an open deal liquidating at 45000, got %s %+v", got.Status, got.LastKnownPositionInfo)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "44000")
+	deal, _ := ts.GetDealByID(1)
+	if deal.Status != "liquidated" || deal.FinalProfit != "-10" || deal.FinalProfitPercentage != "-100.00" || deal.ClosedAt.IsNull() {
+		t.Fatalf("expected liquidation losing the margin, got %s %s (%s%%)", deal.Status, deal.FinalProfit, deal.FinalProfitPercentage)
+	}
+	if msg := lastBotEvent(t, ts, 1); !strings.HasPrefix(msg, "Position liquidated. Price: 45000 USDT") {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+	if balance, _ := ts.GetBalance(1, "USDT"); balance.Position != "90" {
+		t.Fatalf("expected the margin to be lost, got %s", balance.Position)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Deal lifecycle shared by signals, the deal-start engine and smart trades
+
+// nextDealIDLocked returns the ID after the highest existing deal ID
+// The caller must hold ts.mu
+func (ts *TestServer) nextDealIDLocked() int {
+	id := 1
+	for existing := range ts.deals {
+		if existing >= id {
+			id = existing + 1
+		}
+	}
+	return id
+}
+
+// marketPriceLocked returns the last price of a pair on a market, falling back to the default market
+// The caller must hold ts.mu
+func (ts *TestServer) marketPriceLocked(marketCode, pair string) (*big.Rat, bool) {
+	entry, ok := ts.lookupMarketLocked(marketCode, pair)
+	if !ok {
+		return nil, false
+	}
+	price := decimal.Parse(entry.rates.Last)
+	return price, price.Sign() > 0
+}
+
+// openDealLocked starts a deal for a bot on pair with a market base order at price
+// The deal takes the bot's settings; the base order is checked against the
+// trading limits and the account balance
+// The caller must hold ts.mu
+func (ts *TestServer) openDealLocked(bot *tcmock.Bot, pair string, price *big.Rat) (*tcmock.Deal, *orderError) {
+	deal := NewDeal(ts.nextDealIDLocked(), bot.Id, pair, "bought")
+	applyBotSettings(&deal, bot)
+	deal.MarketType = ts.accountMarketTypeLocked(bot.AccountId)
+	ts.applyReinvestmentLocked(&deal)
+	volume := new(big.Rat)
+	if bot.BaseOrderVolume != nil {
+		volume = decimal.Parse(deal.BaseOrderVolume)
+	}
+
+	marketCode := ts.accountMarketLocked(bot.AccountId)
+	limits := ts.orderLimitsLocked(marketCode, pair)
+	amount, price, err := limits.checkTypedVolume("Base order", "base_order_volume", deal.BaseOrderVolumeType, volume, price)
+	if err == nil && amount == nil {
+		err = &orderError{Field: "pair", Message: "Market price is unknown for " + pair}
+	}
+	quote, _ := splitPair(pair)
+	if err == nil {
+		err = ts.checkFundsLocked(bot.AccountId, quote, orderMargin(&deal, new(big.Rat).Mul(amount, price)), "base_order_volume")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := ts.nowLocked()
+	deal.FromCurrency = quote
+	deal.OrderbookPriceCurrency = quote
+	deal.CreatedAt = now
+	setDealStatus(&deal, tcmock.DealStatusBought, now)
+	deal.SmartTradeConvertable = deal.MarketType != futuresMarketType
+
+	addBotEventAt(&deal, "Placing base order. "+orderSize(&deal, amount, price), now)
+	entryFills(&deal).add(amount, price)
+	deal.BaseOrderAveragePrice = decimal.Format(price)
+	addBotEventAt(&deal, "Base order executed. "+orderSize(&deal, amount, price), now)
+	ts.updateTakeProfitPriceLocked(&deal)
+
+	ts.deals[deal.Id] = &deal
+	ts.dealExtensions[deal.Id] = newDealExtension(bot)
+	ts.repriceDealLocked(&deal)
+	ts.dealChangedLocked(DealCreated, &deal, "")
+	if current, ok := ts.marketPriceLocked(marketCode, pair); ok && ts.simulateDeals {
+		ts.simulateDealLocked(&deal, current)
+	}
+	return &deal, nil
+}
+
+// applyBotSettings copies a bot's deal settings onto a new deal
+func applyBotSettings(deal *tcmock.Deal, bot *tcmock.Bot) {
+	deal.AccountId = bot.AccountId
+	deal.AccountName = bot.AccountName
+	if bot.Name != nil {
+		deal.BotName = *bot.Name
+	}
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&deal.BaseOrderVolume, bot.BaseOrderVolume)
+	set(&deal.SafetyOrderVolume, bot.SafetyOrderVolume)
+	if bot.BaseOrderVolumeType != nil {
+		deal.BaseOrderVolumeType = string(*bot.BaseOrderVolumeType)
+	}
+	if bot.SafetyOrderVolumeType != nil {
+		deal.SafetyOrderVolumeType = string(*bot.SafetyOrderVolumeType)
+	}
+	set(&deal.SafetyOrderStepPercentage, bot.SafetyOrderStepPercentage)
+	set(&deal.MartingaleStepCoefficient, bot.MartingaleStepCoefficient)
+	set(&deal.MartingaleVolumeCoefficient, bot.MartingaleVolumeCoefficient)
+	set(&deal.StopLossPercentage, bot.StopLossPercentage)
+	set(&deal.MinProfitPercentage, bot.MinProfitPercentage)
+	if bot.MinProfitType != nil {
+		deal.MinProfitType = nullable.NewNullableWithValue(string(*bot.MinProfitType))
+	}
+	if bot.CloseStrategyList != nil {
+		deal.CloseStrategyList = strategyMaps(*bot.CloseStrategyList)
+	}
+	if bot.MaxSafetyOrders != nil {
+		deal.MaxSafetyOrders = *bot.MaxSafetyOrders
+	}
+	if bot.TakeProfit != nil {
+		deal.TakeProfit = nullable.NewNullableWithValue(*bot.TakeProfit)
+	}
+	if bot.TakeProfitType != nil {
+		deal.TakeProfitType = tcmock.DealTakeProfitType(*bot.TakeProfitType)
+	}
+	if bot.TrailingEnabled != nil {
+		deal.TrailingEnabled = *bot.TrailingEnabled
+	}
+	if bot.TslEnabled != nil {
+		deal.TslEnabled = *bot.TslEnabled
+	}
+	if bot.StopLossTimeoutEnabled != nil {
+		deal.StopLossTimeoutEnabled = *bot.StopLossTimeoutEnabled
+	}
+	if bot.StopLossTimeoutInSeconds != nil {
+		deal.StopLossTimeoutInSeconds = *bot.StopLossTimeoutInSeconds
+	}
+	if bot.SlToBreakevenEnabled != nil {
+		deal.SlToBreakevenEnabled = *bot.SlToBreakevenEnabled
+	}
+	if bot.ProfitCurrency != nil {
+		deal.ProfitCurrency = string(*bot.ProfitCurrency)
+	}
+	if bot.StopLossType != nil {
+		deal.StopLossType = string(*bot.StopLossType)
+	}
+	if bot.Strategy != nil && *bot.Strategy == tcmock.BotStrategyShort {
+		deal.Type = shortDealType
+	}
+	if bot.LeverageType != nil {
+		deal.LeverageType = string(*bot.LeverageType)
+	}
+	if bot.LeverageCustomValue != nil {
+		deal.LeverageCustomValue = nullable.NewNullableWithValue(decimal.Format(decimal.FromFloat32(*bot.LeverageCustomValue)))
+	}
+}
+
+// addSafetyOrderLocked fills the next safety order of a deal at market price
+// Used for signal-driven safety strategies; the order is sized like a price-triggered one
+// The caller must hold ts.mu
+func (ts *TestServer) addSafetyOrderLocked(deal *tcmock.Deal, price *big.Rat) *orderError {
+	if deal.CompletedSafetyOrdersCount >= deal.MaxSafetyOrders {
+		return &orderError{Field: "action", Message: fmt.Sprintf("Max safety orders reached (%d)", deal.MaxSafetyOrders)}
+	}
+
+	n := deal.CompletedSafetyOrdersCount + 1
+	limits := ts.dealLimitsLocked(deal)
+	amount, price, err := limits.checkTypedVolume("Safety order", "safety_order_volume", deal.SafetyOrderVolumeType, safetyOrderVolume(deal, n), price)
+	if err == nil && amount == nil {
+		err = &orderError{Field: "pair", Message: "Market price is unknown for " + deal.Pair}
+	}
+	if err == nil {
+		err = ts.checkFundsLocked(deal.AccountId, deal.FromCurrency, orderMargin(deal, new(big.Rat).Mul(amount, price)), "safety_order_volume")
+	}
+	if err != nil {
+		return err
+	}
+
+	now := ts.nowLocked()
+	entryFills(deal).add(amount, price)
+	deal.CompletedSafetyOrdersCount++
+	deal.UpdatedAt = now
+	addBotEventAt(deal, fmt.Sprintf("Averaging order (%d out of %d) executed. %s",
+		n, deal.MaxSafetyOrders, orderSize(deal, amount, price)), now)
+	ts.updateTakeProfitPriceLocked(deal)
+	ts.updateActualProfitLocked(deal, price)
+	ts.dealChangedLocked(DealBotEventAdded, deal, "")
+	return nil
+}
+
+// closeDealLocked exits a deal's remaining position at price and finishes it with status
+// The final profit is booked on the account balance
+// The caller must hold ts.mu
+func (ts *TestServer) closeDealLocked(deal *tcmock.Deal, price *big.Rat, status tcmock.DealStatus, message string) {
+	amount := ts.closingAmountLocked(deal, price)
+
+	now := ts.nowLocked()
+	if amount.Sign() > 0 {
+		exitFills(deal).add(amount, price)
+		addBotEventAt(deal, message+" "+orderSize(deal, amount, price), now)
+	}
+
+	profit := dealProfit(deal, price)
+	deal.FinalProfit = decimal.Format(profitInCurrency(deal, profit, price))
+	deal.FinalProfitPercentage = dealProfitPercentage(deal, profit)
+	if usd, ok := ts.usdRateLocked(ts.accountMarketLocked(deal.AccountId), deal.FromCurrency); ok {
+		deal.UsdFinalProfit = decimal.Format(new(big.Rat).Mul(profit, usd))
+	}
+	deal.CurrentPrice = decimal.Format(price)
+	deal.ActualProfit = nullable.NewNullableWithValue(deal.FinalProfit)
+	deal.ActualProfitPercentage = deal.FinalProfitPercentage
+	deal.ActualUsdProfit = nullable.NewNullableWithValue(deal.UsdFinalProfit)
+
+	previous := deal.Status
+	setDealStatus(deal, status, now)
+	ts.settleDealLocked(deal)
+	ts.reinvestLocked(deal, profit)
+	ts.dealChangedLocked(DealStatusChanged, deal, previous)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Trading Limits
+
+// SetTradingLimits sets the exchange trading limits of a pair on the default market
+// The limits are reflected in the currency_rates endpoints and enforced on bot
+// creation, add_funds and simulated orders
+func (ts *TestServer) SetTradingLimits(pair string, limits tcmock.ExchangeTradingLimits) {
+	ts.SetTradingLimitsOn("", pair, limits)
+}
+
+// SetTradingLimitsOn sets the exchange trading limits of a pair on a specific market
+func (ts *TestServer) SetTradingLimitsOn(marketCode, pair string, limits tcmock.ExchangeTradingLimits) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.marketEntryLocked(marketCode, pair)
+	entry.limits = limits
+	applyLimitsToRates(&entry.rates, limits)
+}
+
+// applyLimitsToRates copies the limits into the matching rates fields
+func applyLimitsToRates(rates *tcmock.CurrencyRatesWithLeverageData, limits tcmock.ExchangeTradingLimits) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&rates.LotStep, limits.LotStep)
+	set(&rates.PriceStep, limits.PriceStep)
+	set(&rates.MinLotSize, limits.MinLotSize)
+	set(&rates.MaxLotSize, limits.MaxLotSize)
+	set(&rates.MinTotal, limits.MinTotal)
+	set(&rates.MinPrice, limits.MinPrice)
+	set(&rates.MaxPrice, limits.MaxPrice)
+	set(&rates.MaxMarketBuyAmount, limits.MaxMarketBuyAmount)
+	set(&rates.MaxMarketSellAmount, limits.MaxMarketSellAmount)
+	set(&rates.MinMarketTotal, limits.MarketBuyMinTotal)
+	set(&rates.AskMultiplierUp, limits.PriceMultiplierUp)
+	set(&rates.BidMultiplierDown, limits.PriceMultiplierDown)
+	rates.MinMarketBuyAmount = limits.MinMarketBuyAmount
+	rates.MinMarketSellAmount = limits.MinMarketSellAmount
+}
+
+// orderLimits are the parsed limits of a pair; zero values mean "no limit"
+type orderLimits struct {
+	quote, base string
+
+	lotStep, priceStep *big.Rat
+	minLot, maxLot     *big.Rat
+	minPrice, maxPrice *big.Rat
+	minTotal           *big.Rat
+}
+
+// dealLimitsLocked returns the limits of a deal's pair on the market of its account
+// The caller must hold ts.mu
+func (ts *TestServer) dealLimitsLocked(deal *tcmock.Deal) orderLimits {
+	return ts.orderLimitsLocked(ts.accountMarketLocked(deal.AccountId), deal.Pair)
+}
+
+// orderLimitsLocked returns the limits of a pair
+// Pairs without configured limits only get the default 1e-8 steps
+// The caller must hold ts.mu
+func (ts *TestServer) orderLimitsLocked(marketCode, pair string) orderLimits {
+	limits := tcmock.ExchangeTradingLimits{}
+	if entry, ok := ts.lookupMarketLocked(marketCode, pair); ok {
+		limits = entry.limits
+	}
+
+	value := func(s *string, fallback string) *big.Rat {
+		if s == nil {
+			return decimal.Parse(fallback)
+		}
+		return decimal.Parse(*s)
+	}
+
+	quote, base := splitPair(pair)
+	return orderLimits{
+		quote:     quote,
+		base:      base,
+		lotStep:   value(limits.LotStep, "0.00000001"),
+		priceStep: value(limits.PriceStep, "0.00000001"),
+		minLot:    value(limits.MinLotSize, "0"),
+		maxLot:    value(limits.MaxLotSize, "0"),
+		minPrice:  value(limits.MinPrice, "0"),
+		maxPrice:  value(limits.MaxPrice, "0"),
+		minTotal:  value(limits.MinTotal, "0"),
+	}
+}
+
+// volumeTypeBase is the base_order_volume_type and safety_order_volume_type of
+// volumes given in the base currency; other types are quote currency volumes
+const volumeTypeBase = "base_currency"
+
+// orderError is a trading limit violation with the 3Commas error message
+type orderError struct {
+	Field   string
+	Message string
+}
+
+func (e *orderError) Error() string {
+	return e.Message
+}
+
+// attributes returns the error_attributes body for the violation
+func (e *orderError) attributes() map[string][]string {
+	return map[string][]string{e.Field: {e.Message}}
+}
+
+// checkOrder rounds an order to the pair's lot and price steps and validates it
+// kind names the order in messages ("Base order", "Safety order", "Order")
+// field is the request attribute reported on violations
+// Returns the rounded amount (base currency) and price (quote currency)
+func (l orderLimits) checkOrder(kind, field string, amount, price *big.Rat) (*big.Rat, *big.Rat, *orderError) {
+	amount = decimal.FloorToStep(amount, l.lotStep)
+	price = decimal.RoundToStep(price, l.priceStep)
+
+	if amount.Sign() <= 0 {
+		return nil, nil, &orderError{Field: field, Message: fmt.Sprintf(
+			"%s amount is too small. Lot step: %s %s", kind, decimal.Format(l.lotStep), l.base)}
+	}
+	if l.minLot.Sign() > 0 && amount.Cmp(l.minLot) < 0 {
+		return nil, nil, &orderError{Field: field, Message: fmt.Sprintf(
+			"%s amount is too small. Min: %s %s", kind, decimal.Format(l.minLot), l.base)}
+	}
+	if l.maxLot.Sign() > 0 && amount.Cmp(l.maxLot) > 0 {
+		return nil, nil, &orderError{Field: field, Message: fmt.Sprintf(
+			"%s amount is too big. Max: %s %s", kind, decimal.Format(l.maxLot), l.base)}
+	}
+	if price.Sign() > 0 {
+		if l.minPrice.Sign() > 0 && price.Cmp(l.minPrice) < 0 {
+			return nil, nil, &orderError{Field: field, Message: fmt.Sprintf(
+				"%s price is too low. Min: %s %s", kind, decimal.Format(l.minPrice), l.quote)}
+		}
+		if l.maxPrice.Sign() > 0 && price.Cmp(l.maxPrice) > 0 {
+			return nil, nil, &orderError{Field: field, Message: fmt.Sprintf(
+				"%s price is too high. Max: %s %s", kind, decimal.Format(l.maxPrice), l.quote)}
+		}
+
+		total := new(big.Rat).Mul(amount, price)
+		if l.minTotal.Sign() > 0 && total.Cmp(l.minTotal) < 0 {
+			return nil, nil, &orderError{Field: field, Message: fmt.Sprintf(
+				"%s size is too small. Min: %s %s", kind, decimal.Format(l.minTotal), l.quote)}
+		}
+	}
+
+	return amount, price, nil
+}
+
+// checkTypedVolume validates an order volume of volumeType
+// base_currency volumes are amounts checked like checkOrder, only against the
+// lot limits without a price; other volumes are quote volumes checked like checkVolume
+func (l orderLimits) checkTypedVolume(kind, field, volumeType string, volume, price *big.Rat) (*big.Rat, *big.Rat, *orderError) {
+	if volumeType != volumeTypeBase {
+		return l.checkVolume(kind, field, volume, price)
+	}
+	if price == nil || price.Sign() <= 0 {
+		_, _, err := l.checkOrder(kind, field, volume, new(big.Rat))
+		return nil, nil, err
+	}
+	return l.checkOrder(kind, field, volume, price)
+}
+
+// quoteVolume converts an order volume of volumeType to the quote currency at price
+// False for a base_currency volume without a known price
+func quoteVolume(volumeType string, volume, price *big.Rat) (*big.Rat, bool) {
+	if volumeType != volumeTypeBase {
+		return volume, true
+	}
+	if price == nil || price.Sign() <= 0 {
+		return nil, false
+	}
+	return new(big.Rat).Mul(volume, price), true
+}
+
+// checkVolume validates an order given as a quote currency volume
+// The min notional applies to the requested volume, so flooring the amount to
+// the lot step cannot push an order at exactly minTotal below it
+// Without a price only the min notional can be checked; otherwise the rounded
+// amount and price are returned like checkOrder does
+func (l orderLimits) checkVolume(kind, field string, volume, price *big.Rat) (*big.Rat, *big.Rat, *orderError) {
+	if l.minTotal.Sign() > 0 && volume.Cmp(l.minTotal) < 0 {
+		return nil, nil, &orderError{Field: field, Message: fmt.Sprintf(
+			"%s size is too small. Min: %s %s", kind, decimal.Format(l.minTotal), l.quote)}
+	}
+	if price == nil || price.Sign() <= 0 {
+		return nil, nil, nil
+	}
+
+	l.minTotal = new(big.Rat)
+	return l.checkOrder(kind, field, new(big.Rat).Quo(volume, price), price)
+}
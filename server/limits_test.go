@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// btcLimits are Binance-like USDT_BTC limits
+var btcLimits = tcmock.ExchangeTradingLimits{
+	LotStep:    ptr("0.00001"),
+	PriceStep:  ptr("0.01"),
+	MinLotSize: ptr("0.00001"),
+	MaxLotSize: ptr("9000"),
+	MinTotal:   ptr("10"),
+}
+
+func TestCheckOrder(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetTradingLimits("USDT_BTC", btcLimits)
+	ts.mu.RLock()
+	limits := ts.orderLimitsLocked("", "USDT_BTC")
+	ts.mu.RUnlock()
+
+	tests := []struct {
+		amount, price string
+		wantAmount    string
+		wantPrice     string
+		wantErr       string
+	}{
+		{amount: "0.000345678", price: "50000.004", wantAmount: "0.00034", wantPrice: "50000"},
+		{amount: "0.001", price: "50000.005", wantAmount: "0.001", wantPrice: "50000.01"},
+		{amount: "0.000001", price: "50000", wantErr: "Order amount is too small. Lot step: 0.00001 BTC"},
+		{amount: "10000", price: "50000", wantErr: "Order amount is too big. Max: 9000 BTC"},
+		{amount: "0.0001", price: "50000", wantErr: "Order size is too small. Min: 10 USDT"},
+	}
+	for _, tt := range tests {
+		amount, price, err := limits.checkOrder("Order", "quantity", decimal.Parse(tt.amount), decimal.Parse(tt.price))
+		if tt.wantErr != "" {
+			if err == nil || err.Message != tt.wantErr {
+				t.Errorf("%s @ %s: expected error %q, got %v", tt.amount, tt.price, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s @ %s: unexpected error: %v", tt.amount, tt.price, err)
+			continue
+		}
+		if decimal.Format(amount) != tt.wantAmount || decimal.Format(price) != tt.wantPrice {
+			t.Errorf("%s @ %s: expected %s @ %s, got %s @ %s", tt.amount, tt.price,
+				tt.wantAmount, tt.wantPrice, decimal.Format(amount), decimal.Format(price))
+		}
+	}
+}
+
+func TestTradingLimitsInCurrencyRates(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetTradingLimits("USDT_BTC", btcLimits)
+
+	resp, err := http.Get(ts.URL() + "/ver1/accounts/currency_rates?pair=USDT_BTC")
+	if err != nil {
+		t.Fatalf("failed to GET currency_rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var rates tcmock.CurrencyRates
+	if err := json.NewDecoder(resp.Body).Decode(&rates); err != nil {
+		t.Fatalf("failed to decode rates: %v", err)
+	}
+	if rates.LotStep != "0.00001" || rates.PriceStep != "0.01" || rates.MinTotal != "10" {
+		t.Fatalf("expected limits in rates, got lotStep=%s priceStep=%s minTotal=%s", rates.LotStep, rates.PriceStep, rates.MinTotal)
+	}
+	if rates.Last != "50000" {
+		t.Fatalf("expected price to be kept, got %s", rates.Last)
+	}
+}
+
+func TestCreateBot_TradingLimits(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetTradingLimits("USDT_BTC", btcLimits)
+
+	body := `{"account_id": 1, "name": "DCA", "pairs": ["USDT_BTC"], "base_order_volume": "5",
+		"safety_order_volume": "20", "max_safety_orders": 3}`
+	resp, err := http.Post(ts.URL()+"/ver1/bots/create_bot", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to POST create_bot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", resp.StatusCode)
+	}
+	var errResp tcmock.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	attrs := *errResp.ErrorAttributes
+	if got := attrs["base_order_volume"]; len(got) != 1 || got[0] != "Base order size is too small. Min: 10 USDT" {
+		t.Fatalf("unexpected base_order_volume errors: %v", got)
+	}
+	if _, ok := attrs["safety_order_volume"]; ok {
+		t.Fatalf("safety order volume should be valid, got %v", attrs)
+	}
+	if len(ts.GetAllBots()) != 0 {
+		t.Fatal("invalid bot should not be created")
+	}
+}
+
+func TestCreateBot_BaseCurrencyVolume(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetTradingLimits("USDT_BTC", btcLimits)
+
+	create := func(safetyVolume string) (*http.Response, tcmock.ErrorResponse) {
+		body := `{"account_id": 1, "name": "DCA", "pairs": ["USDT_BTC"], "base_order_volume": "0.001",
+			"base_order_volume_type": "base_currency", "safety_order_volume": "` + safetyVolume + `",
+			"safety_order_volume_type": "base_currency", "max_safety_orders": 1}`
+		resp, err := http.Post(ts.URL()+"/ver1/bots/create_bot", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to POST create_bot: %v", err)
+		}
+		defer resp.Body.Close()
+		var errResp tcmock.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return resp, errResp
+	}
+
+	// 0.0001 BTC is 5 USDT at 50000, below the min notional; 0.001 BTC is 50 USDT
+	resp, errResp := create("0.0001")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", resp.StatusCode)
+	}
+	attrs := *errResp.ErrorAttributes
+	if _, ok := attrs["base_order_volume"]; ok {
+		t.Fatalf("base order volume should be valid, got %v", attrs)
+	}
+	if got := attrs["safety_order_volume"]; len(got) != 1 || got[0] != "Safety order size is too small. Min: 10 USDT" {
+		t.Fatalf("unexpected safety_order_volume errors: %v", got)
+	}
+
+	if resp, _ := create("0.0004"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", resp.StatusCode)
+	}
+	ts.UpdateBotEnabled(1, true)
+	deal, opened, err := ts.StartDeal(1, "USDT_BTC")
+	if err != nil || !opened {
+		t.Fatalf("expected a deal to start, got %v", err)
+	}
+	if deal.BoughtAmount != "0.001" || deal.BoughtVolume != "50" || deal.BaseOrderVolumeType != "base_currency" {
+		t.Fatalf("expected 0.001 BTC bought for 50 USDT, got %s for %s (%s)", deal.BoughtAmount, deal.BoughtVolume, deal.BaseOrderVolumeType)
+	}
+}
+
+func TestCreateBot(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(7, "Existing", 1, true))
+	ts.SetTradingLimits("USDT_BTC", btcLimits)
+
+	body := `{"account_id": 1, "name": "DCA", "pairs": ["USDT_BTC"], "base_order_volume": "10", "take_profit": "1.5"}`
+	resp, err := http.Post(ts.URL()+"/ver1/bots/create_bot", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to POST create_bot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", resp.StatusCode)
+	}
+	var bot tcmock.Bot
+	if err := json.NewDecoder(resp.Body).Decode(&bot); err != nil {
+		t.Fatalf("failed to decode bot: %v", err)
+	}
+	if bot.Id != 8 || bot.IsEnabled || *bot.Name != "DCA" || *bot.TakeProfit != "1.5" {
+		t.Fatalf("unexpected bot: id=%d enabled=%v name=%s", bot.Id, bot.IsEnabled, *bot.Name)
+	}
+	if _, ok := ts.GetBot(8); !ok {
+		t.Fatal("bot should be stored")
+	}
+}
+
+func TestAddFunds(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetTradingLimits("USDT_BTC", btcLimits)
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	deal := NewDeal(100, 1, "USDT_BTC", "bought")
+	deal.BoughtAmount = "0.001"
+	deal.BoughtVolume = "50"
+	ts.AddDeal(deal)
+
+	post := func(body string) *http.Response {
+		resp, err := http.Post(ts.URL()+"/ver1/deals/100/add_funds", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to POST add_funds: %v", err)
+		}
+		return resp
+	}
+
+	resp := post(`{"quantity": 0.0001, "is_market": true}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an order below min notional, got %d", resp.StatusCode)
+	}
+
+	resp = post(`{"quantity": "0.000345678", "is_market": true}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	got, _ := ts.GetDealByID(100)
+	if got.BoughtAmount != "0.00134" || got.BoughtVolume != "67" {
+		t.Fatalf("expected rounded fill (0.00134 BTC for 67 USDT), got %s BTC for %s USDT", got.BoughtAmount, got.BoughtVolume)
+	}
+	if got.CompletedManualSafetyOrdersCount != 1 {
+		t.Fatalf("expected 1 manual safety order, got %d", got.CompletedManualSafetyOrdersCount)
+	}
+	last := *got.BotEvents[len(got.BotEvents)-1].Message
+	if last != "Manual averaging order executed. Price: 50000 USDT Size: 17 USDT (0.00034 BTC)" {
+		t.Fatalf("unexpected bot event: %s", last)
+	}
+}
+
+func TestDealSimulation_SafetyOrders(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetTradingLimits("USDT_BTC", btcLimits)
+	ts.SetDealSimulation(true)
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+
+	deal := NewDeal(100, 1, "USDT_BTC", "bought")
+	deal.BaseOrderAveragePrice = "50000"
+	deal.BoughtAmount = "0.0002"
+	deal.BoughtVolume = "10"
+	deal.BoughtAveragePrice = "50000"
+	deal.MaxSafetyOrders = 3
+	deal.SafetyOrderVolume = "20"
+	deal.SafetyOrderStepPercentage = "1"
+	deal.MartingaleStepCoefficient = "2"
+	deal.MartingaleVolumeCoefficient = "0.25"
+	ts.AddDeal(deal)
+
+	// 1% below the base price fills the first safety order only
+	ts.SetMarketPrice("USDT_BTC", "49500")
+	got, _ := ts.GetDealByID(100)
+	if got.CompletedSafetyOrdersCount != 1 {
+		t.Fatalf("expected 1 safety order, got %d", got.CompletedSafetyOrdersCount)
+	}
+	if got.BoughtAmount != "0.0006" {
+		t.Fatalf("expected 0.0006 BTC bought, got %s", got.BoughtAmount)
+	}
+
+	// The second order (3% down, 5 USDT) is below the 10 USDT min notional
+	ts.SetMarketPrice("USDT_BTC", "48000")
+	got, _ = ts.GetDealByID(100)
+	if got.CompletedSafetyOrdersCount != 1 {
+		t.Fatalf("expected the invalid safety order not to fill, got %d", got.CompletedSafetyOrdersCount)
+	}
+	if msg, _ := got.ErrorMessage.Get(); !got.DealHasError || msg != "Safety order size is too small. Min: 10 USDT" {
+		t.Fatalf("expected min notional error, got %v %q", got.DealHasError, msg)
+	}
+}
+
+func TestDealSimulation_SafetyOrderAtZeroPrice(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+
+	deal := NewDeal(100, 1, "USDT_BTC", "bought", WithBought("0.0002", "10", "50000"))
+	deal.BaseOrderAveragePrice = "50000"
+	deal.MaxSafetyOrders = 2
+	deal.SafetyOrderVolume = "10"
+	deal.SafetyOrderStepPercentage = "50"
+	deal.MartingaleStepCoefficient = "1"
+	deal.MartingaleVolumeCoefficient = "1"
+	ts.AddDeal(deal)
+
+	// The second order's trigger is 100% down, at a price of zero
+	ts.SetMarketPrice("USDT_BTC", "0")
+	if got, _ := ts.GetDealByID(100); got.CompletedSafetyOrdersCount != 1 {
+		t.Fatalf("expected only the first safety order to fill, got %d", got.CompletedSafetyOrdersCount)
+	}
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"cmp"
+	"maps"
+	"math/big"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// PriceFunc computes a pair's price at a point in mock time
+// It runs with the server lock held and must not call back into the TestServer
+type PriceFunc func(now time.Time) string
+
+// marketKey identifies a pair on an exchange
+// An empty MarketCode is the default market used when no exchange-specific entry exists
+type marketKey struct {
+	MarketCode string
+	Pair       string
+}
+
+// marketEntry is the price and limit table row of a pair
+type marketEntry struct {
+	rates     tcmock.CurrencyRatesWithLeverageData
+	limits    tcmock.ExchangeTradingLimits
+	priceFunc PriceFunc
+	// dayOpen and volumeBtc24h feed the deal-start engine; empty when not set
+	dayOpen      string
+	volumeBtc24h string
+	// history records the entry's price changes for computed indicators
+	history []pricePoint
+}
+
+// Market Data
+
+// SetMarketPrice sets the price of a pair on the default market
+// Last, bid and ask (and their orderbook variants) all take the price, and open
+// deals on the pair are repriced
+func (ts *TestServer) SetMarketPrice(pair, price string) {
+	ts.SetMarketPriceOn("", pair, price)
+}
+
+// SetMarketPriceOn sets the price of a pair on a specific market (e.g. "binance")
+func (ts *TestServer) SetMarketPriceOn(marketCode, pair, price string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.marketEntryLocked(marketCode, pair)
+	entry.priceFunc = nil
+	setRatesPrice(&entry.rates, price)
+	ts.priceChangedLocked(marketCode, pair)
+}
+
+// SetCurrencyRates replaces the full rates record of a pair on a market
+// Use "" as marketCode for the default market
+func (ts *TestServer) SetCurrencyRates(marketCode, pair string, rates tcmock.CurrencyRatesWithLeverageData) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.marketEntryLocked(marketCode, pair)
+	entry.priceFunc = nil
+	entry.rates = rates
+	ts.priceChangedLocked(marketCode, pair)
+}
+
+// SetPriceFunc animates a pair's price on the default market
+// fn is evaluated now and every time the mock clock moves (SetClock, AdvanceClock)
+func (ts *TestServer) SetPriceFunc(pair string, fn PriceFunc) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.marketEntryLocked("", pair)
+	entry.priceFunc = fn
+	setRatesPrice(&entry.rates, fn(ts.nowLocked()))
+	ts.priceChangedLocked("", pair)
+}
+
+// MarketPrice returns the last price of a pair on the default market
+func (ts *TestServer) MarketPrice(pair string) (string, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry, ok := ts.lookupMarketLocked("", pair)
+	if !ok {
+		return "", false
+	}
+	return entry.rates.Last, true
+}
+
+// marketEntryLocked returns the entry for a pair, creating it with defaults
+// The caller must hold ts.mu
+func (ts *TestServer) marketEntryLocked(marketCode, pair string) *marketEntry {
+	key := marketKey{MarketCode: marketCode, Pair: pair}
+	entry, ok := ts.markets[key]
+	if !ok {
+		entry = &marketEntry{rates: defaultRates(pair)}
+		ts.markets[key] = entry
+	}
+	return entry
+}
+
+// lookupMarketLocked finds a pair on a market, falling back to the default market
+// The caller must hold ts.mu
+func (ts *TestServer) lookupMarketLocked(marketCode, pair string) (*marketEntry, bool) {
+	if entry, ok := ts.markets[marketKey{MarketCode: marketCode, Pair: pair}]; ok {
+		return entry, true
+	}
+	entry, ok := ts.markets[marketKey{Pair: pair}]
+	return entry, ok
+}
+
+// dealMarketLocked finds the pair of a deal on the market of its account
+// The caller must hold ts.mu
+func (ts *TestServer) dealMarketLocked(deal *tcmock.Deal) (*marketEntry, bool) {
+	return ts.lookupMarketLocked(ts.accountMarketLocked(deal.AccountId), deal.Pair)
+}
+
+// refreshAnimatedPricesLocked re-evaluates every PriceFunc at the current mock time
+// Markets are refreshed in order of market code and pair
+// The caller must hold ts.mu
+func (ts *TestServer) refreshAnimatedPricesLocked() {
+	now := ts.nowLocked()
+	keys := slices.SortedFunc(maps.Keys(ts.markets), func(a, b marketKey) int {
+		return cmp.Or(strings.Compare(a.MarketCode, b.MarketCode), strings.Compare(a.Pair, b.Pair))
+	})
+	for _, key := range keys {
+		entry := ts.markets[key]
+		if entry.priceFunc == nil {
+			continue
+		}
+		setRatesPrice(&entry.rates, entry.priceFunc(now))
+		ts.priceChangedLocked(key.MarketCode, key.Pair)
+	}
+}
+
+// priceChangedLocked records a pair's new price on a market and reprices the
+// open deals priced by it; with simulation enabled, they react to the new price
+// Deals and smart trades are priced by their account's market, or the default
+// market if the pair isn't listed there; open smart trades always react
+// The caller must hold ts.mu
+func (ts *TestServer) priceChangedLocked(marketCode, pair string) {
+	changed, ok := ts.markets[marketKey{MarketCode: marketCode, Pair: pair}]
+	if !ok {
+		return
+	}
+	ts.recordPriceLocked(changed)
+	for _, id := range slices.Sorted(maps.Keys(ts.deals)) {
+		deal := ts.deals[id]
+		if entry, ok := ts.dealMarketLocked(deal); ok && deal.Pair == pair && entry == changed {
+			ts.tickDealLocked(deal)
+		}
+	}
+	ts.priceChangedSmartTradesLocked(changed, pair)
+}
+
+// tickDealLocked brings a deal in line with the current market price
+// The caller must hold ts.mu
+func (ts *TestServer) tickDealLocked(deal *tcmock.Deal) {
+	if price, ok := ts.repriceDealLocked(deal); ok && ts.simulateDeals {
+		ts.simulateDealLocked(deal, price)
+	}
+}
+
+// repriceDealLocked updates the current price and unrealized P/L of a deal
+// Inactive deals and deals without a known market price are left untouched
+// The caller must hold ts.mu
+func (ts *TestServer) repriceDealLocked(deal *tcmock.Deal) (*big.Rat, bool) {
+	if !isActiveDeal(deal) {
+		return nil, false
+	}
+	entry, ok := ts.dealMarketLocked(deal)
+	if !ok {
+		return nil, false
+	}
+
+	price := decimal.Parse(entry.rates.Last)
+	deal.CurrentPrice = decimal.Format(price)
+	ts.updateActualProfitLocked(deal, price)
+	return price, true
+}
+
+// updateActualProfitLocked recomputes the unrealized P/L of a deal at price
+// The caller must hold ts.mu
+func (ts *TestServer) updateActualProfitLocked(deal *tcmock.Deal, price *big.Rat) {
+	profit := dealProfit(deal, price)
+	deal.ActualProfit.Set(decimal.Format(profitInCurrency(deal, profit, price)))
+	deal.ActualProfitPercentage = dealProfitPercentage(deal, profit)
+
+	if usd, ok := ts.usdRateLocked(ts.accountMarketLocked(deal.AccountId), deal.FromCurrency); ok {
+		deal.ActualUsdProfit.Set(decimal.Format(new(big.Rat).Mul(profit, usd)))
+	}
+	ts.updatePositionInfoLocked(deal, price)
+}
+
+// usdRateLocked returns the USD value of one unit of currency on a market
+// Dollar-like currencies are 1, others use the USDT_<currency> price
+// The caller must hold ts.mu
+func (ts *TestServer) usdRateLocked(marketCode, currency string) (*big.Rat, bool) {
+	if usdCurrencies[currency] {
+		return big.NewRat(1, 1), true
+	}
+	entry, ok := ts.lookupMarketLocked(marketCode, "USDT_"+currency)
+	if !ok {
+		return nil, false
+	}
+	return decimal.Parse(entry.rates.Last), true
+}
+
+// splitPair splits a 3Commas pair (QUOTE_BASE, e.g. USDT_BTC) into quote and base currency
+func splitPair(pair string) (quote, base string) {
+	parts := strings.SplitN(strings.ToUpper(pair), "_", 2)
+	if len(parts) != 2 {
+		return "", strings.ToUpper(pair)
+	}
+	return parts[0], parts[1]
+}
+
+// defaultRates returns a spot rates record without limits
+func defaultRates(pair string) tcmock.CurrencyRatesWithLeverageData {
+	quote, _ := splitPair(pair)
+	return tcmock.CurrencyRatesWithLeverageData{
+		Ask:                    "0",
+		AskMultiplierDown:      "0.2",
+		AskMultiplierUp:        "5",
+		Bid:                    "0",
+		BidMultiplierDown:      "0.2",
+		BidMultiplierUp:        "5",
+		InstrumentKind:         "spot",
+		Last:                   "0",
+		LeverageData:           []tcmock.LeverageData{},
+		LotStep:                "0.00000001",
+		MaxLotSize:             "0",
+		MaxMarketBuyAmount:     "0",
+		MaxMarketSellAmount:    "0",
+		MaxPrice:               "0",
+		MaxTotal:               "0",
+		MinLotSize:             "0",
+		MinMarketTotal:         "0",
+		MinPrice:               "0",
+		MinTotal:               "0",
+		OrderbookAsk:           "0",
+		OrderbookBid:           "0",
+		OrderbookLast:          "0",
+		OrderbookPriceCurrency: quote,
+		PriceStep:              "0.00000001",
+		StrategyName:           "spot",
+	}
+}
+
+// setRatesPrice sets every price field of a rates record
+func setRatesPrice(rates *tcmock.CurrencyRatesWithLeverageData, price string) {
+	rates.Last = price
+	rates.Bid = price
+	rates.Ask = price
+	rates.OrderbookLast = price
+	rates.OrderbookBid = price
+	rates.OrderbookAsk = price
+}
+
+// currencyRates drops the leverage data from a rates record
+func currencyRates(r tcmock.CurrencyRatesWithLeverageData) tcmock.CurrencyRates {
+	return tcmock.CurrencyRates{
+		Ask:                    r.Ask,
+		AskMultiplierDown:      r.AskMultiplierDown,
+		AskMultiplierUp:        r.AskMultiplierUp,
+		Bid:                    r.Bid,
+		BidMultiplierDown:      r.BidMultiplierDown,
+		BidMultiplierUp:        r.BidMultiplierUp,
+		ContractStrategyName:   r.ContractStrategyName,
+		InstrumentKind:         r.InstrumentKind,
+		Last:                   r.Last,
+		LotStep:                r.LotStep,
+		MaxLotSize:             r.MaxLotSize,
+		MaxMarketBuyAmount:     r.MaxMarketBuyAmount,
+		MaxMarketSellAmount:    r.MaxMarketSellAmount,
+		MaxPrice:               r.MaxPrice,
+		MaxTotal:               r.MaxTotal,
+		MinLotSize:             r.MinLotSize,
+		MinMarketTotal:         r.MinMarketTotal,
+		MinPrice:               r.MinPrice,
+		MinTotal:               r.MinTotal,
+		OrderbookAsk:           r.OrderbookAsk,
+		OrderbookBid:           r.OrderbookBid,
+		OrderbookLast:          r.OrderbookLast,
+		OrderbookPriceCurrency: r.OrderbookPriceCurrency,
+		PriceStep:              r.PriceStep,
+		StrategyName:           r.StrategyName,
+	}
+}
+
+// ratesForRequest resolves the pair and market_code query parameters
+// Writes the error response and returns false if the pair is missing or unknown
+func (ts *TestServer) ratesForRequest(w http.ResponseWriter, r *http.Request) (tcmock.CurrencyRatesWithLeverageData, bool) {
+	pair := r.URL.Query().Get("pair")
+	if pair == "" {
+		ts.handleParamError(w, r, &tcmock.RequiredParamError{ParamName: "pair"})
+		return tcmock.CurrencyRatesWithLeverageData{}, false
+	}
+
+	entry, ok := ts.lookupMarketLocked(r.URL.Query().Get("market_code"), pair)
+	if !ok {
+		writeError(w, http.StatusNotFound, errorNotFound, "Unknown pair "+pair, nil)
+		return tcmock.CurrencyRatesWithLeverageData{}, false
+	}
+	return entry.rates, true
+}
+
+// handleCurrencyRates serves GET /ver1/accounts/currency_rates
+func (ts *TestServer) handleCurrencyRates(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	rates, ok := ts.ratesForRequest(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, currencyRates(rates))
+}
+
+// handleCurrencyRatesWithLeverage serves GET /ver1/accounts/currency_rates_with_leverage_data
+func (ts *TestServer) handleCurrencyRatesWithLeverage(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	rates, ok := ts.ratesForRequest(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, rates)
+}
+
+// handleMarketPairs serves GET /ver1/accounts/market_pairs
+// Lists the pairs of the requested market_code plus those of the default market
+func (ts *TestServer) handleMarketPairs(w http.ResponseWriter, r *http.Request) {
+	marketCode := r.URL.Query().Get("market_code")
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	seen := map[string]bool{}
+	pairs := tcmock.Pairs{}
+	for key := range ts.markets {
+		if key.MarketCode != "" && key.MarketCode != marketCode {
+			continue
+		}
+		if !seen[key.Pair] {
+			seen[key.Pair] = true
+			pairs = append(pairs, key.Pair)
+		}
+	}
+	sort.Strings(pairs)
+
+	writeJSON(w, http.StatusOK, tcmock.PairsResponse{Pairs: pairs})
+}
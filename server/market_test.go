@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestCurrencyRates(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000.5")
+
+	resp, err := http.Get(ts.URL() + "/ver1/accounts/currency_rates?pair=USDT_BTC&market_code=binance")
+	if err != nil {
+		t.Fatalf("failed to GET currency_rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var rates tcmock.CurrencyRates
+	if err := json.NewDecoder(resp.Body).Decode(&rates); err != nil {
+		t.Fatalf("failed to decode rates: %v", err)
+	}
+	if rates.Last != "50000.5" || rates.Bid != "50000.5" || rates.Ask != "50000.5" {
+		t.Fatalf("expected price 50000.5, got last=%s bid=%s ask=%s", rates.Last, rates.Bid, rates.Ask)
+	}
+	if rates.OrderbookPriceCurrency != "USDT" {
+		t.Fatalf("expected orderbook price currency USDT, got %s", rates.OrderbookPriceCurrency)
+	}
+}
+
+func TestCurrencyRates_Errors(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{url: "/ver1/accounts/currency_rates", status: http.StatusBadRequest},
+		{url: "/ver1/accounts/currency_rates?pair=USDT_XYZ", status: http.StatusNotFound},
+		{url: "/ver1/accounts/currency_rates_with_leverage_data?pair=USDT_XYZ", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL() + tt.url)
+		if err != nil {
+			t.Fatalf("failed to GET %s: %v", tt.url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.url, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestCurrencyRatesWithLeverageData_PerMarket(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	rates := defaultRates("USDT_BTC")
+	setRatesPrice(&rates, "49000")
+	rates.LeverageData = []tcmock.LeverageData{{Code: ptr("BTCUSDT"), MaxLeverage: ptr("125")}}
+	ts.SetCurrencyRates("binance_futures", "USDT_BTC", rates)
+	ts.SetMarketPrice("USDT_BTC", "50000")
+
+	resp, err := http.Get(ts.URL() + "/ver1/accounts/currency_rates_with_leverage_data?pair=USDT_BTC&market_code=binance_futures")
+	if err != nil {
+		t.Fatalf("failed to GET rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var got tcmock.CurrencyRatesWithLeverageData
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode rates: %v", err)
+	}
+	if got.Last != "49000" {
+		t.Fatalf("expected market-specific price 49000, got %s", got.Last)
+	}
+	if len(got.LeverageData) != 1 || *got.LeverageData[0].MaxLeverage != "125" {
+		t.Fatalf("expected leverage data, got %+v", got.LeverageData)
+	}
+}
+
+func TestMarketPairs(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_ETH", "3000")
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetMarketPriceOn("kraken", "USD_BTC", "50000")
+
+	resp, err := http.Get(ts.URL() + "/ver1/accounts/market_pairs?market_code=binance")
+	if err != nil {
+		t.Fatalf("failed to GET market_pairs: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var pairs tcmock.PairsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		t.Fatalf("failed to decode pairs: %v", err)
+	}
+	if len(pairs.Pairs) != 2 || pairs.Pairs[0] != "USDT_BTC" || pairs.Pairs[1] != "USDT_ETH" {
+		t.Fatalf("expected [USDT_BTC USDT_ETH], got %v", pairs.Pairs)
+	}
+}
+
+func TestMarketPrice_DrivesDealProfit(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	deal := NewDeal(101, 1, "USDT_BTC", "bought")
+	deal.BoughtAmount = "0.002"
+	deal.BoughtVolume = "100"
+	ts.AddDeal(deal)
+
+	ts.SetMarketPrice("USDT_BTC", "55000")
+
+	got, _ := ts.GetDealByID(101)
+	if got.CurrentPrice != "55000" {
+		t.Fatalf("expected current price 55000, got %s", got.CurrentPrice)
+	}
+	if profit, _ := got.ActualProfit.Get(); profit != "10" {
+		t.Fatalf("expected actual profit 10, got %s", profit)
+	}
+	if usd, _ := got.ActualUsdProfit.Get(); usd != "10" {
+		t.Fatalf("expected actual USD profit 10, got %s", usd)
+	}
+	if got.ActualProfitPercentage != "10.00" {
+		t.Fatalf("expected actual profit percentage 10.00, got %s", got.ActualProfitPercentage)
+	}
+}
+
+func TestMarketPrice_AccountMarket(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(1, "Binance", "binance"))
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetMarketPriceOn("binance", "USDT_BTC", "55000")
+	ts.AddBot(NewBot(1, "Binance Bot", 1, true))
+	ts.AddBot(NewBot(2, "Other Bot", 2, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought", WithBought("0.002", "100", "50000")))
+	ts.AddDeal(NewDeal(102, 2, "USDT_BTC", "bought", WithBought("0.002", "100", "50000"), WithDeal(func(deal *tcmock.Deal) {
+		deal.AccountId = 2
+	})))
+
+	binance, _ := ts.GetDealByID(101)
+	other, _ := ts.GetDealByID(102)
+	if binance.CurrentPrice != "55000" || other.CurrentPrice != "50000" {
+		t.Fatalf("expected each deal priced by its account's market, got %s and %s", binance.CurrentPrice, other.CurrentPrice)
+	}
+	if profit, _ := binance.ActualProfit.Get(); profit != "10" {
+		t.Fatalf("expected actual profit 10 at the binance price, got %s", profit)
+	}
+
+	// The default market only moves deals without an exchange-specific price
+	ts.SetMarketPrice("USDT_BTC", "45000")
+	binance, _ = ts.GetDealByID(101)
+	other, _ = ts.GetDealByID(102)
+	if binance.CurrentPrice != "55000" || other.CurrentPrice != "45000" {
+		t.Fatalf("expected only the default market deal to move, got %s and %s", binance.CurrentPrice, other.CurrentPrice)
+	}
+}
+
+func TestPriceFunc_AnimatesWithClock(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts.SetClock(start)
+
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	deal := NewDeal(101, 1, "USDT_BTC", "bought")
+	deal.BoughtAmount = "0.002"
+	deal.BoughtVolume = "100"
+	ts.AddDeal(deal)
+
+	// Price drops 1000 per hour from 50000
+	hourly := []string{"50000", "49000", "48000"}
+	ts.SetPriceFunc("USDT_BTC", func(now time.Time) string {
+		return hourly[int(now.Sub(start)/time.Hour)]
+	})
+
+	ts.AdvanceClock(2 * time.Hour)
+
+	if price, _ := ts.MarketPrice("USDT_BTC"); price != "48000" {
+		t.Fatalf("expected price 48000 after 2h, got %s", price)
+	}
+	got, _ := ts.GetDealByID(101)
+	if profit, _ := got.ActualProfit.Get(); profit != "-4" {
+		t.Fatalf("expected actual profit -4, got %s", profit)
+	}
+	if !ts.Now().Equal(start.Add(2 * time.Hour)) {
+		t.Fatalf("expected clock at %s, got %s", start.Add(2*time.Hour), ts.Now())
+	}
+}
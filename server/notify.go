@@ -0,0 +1,59 @@
+package server
+
+import (
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// DealChangeKind identifies what changed about a deal
+type DealChangeKind string
+
+const (
+	// DealCreated is emitted when a deal is added to the mock
+	DealCreated DealChangeKind = "deal_created"
+	// DealStatusChanged is emitted when a deal's status changes
+	DealStatusChanged DealChangeKind = "deal_status_changed"
+	// DealBotEventAdded is emitted when a bot event is appended to a deal
+	DealBotEventAdded DealChangeKind = "deal_bot_event_added"
+	// DealUpdated is emitted when a deal's settings are edited
+	DealUpdated DealChangeKind = "deal_updated"
+)
+
+// DealChange describes a single mutation of a deal
+type DealChange struct {
+	Kind           DealChangeKind    `json:"kind"`
+	Deal           DealResponse      `json:"deal"`
+	PreviousStatus tcmock.DealStatus `json:"previous_status,omitempty"`
+	OccurredAt     time.Time         `json:"occurred_at"`
+}
+
+// dealListener receives deal changes
+// Listeners are called with ts.mu held and must not block or call back into the TestServer
+type dealListener func(DealChange)
+
+// addDealListener registers a listener for deal changes
+func (ts *TestServer) addDealListener(l dealListener) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.dealListeners = append(ts.dealListeners, l)
+}
+
+// dealChangedLocked notifies listeners about a deal mutation
+// The caller must hold ts.mu
+func (ts *TestServer) dealChangedLocked(kind DealChangeKind, deal *tcmock.Deal, previousStatus tcmock.DealStatus) {
+	if len(ts.dealListeners) == 0 {
+		return
+	}
+
+	change := DealChange{
+		Kind:           kind,
+		Deal:           ts.dealResponseLocked(deal),
+		PreviousStatus: previousStatus,
+		OccurredAt:     ts.nowLocked(),
+	}
+	for _, l := range ts.dealListeners {
+		l(change)
+	}
+}
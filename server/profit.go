@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Profit currency and reinvestment
+//
+// Deals with profit_currency base_currency report actual_profit and final_profit
+// in the base currency and, when they close in profit, exit only enough of the
+// position to recover the quote volume, keeping the rest as profit. Futures deals
+// always take profit in the quote currency
+//
+// Bots with reinvesting_percentage add that share of each realized profit to a
+// per-bot reinvested volume; risk_reduction_percentage takes that share of each
+// realized loss off it. New deals spread the reinvested volume over their base
+// and safety orders in proportion to their share of the deal's maximum volume
+
+// baseProfitCurrency is the profit currency keeping profits in the base currency
+const baseProfitCurrency = "base_currency"
+
+// profitInBase reports whether a deal takes its profit in the base currency
+func profitInBase(deal *tcmock.Deal) bool {
+	return deal.ProfitCurrency == baseProfitCurrency && deal.MarketType != futuresMarketType
+}
+
+// profitInCurrency converts a deal's quote profit to its profit currency at price
+func profitInCurrency(deal *tcmock.Deal, quoteProfit, price *big.Rat) *big.Rat {
+	if !profitInBase(deal) || price.Sign() <= 0 {
+		return quoteProfit
+	}
+	return decimal.RoundToStep(new(big.Rat).Quo(quoteProfit, price), averagePriceStep)
+}
+
+// closingAmountLocked returns how much of a deal's position to exit when closing at price
+// That is the open amount, or for profitable base-currency deals just enough to
+// recover the quote volume
+// The caller must hold ts.mu
+func (ts *TestServer) closingAmountLocked(deal *tcmock.Deal, price *big.Rat) *big.Rat {
+	remaining := openAmount(deal)
+	if !profitInBase(deal) || dealProfit(deal, price).Sign() <= 0 {
+		return remaining
+	}
+
+	owed := new(big.Rat).Sub(entryFills(deal).filledVolume(), exitFills(deal).filledVolume())
+	amount := decimal.RoundToStep(owed.Quo(owed, price), ts.dealLimitsLocked(deal).lotStep)
+	if !isShortDeal(deal) && amount.Cmp(remaining) > 0 {
+		return remaining
+	}
+	return amount
+}
+
+// SetBotReinvestedVolume sets the reinvested quote volume new deals of a bot spread over their orders
+// A negative volume shrinks the orders, as accumulated risk reduction does
+func (ts *TestServer) SetBotReinvestedVolume(botID int, volume string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	bot, ok := ts.bots[botID]
+	if !ok {
+		return fmt.Errorf("bot %d not found", botID)
+	}
+	ts.botReinvested[botID] = decimal.Parse(volume)
+	ts.updateReinvestedVolumeUsdLocked(bot)
+	return nil
+}
+
+// GetBotReinvestedVolume returns the reinvested quote volume of a bot
+func (ts *TestServer) GetBotReinvestedVolume(botID int) (string, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	if _, ok := ts.bots[botID]; !ok {
+		return "", false
+	}
+	volume, ok := ts.botReinvested[botID]
+	if !ok {
+		return "0", true
+	}
+	return decimal.Format(volume), true
+}
+
+// reinvestLocked adds the reinvested share of a closed deal's quote profit to its bot
+// Profits count with reinvesting_percentage and losses with risk_reduction_percentage
+// The caller must hold ts.mu
+func (ts *TestServer) reinvestLocked(deal *tcmock.Deal, quoteProfit *big.Rat) {
+	bot, ok := ts.bots[deal.BotId]
+	if !ok {
+		return
+	}
+
+	pct := bot.ReinvestingPercentage
+	if quoteProfit.Sign() < 0 {
+		pct = bot.RiskReductionPercentage
+	}
+	if pct == nil || decimal.Parse(*pct).Sign() <= 0 || quoteProfit.Sign() == 0 {
+		return
+	}
+
+	share := new(big.Rat).Mul(quoteProfit, decimal.Parse(*pct))
+	share.Quo(share, big.NewRat(100, 1))
+	volume, ok := ts.botReinvested[bot.Id]
+	if !ok {
+		volume = new(big.Rat)
+		ts.botReinvested[bot.Id] = volume
+	}
+	volume.Add(volume, decimal.RoundToStep(share, averagePriceStep))
+	ts.updateReinvestedVolumeUsdLocked(bot)
+}
+
+// updateReinvestedVolumeUsdLocked refreshes a bot's reinvested_volume_usd
+// The caller must hold ts.mu
+func (ts *TestServer) updateReinvestedVolumeUsdLocked(bot *tcmock.Bot) {
+	volume, ok := ts.botReinvested[bot.Id]
+	if !ok {
+		return
+	}
+	quote := ""
+	if len(bot.Pairs) > 0 {
+		quote, _ = splitPair(bot.Pairs[0])
+	}
+	usd, ok := ts.usdRateLocked(ts.accountMarketLocked(bot.AccountId), quote)
+	if !ok {
+		return
+	}
+	value, _ := new(big.Rat).Mul(volume, usd).Float32()
+	bot.ReinvestedVolumeUsd = nullable.NewNullableWithValue(value)
+	bot.UpdatedAt = ts.nowLocked()
+}
+
+// applyReinvestmentLocked spreads its bot's reinvested volume over a new deal's orders
+// The base and safety order volumes scale by (max volume + reinvested) / max volume,
+// where the max volume is the base order plus every safety order; they never go below 0
+// The caller must hold ts.mu
+func (ts *TestServer) applyReinvestmentLocked(deal *tcmock.Deal) {
+	reinvested, ok := ts.botReinvested[deal.BotId]
+	if !ok || reinvested.Sign() == 0 {
+		return
+	}
+
+	maxVolume := decimal.Parse(deal.BaseOrderVolume)
+	for n := 1; n <= deal.MaxSafetyOrders; n++ {
+		maxVolume.Add(maxVolume, safetyOrderVolume(deal, n))
+	}
+	if maxVolume.Sign() <= 0 {
+		return
+	}
+
+	factor := new(big.Rat).Add(maxVolume, reinvested)
+	factor.Quo(factor, maxVolume)
+	if factor.Sign() < 0 {
+		factor = new(big.Rat)
+	}
+	scale := func(volume string) string {
+		return decimal.Format(decimal.RoundToStep(new(big.Rat).Mul(decimal.Parse(volume), factor), averagePriceStep))
+	}
+	deal.BaseOrderVolume = scale(deal.BaseOrderVolume)
+	deal.SafetyOrderVolume = scale(deal.SafetyOrderVolume)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// profitBot adds a spot account with 1000 USDT and a signal bot with a 2% take
+// profit and no safety orders
+// opts apply after the defaults
+func profitBot(t *testing.T, ts *TestServer, opts ...BotOption) {
+	t.Helper()
+
+	account := NewAccount(1, "Main", "binance")
+	addSignalBot(t, ts, &account, "1000", "Profit Bot", append([]BotOption{
+		WithPairs("USDT_BTC"),
+		WithBaseOrder("100", "quote_currency"),
+		WithSafetyOrders(SafetyOrders{Max: 0, Volume: "100"}),
+		WithTakeProfit("2", tcmock.BotTakeProfitTypeTotal),
+	}, opts...)...)
+	ts.SetBalance(1, "BTC", "0")
+}
+
+const startSignal = `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`
+
+func TestProfitCurrency_Base(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	profitBot(t, ts, WithProfitCurrency(tcmock.BotProfitCurrencyBaseCurrency))
+	sendSignal(t, ts, startSignal)
+
+	ts.SetMarketPrice("USDT_BTC", "50500")
+	deal, _ := ts.GetDealByID(1)
+	if profit, _ := deal.ActualProfit.Get(); profit != "0.0000198" {
+		t.Fatalf("expected an actual profit of 0.0000198 BTC, got %s", profit)
+	}
+
+	// Only the quote volume is recovered; the rest of the position is the profit
+	ts.SetMarketPrice("USDT_BTC", "51000")
+	deal, _ = ts.GetDealByID(1)
+	if deal.Status != "completed" || deal.SoldAmount != "0.00196078" || deal.FinalProfit != "0.00003922" || deal.UsdFinalProfit != "2" {
+		t.Fatalf("expected a base profit of 0.00003922 BTC, got %s sold=%s profit=%s usd=%s",
+			deal.Status, deal.SoldAmount, deal.FinalProfit, deal.UsdFinalProfit)
+	}
+	if balance, _ := ts.GetBalance(1, "BTC"); balance.Position != "0.00003922" {
+		t.Fatalf("expected the profit on the BTC balance, got %s", balance.Position)
+	}
+	if balance, _ := ts.GetBalance(1, "USDT"); balance.Position != "1000" || balance.OnOrders != "0" {
+		t.Fatalf("expected the USDT balance to be unchanged, got %s/%s", balance.Position, balance.OnOrders)
+	}
+}
+
+func TestReinvestmentAndRiskReduction(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	profitBot(t, ts, WithReinvestment("50", "50"))
+
+	// A profit of 2 reinvests 1
+	sendSignal(t, ts, startSignal)
+	ts.SetMarketPrice("USDT_BTC", "51000")
+	if deal, _ := ts.GetDealByID(1); deal.Status != "completed" || deal.FinalProfit != "2" {
+		t.Fatalf("expected the first deal to complete with profit 2, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	bot, _ := ts.GetBot(1)
+	if usd, err := bot.ReinvestedVolumeUsd.Get(); err != nil || usd != 1 {
+		t.Fatalf("expected 1 USD reinvested, got %v", usd)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	_, deal, _ := sendSignal(t, ts, startSignal)
+	if deal.BaseOrderVolume != "101" || deal.BoughtAmount != "0.00202" {
+		t.Fatalf("expected a grown base order of 101, got %s (%s BTC)", deal.BaseOrderVolume, deal.BoughtAmount)
+	}
+
+	// A loss of 5.05 takes 2.525 off the reinvested volume
+	ts.SetMarketPrice("USDT_BTC", "47500")
+	sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC", "action": "close_at_market_price"}`)
+	if deal, _ := ts.GetDealByID(2); deal.Status != "panic_sold" || deal.FinalProfit != "-5.05" {
+		t.Fatalf("expected the second deal to close with a loss of 5.05, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if volume, _ := ts.GetBotReinvestedVolume(1); volume != "-1.525" {
+		t.Fatalf("expected -1.525 reinvested, got %s", volume)
+	}
+
+	_, deal, _ = sendSignal(t, ts, startSignal)
+	if deal.BaseOrderVolume != "98.475" {
+		t.Fatalf("expected a reduced base order of 98.475, got %s", deal.BaseOrderVolume)
+	}
+}
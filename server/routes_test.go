@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestRoutes_PublicAPIPrefix(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+
+	for _, url := range []string{
+		ts.URL() + "/ver1/deals/101/show",
+		ts.URL() + "/public/api/ver1/deals/101/show",
+		ts.APIURL() + "/ver1/deals/101/show",
+	} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("failed to GET %s: %v", url, err)
+		}
+		var deal tcmock.Deal
+		err = json.NewDecoder(resp.Body).Decode(&deal)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", url, err)
+		}
+		if deal.Id != 101 {
+			t.Fatalf("%s: expected deal ID 101, got %d", url, deal.Id)
+		}
+	}
+}
+
+func TestRoutes_CustomBaseURL(t *testing.T) {
+	ts := NewTestServer(t, WithBaseURLs("/api/"))
+	defer ts.Close()
+
+	if ts.APIURL() != ts.URL()+"/api" {
+		t.Fatalf("expected APIURL %s/api, got %s", ts.URL(), ts.APIURL())
+	}
+
+	resp, err := http.Get(ts.URL() + "/api/ver1/bots")
+	if err != nil {
+		t.Fatalf("failed to GET bots: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL() + "/ver1/bots")
+	if err != nil {
+		t.Fatalf("failed to GET bots: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unconfigured prefix, got %d", resp.StatusCode)
+	}
+}
+
+func TestRoutes_UnknownRoute(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.APIURL() + "/ver1/unknown")
+	if err != nil {
+		t.Fatalf("failed to GET unknown route: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON content type, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	var errResp tcmock.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if errResp.Error != "not_found" {
+		t.Fatalf("expected error not_found, got %s", errResp.Error)
+	}
+}
+
+func TestRoutes_StubMatchesAnyPrefix(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.On(http.MethodGet, "/ver1/bots").Once().Respond(http.StatusAccepted, "[]")
+
+	resp, err := http.Get(ts.APIURL() + "/ver1/bots")
+	if err != nil {
+		t.Fatalf("failed to GET bots: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected stubbed status 202, got %d", resp.StatusCode)
+	}
+
+	ts.AssertExpectations(t)
+}
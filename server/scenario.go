@@ -0,0 +1,437 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"math/big"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Scenario generator
+//
+// GenerateScenario fills the mock with bots and deals drawn from a seeded
+// random source, so the same seed and config always produce the same data.
+// Deals are built the way the mock builds its own: the base order and the
+// executed safety orders follow the bot's settings, bought and sold amounts,
+// averages and profits are exact sums of the fills, and bot_events record every
+// order with its size, so event-driven financials replay to the same numbers.
+// A bot's finished deals come first in time and its open deals are the latest
+
+// ScenarioConfig configures GenerateScenario
+// Zero fields take the defaults
+type ScenarioConfig struct {
+	Bots        int            // Bots to add; 3 by default
+	DealsPerBot int            // Deals per bot; 10 by default
+	Pairs       []string       // Pairs the bots trade, each bot a subset; USDT_BTC and USDT_ETH by default
+	StatusMix   map[string]int // Relative weights of deal statuses; mostly completed by default
+	TimeRange   TimeRange      // Span of the deals; January 2024 by default
+	AccountID   int            // Account of the bots, added if missing; 1 by default
+}
+
+// TimeRange is the span of time from Start to End
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// defaultStatusMix is the status mix of scenarios that don't set one
+var defaultStatusMix = map[string]int{
+	"completed":          12,
+	"bought":             3,
+	"panic_sold":         1,
+	"stop_loss_finished": 1,
+	"cancelled":          1,
+	"failed":             1,
+}
+
+// scenarioBasePrices are the reference prices of pairs without a market price, by base currency
+var scenarioBasePrices = map[string]int64{"BTC": 50000, "ETH": 3000, "BNB": 400, "SOL": 100}
+
+// GenerateScenario adds bots and deals drawn from seed, reproducibly
+// Bots and deals take IDs after the existing ones; the deals' final and actual
+// profits, safety order counts and bot_events are consistent with their fills
+func (ts *TestServer) GenerateScenario(seed int64, cfg ScenarioConfig) error {
+	if cfg.Bots < 0 || cfg.DealsPerBot < 0 {
+		return fmt.Errorf("bots and deals per bot can't be negative")
+	}
+	cfg = cfg.withDefaults()
+	g := &scenarioGenerator{ts: ts, cfg: cfg, rng: rand.New(rand.NewPCG(uint64(seed), 0)), basePrices: map[string]*big.Rat{}}
+	for _, status := range slices.Sorted(maps.Keys(cfg.StatusMix)) {
+		if _, ok := dealStatuses[tcmock.DealStatus(status)]; !ok {
+			return fmt.Errorf("unknown deal status %q", status)
+		}
+		// Only futures deals are liquidated, and scenarios trade spot
+		if status == "liquidated" {
+			return fmt.Errorf("scenario deals are spot and can't be %s", status)
+		}
+		if weight := cfg.StatusMix[status]; weight > 0 {
+			g.statuses = append(g.statuses, status)
+			g.weights = append(g.weights, weight)
+			g.total += weight
+		}
+	}
+	if g.total == 0 {
+		return fmt.Errorf("status mix has no positive weight")
+	}
+	if !cfg.TimeRange.End.After(cfg.TimeRange.Start) {
+		return fmt.Errorf("time range ends before it starts")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry, ok := ts.accounts[cfg.AccountID]
+	if !ok {
+		account := NewAccount(cfg.AccountID, "Scenario Account", "binance")
+		account.CreatedAt, account.UpdatedAt = cfg.TimeRange.Start, cfg.TimeRange.Start
+		entry = ts.addAccountLocked(account)
+	}
+	g.account = entry.account
+
+	botID := 1
+	for existing := range ts.bots {
+		if existing >= botID {
+			botID = existing + 1
+		}
+	}
+	dealID := ts.nextDealIDLocked()
+	for i := 0; i < cfg.Bots; i++ {
+		bot, deals := g.bot(botID+i, dealID)
+		dealID += len(deals)
+		ts.addBotLocked(bot)
+		for _, deal := range deals {
+			if err := ts.addDealLocked(deal.deal, deal.reserved); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// withDefaults fills the zero fields of a scenario config
+func (cfg ScenarioConfig) withDefaults() ScenarioConfig {
+	if cfg.Bots == 0 {
+		cfg.Bots = 3
+	}
+	if cfg.DealsPerBot == 0 {
+		cfg.DealsPerBot = 10
+	}
+	if len(cfg.Pairs) == 0 {
+		cfg.Pairs = []string{"USDT_BTC", "USDT_ETH"}
+	}
+	if len(cfg.StatusMix) == 0 {
+		cfg.StatusMix = defaultStatusMix
+	}
+	if cfg.TimeRange.Start.IsZero() && cfg.TimeRange.End.IsZero() {
+		cfg.TimeRange = TimeRange{
+			Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	if cfg.AccountID == 0 {
+		cfg.AccountID = 1
+	}
+	return cfg
+}
+
+// scenarioGenerator draws the bots and deals of a scenario
+type scenarioGenerator struct {
+	ts         *TestServer
+	cfg        ScenarioConfig
+	rng        *rand.Rand
+	statuses   []string // Sorted, for a reproducible draw
+	weights    []int
+	total      int
+	account    Account
+	basePrices map[string]*big.Rat
+}
+
+// scenarioDeal is a generated deal and the volume its active safety order reserves
+type scenarioDeal struct {
+	deal     tcmock.Deal
+	reserved string
+}
+
+// percent draws a percentage between lo and hi hundredths
+func (g *scenarioGenerator) percent(lo, hi int64) *big.Rat {
+	return big.NewRat(lo+g.rng.Int64N(hi-lo+1), 100)
+}
+
+// pick draws one of options
+func (g *scenarioGenerator) pick(options ...string) string {
+	return options[g.rng.IntN(len(options))]
+}
+
+// between draws a time from a to b in whole seconds, or returns a if b isn't after it
+func (g *scenarioGenerator) between(a, b time.Time) time.Time {
+	if !b.After(a) {
+		return a
+	}
+	return a.Add(time.Duration(g.rng.Int64N(int64(b.Sub(a)/time.Second)+1)) * time.Second)
+}
+
+// status draws a deal status from the status mix
+func (g *scenarioGenerator) status() string {
+	n := g.rng.IntN(g.total)
+	for i, weight := range g.weights {
+		if n < weight {
+			return g.statuses[i]
+		}
+		n -= weight
+	}
+	return g.statuses[len(g.statuses)-1]
+}
+
+// bot draws a bot and its deals, numbered from dealID
+func (g *scenarioGenerator) bot(id, dealID int) (tcmock.Bot, []scenarioDeal) {
+	pairs := slices.Clone(g.cfg.Pairs)
+	g.rng.Shuffle(len(pairs), func(i, j int) { pairs[i], pairs[j] = pairs[j], pairs[i] })
+	pairs = pairs[:1+g.rng.IntN(len(pairs))]
+
+	name := fmt.Sprintf("Scenario Bot %d", id)
+	stopLoss := "0"
+	if g.rng.IntN(2) == 0 {
+		stopLoss = decimal.Format(g.percent(300, 1000))
+	}
+	settings := []FixtureOption{
+		WithName(name),
+		WithAccount(g.account.Id, g.account.Name),
+		WithBaseOrder(g.pick("10", "20", "50", "100"), "quote_currency"),
+		WithSafetyOrders(SafetyOrders{
+			Max:                         g.rng.IntN(6),
+			Volume:                      g.pick("10", "20", "50", "100"),
+			VolumeType:                  "quote_currency",
+			StepPercentage:              decimal.Format(g.percent(50, 300)),
+			MartingaleVolumeCoefficient: g.pick("1", "1.05", "1.5", "2"),
+			MartingaleStepCoefficient:   g.pick("1", "1.1", "1.2"),
+		}),
+		WithTakeProfit(decimal.Format(g.percent(50, 300)), tcmock.BotTakeProfitTypeTotal),
+		WithStopLoss(StopLoss{Percentage: stopLoss, Type: "stop_loss"}),
+	}
+
+	// Finished deals take the earliest times, open deals the latest
+	var finished, open []string
+	for i := 0; i < g.cfg.DealsPerBot; i++ {
+		if status := g.status(); dealStatuses[tcmock.DealStatus(status)].finished {
+			finished = append(finished, status)
+		} else {
+			open = append(open, status)
+		}
+	}
+	times := make([]time.Time, g.cfg.DealsPerBot)
+	for i := range times {
+		times[i] = g.between(g.cfg.TimeRange.Start, g.cfg.TimeRange.End)
+	}
+	slices.SortFunc(times, time.Time.Compare)
+
+	botOpts := make([]BotOption, 0, len(settings)+3)
+	for _, opt := range settings {
+		botOpts = append(botOpts, opt)
+	}
+	bot := NewBot(id, name, g.account.Id, true, append(botOpts,
+		WithPairs(pairs...),
+		WithTimes(g.cfg.TimeRange.Start, g.cfg.TimeRange.End),
+		WithDealStart(DealStart{MaxActiveDeals: max(1, len(open)), AllowedDealsOnSamePair: max(1, len(open))}),
+	)...)
+
+	deals := make([]scenarioDeal, 0, g.cfg.DealsPerBot)
+	finishedProfit, activeProfit := new(big.Rat), new(big.Rat)
+	for i, status := range append(finished, open...) {
+		deal := g.deal(dealID+i, id, pairs[g.rng.IntN(len(pairs))], status, times[i], settings)
+		if isProfitRealized(&deal.deal) {
+			finishedProfit.Add(finishedProfit, decimal.Parse(deal.deal.UsdFinalProfit))
+		}
+		if usd, err := deal.deal.ActualUsdProfit.Get(); err == nil && isActiveDeal(&deal.deal) {
+			activeProfit.Add(activeProfit, decimal.Parse(usd))
+		}
+		deals = append(deals, deal)
+	}
+	bot.ActiveDealsCount = len(open)
+	bot.FinishedDealsCount = strconv.Itoa(len(finished))
+	bot.FinishedDealsProfitUsd = decimal.Format(finishedProfit)
+	bot.ActiveDealsUsdProfit = decimal.Format(activeProfit)
+	return bot, deals
+}
+
+// deal draws a deal of a bot on pair, created at created and ending in status
+// The caller must hold ts.mu
+func (g *scenarioGenerator) deal(id, botID int, pair, status string, created time.Time, settings []FixtureOption) scenarioDeal {
+	dealOpts := make([]DealOption, 0, len(settings)+2)
+	for _, opt := range settings {
+		dealOpts = append(dealOpts, opt)
+	}
+	deal := NewDeal(id, botID, pair, "created", append(dealOpts, WithPairs(pair), WithTimes(created, created))...)
+	info := dealStatuses[tcmock.DealStatus(status)]
+	end := g.cfg.TimeRange.End
+	closed := g.between(created.Add(5*time.Minute), created.Add(72*time.Hour))
+	if closed.After(end) {
+		closed = end
+	}
+	lastAt := end
+	if info.finished {
+		lastAt = closed
+	}
+
+	limits := g.ts.orderLimitsLocked(g.account.MarketCode, pair)
+	basePrice := g.price(new(big.Rat).Mul(g.basePrice(pair), percentFactor(g.percent(-1000, 1000))), limits)
+	baseAmount := g.amount(decimal.Parse(deal.BaseOrderVolume), basePrice, limits)
+	addBotEventAt(&deal, "Placing base order. "+orderSize(&deal, baseAmount, basePrice), created)
+
+	switch status {
+	case "created", "base_order_placed", "cancel_pending":
+		setDealStatus(&deal, tcmock.DealStatus(status), created)
+		return scenarioDeal{deal: deal, reserved: "0"}
+	case "cancelled":
+		addBotEventAt(&deal, "Deal cancelled.", closed)
+		setDealStatus(&deal, tcmock.DealStatus(status), closed)
+		return scenarioDeal{deal: deal, reserved: "0"}
+	case "failed":
+		deal.DealHasError = true
+		deal.ErrorMessage = nullable.NewNullableWithValue("Insufficient funds")
+		addBotEventAt(&deal, "Deal failed: Insufficient funds.", closed)
+		setDealStatus(&deal, tcmock.DealStatus(status), closed)
+		return scenarioDeal{deal: deal, reserved: "0"}
+	}
+
+	// Base order and executed safety orders
+	at := created
+	addBotEventAt(&deal, "Base order executed. "+orderSize(&deal, baseAmount, basePrice), at)
+	entryFills(&deal).add(baseAmount, basePrice)
+	deal.BaseOrderAveragePrice = decimal.Format(basePrice)
+	lastPrice := basePrice
+
+	safetyOrder := func(n int) (string, *big.Rat, *big.Rat) {
+		price := g.price(safetyOrderPrice(&deal, basePrice, n), limits)
+		amount := g.amount(safetyOrderVolume(&deal, n), price, limits)
+		return fmt.Sprintf("(%d out of %d)", n, deal.MaxSafetyOrders), amount, price
+	}
+	filled := g.rng.IntN(deal.MaxSafetyOrders + 1)
+	for n := 1; n <= filled; n++ {
+		position, amount, price := safetyOrder(n)
+		addBotEventAt(&deal, "Placing averaging order "+position+". "+orderSize(&deal, amount, price), at)
+		at = g.between(at, lastAt)
+		addBotEventAt(&deal, "Averaging order "+position+" executed. "+orderSize(&deal, amount, price), at)
+		entryFills(&deal).add(amount, price)
+		lastPrice = price
+	}
+	deal.CompletedSafetyOrdersCount = filled
+	reserved := "0"
+	if filled < deal.MaxSafetyOrders {
+		position, amount, price := safetyOrder(filled + 1)
+		addBotEventAt(&deal, "Placing averaging order "+position+". "+orderSize(&deal, amount, price), at)
+		if info.finished {
+			addBotEventAt(&deal, "Averaging order "+position+" cancelled. "+orderSize(&deal, amount, price), closed)
+		} else {
+			deal.ActiveSafetyOrdersCount = 1
+			deal.CurrentActiveSafetyOrders = 1
+			deal.CurrentActiveSafetyOrdersCount = 1
+			reserved = decimal.Format(new(big.Rat).Mul(amount, price))
+		}
+	}
+	g.ts.updateTakeProfitPriceLocked(&deal)
+	deal.TakeProfitPrice = decimal.Format(g.price(decimal.Parse(deal.TakeProfitPrice), limits))
+
+	if !info.finished {
+		current := g.price(new(big.Rat).Mul(lastPrice, percentFactor(g.percent(-300, 200))), limits)
+		deal.CurrentPrice = decimal.Format(current)
+		g.setProfit(&deal, current, false)
+		deal.SmartTradeConvertable = true
+		setDealStatus(&deal, tcmock.DealStatus(status), at)
+		return scenarioDeal{deal: deal, reserved: reserved}
+	}
+
+	// Exit
+	var exit *big.Rat
+	message := ""
+	switch status {
+	case "completed":
+		exit, message = decimal.Parse(deal.TakeProfitPrice), "Take profit executed."
+	case "stop_loss_finished":
+		exit, message = new(big.Rat).Mul(lastPrice, percentFactor(g.percent(-500, -100))), "Stop Loss triggered."
+	case "switched":
+		addBotEventAt(&deal, "Deal converted to SmartTrade.", closed)
+	default:
+		avg := entryFills(&deal).averagePrice()
+		exit, message = new(big.Rat).Mul(avg, percentFactor(g.percent(-500, 200))), "Panic sell executed."
+	}
+	deal.CurrentPrice = decimal.Format(lastPrice)
+	if exit != nil {
+		exit = g.price(exit, limits)
+		amount := decimal.Parse(*entryFills(&deal).amount)
+		exitFills(&deal).add(amount, exit)
+		addBotEventAt(&deal, message+" "+orderSize(&deal, amount, exit), closed)
+		deal.CurrentPrice = decimal.Format(exit)
+		g.setProfit(&deal, exit, true)
+	}
+	setDealStatus(&deal, tcmock.DealStatus(status), closed)
+	return scenarioDeal{deal: deal, reserved: reserved}
+}
+
+// setProfit sets a deal's actual profit at price, and its final profit if final
+// The caller must hold ts.mu
+func (g *scenarioGenerator) setProfit(deal *tcmock.Deal, price *big.Rat, final bool) {
+	profit := dealProfit(deal, price)
+	value := decimal.Format(profitInCurrency(deal, profit, price))
+	percentage := dealProfitPercentage(deal, profit)
+	usd := "0"
+	if rate, ok := g.ts.usdRateLocked(g.account.MarketCode, deal.FromCurrency); ok {
+		usd = decimal.Format(new(big.Rat).Mul(profit, rate))
+	}
+	deal.ActualProfit = nullable.NewNullableWithValue(value)
+	deal.ActualProfitPercentage = percentage
+	deal.ActualUsdProfit = nullable.NewNullableWithValue(usd)
+	if final {
+		deal.FinalProfit, deal.FinalProfitPercentage, deal.UsdFinalProfit = value, percentage, usd
+	}
+}
+
+// basePrice returns the reference price of a pair: its market price, or one drawn once per pair
+// The caller must hold ts.mu
+func (g *scenarioGenerator) basePrice(pair string) *big.Rat {
+	if price, ok := g.basePrices[pair]; ok {
+		return price
+	}
+	price, ok := g.ts.marketPriceLocked(g.account.MarketCode, pair)
+	if !ok {
+		_, base := splitPair(pair)
+		if reference, known := scenarioBasePrices[base]; known {
+			price = big.NewRat(reference, 1)
+		} else {
+			price = g.percent(50, 50000)
+		}
+	}
+	g.basePrices[pair] = price
+	return price
+}
+
+// price rounds a drawn price to the pair's price step, at least cents above 10 and 1e-6 below
+func (g *scenarioGenerator) price(price *big.Rat, limits orderLimits) *big.Rat {
+	step := decimal.Step(6)
+	if price.Cmp(big.NewRat(10, 1)) >= 0 {
+		step = decimal.Step(2)
+	}
+	if limits.priceStep.Cmp(step) > 0 {
+		step = limits.priceStep
+	}
+	return decimal.RoundToStep(price, step)
+}
+
+// amount returns the base amount bought with volume at price, floored to the pair's lot step
+func (g *scenarioGenerator) amount(volume, price *big.Rat, limits orderLimits) *big.Rat {
+	step := decimal.Step(6)
+	if limits.lotStep.Cmp(step) > 0 {
+		step = limits.lotStep
+	}
+	amount := decimal.FloorToStep(new(big.Rat).Quo(volume, price), step)
+	if amount.Sign() == 0 {
+		return step
+	}
+	return amount
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// scenarioDeals generates a scenario on a new server and returns its deals ordered by ID
+func scenarioDeals(t *testing.T, seed int64, cfg ScenarioConfig, eventFinancials bool) []tcmock.Deal {
+	t.Helper()
+
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetEventDrivenFinancials(eventFinancials)
+	if err := ts.GenerateScenario(seed, cfg); err != nil {
+		t.Fatalf("failed to generate the scenario: %v", err)
+	}
+	deals := ts.GetAllDeals()
+	slices.SortFunc(deals, func(a, b tcmock.Deal) int { return a.Id - b.Id })
+	return deals
+}
+
+func TestScenario_Reproducible(t *testing.T) {
+	cfg := ScenarioConfig{Bots: 4, DealsPerBot: 25, Pairs: []string{"USDT_BTC", "USDT_ETH", "USDT_ADA"}}
+	first, _ := json.Marshal(scenarioDeals(t, 42, cfg, false))
+	second, _ := json.Marshal(scenarioDeals(t, 42, cfg, false))
+	if string(first) != string(second) {
+		t.Fatal("expected the same seed to generate the same deals")
+	}
+	other, _ := json.Marshal(scenarioDeals(t, 43, cfg, false))
+	if string(first) == string(other) {
+		t.Fatal("expected another seed to generate other deals")
+	}
+}
+
+func TestScenario_Consistent(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	cfg := ScenarioConfig{
+		Bots:        5,
+		DealsPerBot: 40,
+		StatusMix:   map[string]int{"completed": 5, "bought": 2, "stop_loss_finished": 1, "panic_sold": 1, "cancelled": 1},
+		TimeRange:   TimeRange{Start: start, End: start.Add(7 * 24 * time.Hour)},
+	}
+	deals := scenarioDeals(t, 7, cfg, false)
+	if len(deals) != 200 {
+		t.Fatalf("expected 200 deals, got %d", len(deals))
+	}
+
+	for _, deal := range deals {
+		if deal.CreatedAt.Before(cfg.TimeRange.Start) || deal.CreatedAt.After(cfg.TimeRange.End) {
+			t.Fatalf("deal %d created outside the time range at %v", deal.Id, deal.CreatedAt)
+		}
+		closedAt, err := deal.ClosedAt.Get()
+		if deal.Finished != (err == nil) || (err == nil && (closedAt.Before(deal.CreatedAt) || closedAt.After(cfg.TimeRange.End))) {
+			t.Fatalf("deal %d (%s) has an inconsistent closed_at %v", deal.Id, deal.Status, closedAt)
+		}
+		if deal.LocalizedStatus == "" {
+			t.Fatalf("deal %d has no localized status", deal.Id)
+		}
+		switch deal.Status {
+		case "completed":
+			if decimal.Parse(deal.FinalProfit).Sign() <= 0 || deal.SoldAmount != deal.BoughtAmount {
+				t.Fatalf("completed deal %d has profit %s, sold %s of %s", deal.Id, deal.FinalProfit, deal.SoldAmount, deal.BoughtAmount)
+			}
+		case "stop_loss_finished":
+			if decimal.Parse(deal.FinalProfit).Sign() >= 0 {
+				t.Fatalf("stop loss deal %d has profit %s", deal.Id, deal.FinalProfit)
+			}
+		case "cancelled":
+			if deal.BoughtAmount != "0" {
+				t.Fatalf("cancelled deal %d bought %s", deal.Id, deal.BoughtAmount)
+			}
+		}
+		for i := 1; i < len(deal.BotEvents); i++ {
+			if deal.BotEvents[i].CreatedAt.Before(*deal.BotEvents[i-1].CreatedAt) {
+				t.Fatalf("deal %d has bot events out of order", deal.Id)
+			}
+		}
+	}
+
+	// Replaying the bot events gives back the generated financials
+	replayed := scenarioDeals(t, 7, cfg, true)
+	for i, deal := range deals {
+		got := replayed[i]
+		if got.BoughtVolume != deal.BoughtVolume || got.BoughtAveragePrice != deal.BoughtAveragePrice ||
+			got.SoldVolume != deal.SoldVolume || got.FinalProfit != deal.FinalProfit ||
+			got.CompletedSafetyOrdersCount != deal.CompletedSafetyOrdersCount ||
+			got.CurrentActiveSafetyOrdersCount != deal.CurrentActiveSafetyOrdersCount {
+			t.Fatalf("deal %d replays to bought %s @ %s sold %s profit %s, generated bought %s @ %s sold %s profit %s",
+				deal.Id, got.BoughtVolume, got.BoughtAveragePrice, got.SoldVolume, got.FinalProfit,
+				deal.BoughtVolume, deal.BoughtAveragePrice, deal.SoldVolume, deal.FinalProfit)
+		}
+	}
+}
+
+func TestScenario_Config(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(5, "Existing", 1, true))
+	created := map[int]string{}
+	ts.addDealListener(func(change DealChange) {
+		if change.Kind == DealCreated {
+			created[change.Deal.Id] = change.Deal.ReservedBaseCoin
+		}
+	})
+	if err := ts.GenerateScenario(1, ScenarioConfig{Bots: 2, DealsPerBot: 3, StatusMix: map[string]int{"bought": 1}}); err != nil {
+		t.Fatalf("failed to generate the scenario: %v", err)
+	}
+	for id, reserved := range created {
+		if ext, _ := ts.GetDealExtension(id); ext.ReservedBaseCoin != reserved {
+			t.Fatalf("deal %d was created with reserved %s, holds %s", id, reserved, ext.ReservedBaseCoin)
+		}
+	}
+	bot, ok := ts.GetBot(7)
+	if !ok || bot.ActiveDealsCount != 3 || *bot.MaxActiveDeals != 3 || len(ts.GetBotDeals(7)) != 3 {
+		t.Fatalf("expected bots after the existing one with 3 open deals, got %v", ok)
+	}
+
+	err := ts.GenerateScenario(1, ScenarioConfig{StatusMix: map[string]int{"sold": 1}})
+	if err == nil || !strings.Contains(err.Error(), `unknown deal status "sold"`) {
+		t.Fatalf("expected the unknown status to be rejected, got %v", err)
+	}
+	err = ts.GenerateScenario(1, ScenarioConfig{StatusMix: map[string]int{"completed": 1, "liquidated": 1}})
+	if err == nil || !strings.Contains(err.Error(), "can't be liquidated") {
+		t.Fatalf("expected liquidated spot deals to be rejected, got %v", err)
+	}
+	for _, cfg := range []ScenarioConfig{{Bots: -1}, {DealsPerBot: -1}} {
+		if err := ts.GenerateScenario(1, cfg); err == nil || !strings.Contains(err.Error(), "can't be negative") {
+			t.Fatalf("expected %+v to be rejected, got %v", cfg, err)
+		}
+	}
+}
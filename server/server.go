@@ -1,12 +1,15 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/recomma/3commas-mock/tcmock"
 )
@@ -17,43 +20,203 @@ type TestServer struct {
 	mu     sync.RWMutex
 
 	// State
-	bots  map[int]*tcmock.Bot
-	deals map[int]*tcmock.Deal
+	bots       map[int]*tcmock.Bot
+	botSecrets map[string]int
+	botSignals map[int]SignalCredentials
+	// botReinvested is the reinvested quote volume per bot
+	botReinvested map[int]*big.Rat
+	deals         map[int]*tcmock.Deal
+
+	dealExtensions map[int]*DealExtension
+	markets        map[marketKey]*marketEntry
+	clock          *time.Time
+	// closeEvaluators override the built-in close strategy evaluators
+	closeEvaluators map[string]CloseEvaluator
+	indicators      map[indicatorKey]string
+	// pendingStarts are deal starts waiting out deal_start_delay_seconds
+	pendingStarts []pendingDealStart
+
+	smartTrades map[int]*smartTradeEntry
+
+	accounts     map[int]*accountEntry
+	marketList   []tcmock.MarketListItem
+	strategyList tcmock.StrategyList
 
 	// Configuration
 	allowDuplicateIDs bool
+	baseURLs          []string
+	simulateDeals     bool
+	eventFinancials   bool
 
 	// Error simulation
 	rateLimitEnabled bool
 	rateLimitRetry   int
 	botErrors        map[int]error
 	dealErrors       map[int]error
+
+	// Stubs
+	expectations    []*Expectation
+	unexpectedCalls []string
+
+	// Push notifications
+	dealListeners []dealListener
+	webhooks      *webhookDispatcher
+	ws            *wsHub
+}
+
+// DefaultBaseURLs are the path prefixes the API is served under by default
+// "/public/api" matches the production base URL (https://api.3commas.io/public/api)
+// and "" keeps the bare /ver1/... paths working
+var DefaultBaseURLs = []string{"/public/api", ""}
+
+// Option configures a TestServer
+type Option func(*TestServer)
+
+// WithBaseURLs sets the path prefixes the API is served under
+// The first prefix is the one returned by APIURL
+func WithBaseURLs(prefixes ...string) Option {
+	return func(ts *TestServer) {
+		ts.baseURLs = nil
+		for _, prefix := range prefixes {
+			ts.baseURLs = append(ts.baseURLs, strings.TrimSuffix(prefix, "/"))
+		}
+	}
 }
 
 // NewTestServer creates a new mock 3Commas server for testing
-func NewTestServer(t *testing.T) *TestServer {
+func NewTestServer(t *testing.T, opts ...Option) *TestServer {
 	ts := &TestServer{
-		bots:       make(map[int]*tcmock.Bot),
-		deals:      make(map[int]*tcmock.Deal),
-		botErrors:  make(map[int]error),
-		dealErrors: make(map[int]error),
+		bots:            make(map[int]*tcmock.Bot),
+		botSecrets:      make(map[string]int),
+		botSignals:      make(map[int]SignalCredentials),
+		botReinvested:   make(map[int]*big.Rat),
+		deals:           make(map[int]*tcmock.Deal),
+		dealExtensions:  make(map[int]*DealExtension),
+		markets:         make(map[marketKey]*marketEntry),
+		closeEvaluators: make(map[string]CloseEvaluator),
+		indicators:      make(map[indicatorKey]string),
+		accounts:        make(map[int]*accountEntry),
+		marketList:      DefaultMarketList,
+		strategyList:    DefaultStrategyList,
+		smartTrades:     make(map[int]*smartTradeEntry),
+		botErrors:       make(map[int]error),
+		dealErrors:      make(map[int]error),
+		baseURLs:        DefaultBaseURLs,
+		webhooks:        newWebhookDispatcher(),
+		ws:              newWSHub(),
+	}
+	for _, opt := range opts {
+		opt(ts)
 	}
 
-	// Create HTTP handler using the generated HandlerFromMux
-	handler := tcmock.HandlerFromMux(ts, http.NewServeMux())
-	ts.server = httptest.NewServer(handler)
+	ts.addDealListener(ts.webhooks.dealChanged)
+	ts.addDealListener(ts.ws.dealChanged)
+
+	ts.server = httptest.NewServer(ts.stubMiddleware(ts.routes()))
 
 	return ts
 }
 
+// routes builds the HTTP handler serving the API under every configured base URL
+func (ts *TestServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	for _, baseURL := range ts.baseURLs {
+		// Generated routes for the ServerInterface methods
+		tcmock.HandlerWithOptions(ts, tcmock.StdHTTPServerOptions{
+			BaseURL:          baseURL,
+			BaseRouter:       mux,
+			ErrorHandlerFunc: ts.handleParamError,
+		})
+		ts.registerRoutes(mux, baseURL)
+	}
+
+	// ActionCable stream, served at the root like wss://ws.3commas.io/websocket
+	mux.HandleFunc("GET /websocket", ts.handleWebSocket)
+
+	// Custom signals, served at the root like https://api.3commas.io/trade_signal/trading_view
+	mux.HandleFunc("POST /trade_signal/trading_view", ts.handleTradeSignal)
+
+	// Anything else is an unknown route
+	mux.HandleFunc("/", ts.notFound)
+
+	return mux
+}
+
+// registerRoutes registers the endpoints that are not part of the generated ServerInterface
+func (ts *TestServer) registerRoutes(mux *http.ServeMux, baseURL string) {
+	// Statistics
+	mux.HandleFunc("GET "+baseURL+"/ver1/bots/stats", ts.handleBotsStats)
+	mux.HandleFunc("GET "+baseURL+"/ver1/bots/{bot_id}/deals_stats", ts.handleDealsStats)
+	mux.HandleFunc("GET "+baseURL+"/ver1/bots/{bot_id}/profit_by_day", ts.handleProfitByDay)
+
+	// Market data
+	mux.HandleFunc("GET "+baseURL+"/ver1/accounts/currency_rates", ts.handleCurrencyRates)
+	mux.HandleFunc("GET "+baseURL+"/ver1/accounts/currency_rates_with_leverage_data", ts.handleCurrencyRatesWithLeverage)
+	mux.HandleFunc("GET "+baseURL+"/ver1/accounts/market_pairs", ts.handleMarketPairs)
+
+	// Accounts
+	mux.HandleFunc("GET "+baseURL+"/ver1/accounts", ts.handleListAccounts)
+	mux.HandleFunc("GET "+baseURL+"/ver1/accounts/market_list", ts.handleMarketList)
+	mux.HandleFunc("GET "+baseURL+"/ver1/accounts/{account_id}", ts.handleGetAccount)
+	mux.HandleFunc("POST "+baseURL+"/ver1/accounts/{account_id}/load_balances", ts.handleLoadBalances)
+	mux.HandleFunc("POST "+baseURL+"/ver1/accounts/{account_id}/account_table_data", ts.handleAccountTableData)
+
+	// Bots
+	mux.HandleFunc("GET "+baseURL+"/ver1/bots/strategy_list", ts.handleStrategyList)
+	mux.HandleFunc("POST "+baseURL+"/ver1/bots/create_bot", ts.handleCreateBot)
+	mux.HandleFunc("PATCH "+baseURL+"/ver1/bots/{bot_id}/update_bot", ts.handleUpdateBot)
+	mux.HandleFunc("POST "+baseURL+"/ver1/bots/copy_and_create", ts.handleCopyAndCreate)
+
+	// Deals
+	mux.HandleFunc("GET "+baseURL+"/ver1/deals/{deal_id}/data_for_adding_funds", ts.handleDataForAddingFunds)
+	mux.HandleFunc("POST "+baseURL+"/ver1/deals/{deal_id}/add_funds", ts.handleAddFunds)
+	mux.HandleFunc("PATCH "+baseURL+"/ver1/deals/{deal_id}/update_deal", ts.handleUpdateDeal)
+	mux.HandleFunc("POST "+baseURL+"/ver1/deals/{deal_id}/convert_to_smart_trade", ts.handleConvertDeal)
+
+	// SmartTrade v2
+	mux.HandleFunc("GET "+baseURL+"/v2/smart_trades", ts.handleListSmartTrades)
+	mux.HandleFunc("POST "+baseURL+"/v2/smart_trades", ts.handleCreateSmartTrade)
+	mux.HandleFunc("GET "+baseURL+"/v2/smart_trades/{id}", ts.handleGetSmartTrade)
+	mux.HandleFunc("DELETE "+baseURL+"/v2/smart_trades/{id}", ts.handleCancelSmartTrade)
+	mux.HandleFunc("POST "+baseURL+"/v2/smart_trades/{id}/close_by_market", ts.handleCloseSmartTradeByMarket)
+	mux.HandleFunc("GET "+baseURL+"/v2/smart_trades/{id}/trades", ts.handleSmartTradeOrders)
+}
+
+// notFound answers unknown routes with a 3Commas-style JSON 404
+func (ts *TestServer) notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, errorNotFound, fmt.Sprintf("No route matches %s %s", r.Method, r.URL.Path), nil)
+}
+
+// trimBaseURL strips the longest configured base URL from path
+func (ts *TestServer) trimBaseURL(path string) string {
+	longest := ""
+	for _, baseURL := range ts.baseURLs {
+		if len(baseURL) > len(longest) && strings.HasPrefix(path, baseURL+"/") {
+			longest = baseURL
+		}
+	}
+	return strings.TrimPrefix(path, longest)
+}
+
 // URL returns the base URL of the mock server
 func (ts *TestServer) URL() string {
 	return ts.server.URL
 }
 
-// Close shuts down the mock server
+// APIURL returns the mock server URL including the primary base URL
+// Point an SDK's production base URL (https://api.3commas.io/public/api) here
+func (ts *TestServer) APIURL() string {
+	if len(ts.baseURLs) == 0 {
+		return ts.server.URL
+	}
+	return ts.server.URL + ts.baseURLs[0]
+}
+
+// Close shuts down the mock server, its stream connections and webhook delivery
 func (ts *TestServer) Close() {
+	ts.ws.close()
 	ts.server.Close()
+	ts.webhooks.reset()
 }
 
 // Reset clears all state
@@ -62,11 +225,29 @@ func (ts *TestServer) Reset() {
 	defer ts.mu.Unlock()
 
 	ts.bots = make(map[int]*tcmock.Bot)
+	ts.botSecrets = make(map[string]int)
+	ts.botSignals = make(map[int]SignalCredentials)
+	ts.botReinvested = make(map[int]*big.Rat)
 	ts.deals = make(map[int]*tcmock.Deal)
+	ts.dealExtensions = make(map[int]*DealExtension)
+	ts.markets = make(map[marketKey]*marketEntry)
+	ts.clock = nil
+	ts.closeEvaluators = make(map[string]CloseEvaluator)
+	ts.indicators = make(map[indicatorKey]string)
+	ts.pendingStarts = nil
+	ts.accounts = make(map[int]*accountEntry)
+	ts.marketList = DefaultMarketList
+	ts.strategyList = DefaultStrategyList
+	ts.smartTrades = make(map[int]*smartTradeEntry)
 	ts.botErrors = make(map[int]error)
 	ts.dealErrors = make(map[int]error)
 	ts.rateLimitEnabled = false
 	ts.allowDuplicateIDs = false
+	ts.simulateDeals = false
+	ts.eventFinancials = false
+	ts.expectations = nil
+	ts.unexpectedCalls = nil
+	ts.webhooks.reset()
 }
 
 // AllowDuplicateIDs enables or disables duplicate ID checking
@@ -88,12 +269,7 @@ func (ts *TestServer) ListBots(w http.ResponseWriter, r *http.Request, params tc
 		if ts.rateLimitRetry > 0 {
 			w.Header().Set("Retry-After", fmt.Sprintf("%d", ts.rateLimitRetry))
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusTooManyRequests)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":             "rate limit exceeded",
-			"error_description": "You have exceeded the rate limit. Please try again later.",
-		})
+		writeError(w, http.StatusTooManyRequests, "rate limit exceeded", "You have exceeded the rate limit. Please try again later.", nil)
 		return
 	}
 
@@ -112,9 +288,7 @@ func (ts *TestServer) ListBots(w http.ResponseWriter, r *http.Request, params tc
 		result = append(result, *bot)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	writeJSON(w, http.StatusOK, result)
 }
 
 // ListDeals implements the ServerInterface method for GET /ver1/deals
@@ -123,7 +297,7 @@ func (ts *TestServer) ListDeals(w http.ResponseWriter, r *http.Request, params t
 	defer ts.mu.RUnlock()
 
 	// Filter deals based on parameters
-	var result []tcmock.Deal
+	var result []DealResponse
 	for _, deal := range ts.deals {
 		// Apply bot_id filter if provided
 		if params.BotId != nil && deal.BotId != *params.BotId {
@@ -135,12 +309,11 @@ func (ts *TestServer) ListDeals(w http.ResponseWriter, r *http.Request, params t
 			continue
 		}
 
-		result = append(result, *deal)
+		result = append(result, ts.dealResponseLocked(deal))
 	}
+	sortDeals(result, params.Order, params.OrderDirection)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	writeJSON(w, http.StatusOK, result)
 }
 
 // GetDeal implements the ServerInterface method for GET /ver1/deals/{deal_id}/show
@@ -150,23 +323,39 @@ func (ts *TestServer) GetDeal(w http.ResponseWriter, r *http.Request, dealID tcm
 
 	// Check for deal-specific error
 	if err := ts.dealErrors[dealID]; err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": err.Error(),
-		})
+		writeError(w, http.StatusInternalServerError, err.Error(), "", nil)
 		return
 	}
 
 	deal, ok := ts.deals[dealID]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "deal not found",
-		})
+		writeError(w, http.StatusNotFound, "deal not found", "", nil)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(deal)
+	writeJSON(w, http.StatusOK, ts.dealResponseLocked(deal))
+}
+
+// Request helpers
+
+// pathInt parses an integer path parameter
+func pathInt(r *http.Request, name string) (int, error) {
+	v, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		return 0, &tcmock.InvalidParamFormatError{ParamName: name, Err: err}
+	}
+	return v, nil
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(r *http.Request, name string) (*int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, &tcmock.InvalidParamFormatError{ParamName: name, Err: err}
+	}
+	return &v, nil
 }
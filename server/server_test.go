@@ -3,8 +3,10 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 	"testing"
 
+	"github.com/oapi-codegen/nullable"
 	"github.com/recomma/3commas-mock/tcmock"
 )
 
@@ -224,6 +226,39 @@ func TestListDeals_FilterByBot(t *testing.T) {
 	}
 }
 
+func TestListDeals_OrderByProfit(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Bot 1", 123, true))
+	for id, profit := range map[int]string{101: "0.1", 102: "0.09999999999999999999", 103: "-1"} {
+		deal := NewDeal(id, 1, "USDT_BTC", "completed")
+		deal.FinalProfit = profit
+		ts.AddDeal(deal)
+	}
+	active := NewDeal(104, 1, "USDT_BTC", "bought")
+	active.ActualProfit = nullable.NewNullableWithValue("0.2")
+	ts.AddDeal(active)
+
+	resp, err := http.Get(ts.URL() + "/ver1/deals?order=profit&order_direction=ASC")
+	if err != nil {
+		t.Fatalf("failed to GET /ver1/deals: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var deals []tcmock.Deal
+	if err := json.NewDecoder(resp.Body).Decode(&deals); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	var ids []int
+	for _, deal := range deals {
+		ids = append(ids, deal.Id)
+	}
+	if !slices.Equal(ids, []int{103, 102, 101, 104}) {
+		t.Fatalf("expected deals ordered by exact profit, got %v", ids)
+	}
+}
+
 func TestReset(t *testing.T) {
 	ts := NewTestServer(t)
 	defer ts.Close()
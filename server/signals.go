@@ -0,0 +1,189 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Signal actions accepted by POST /trade_signal/trading_view
+const (
+	SignalStartDeal          = "start_deal"
+	SignalAddFunds           = "add_funds"
+	SignalCloseAtMarketPrice = "close_at_market_price"
+)
+
+// SignalCredentials authenticate custom signals sent to a bot
+type SignalCredentials struct {
+	BotUUID    string
+	EmailToken string
+}
+
+// tradeSignal is the body of POST /trade_signal/trading_view
+// An empty action starts a deal, like a plain 3Commas signal message
+type tradeSignal struct {
+	BotUUID    string `json:"bot_uuid"`
+	EmailToken string `json:"email_token"`
+	Pair       string `json:"pair"`
+	Action     string `json:"action"`
+}
+
+// dealStartScheduled is the response to a start_deal signal delayed by deal_start_delay_seconds
+type dealStartScheduled struct {
+	BotID   int       `json:"bot_id"`
+	Pair    string    `json:"pair"`
+	StartAt time.Time `json:"start_at"`
+}
+
+// BotSignalCredentials returns the bot_uuid and email_token of a bot,
+// generating them on first use
+func (ts *TestServer) BotSignalCredentials(botID int) (SignalCredentials, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.bots[botID]; !ok {
+		return SignalCredentials{}, fmt.Errorf("bot %d not found", botID)
+	}
+	if creds, ok := ts.botSignals[botID]; ok {
+		return creds, nil
+	}
+
+	creds := SignalCredentials{BotUUID: randomUUID(), EmailToken: randomUUID()}
+	ts.botSignals[botID] = creds
+	return creds, nil
+}
+
+// SetBotSignalCredentials sets fixed signal credentials for a bot
+func (ts *TestServer) SetBotSignalCredentials(botID int, creds SignalCredentials) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.bots[botID]; !ok {
+		return fmt.Errorf("bot %d not found", botID)
+	}
+	ts.botSignals[botID] = creds
+	return nil
+}
+
+// randomUUID returns a random version 4 UUID
+func randomUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// handleTradeSignal serves POST /trade_signal/trading_view
+// The signal is authenticated by bot_uuid and email_token; the bot must be enabled
+// start_deal opens a deal through the deal-start engine, or schedules it with 202
+// for bots with DealStartDelaySeconds; add_funds fills the next safety order of the open deal and
+// close_at_market_price closes the open deals on the pair
+// Responds with the affected deal
+func (ts *TestServer) handleTradeSignal(w http.ResponseWriter, r *http.Request) {
+	var signal tradeSignal
+	if _, ok := decodeJSONBody(w, r, &signal); !ok {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var bot *tcmock.Bot
+	for botID, creds := range ts.botSignals {
+		if creds.BotUUID == signal.BotUUID && creds.EmailToken == signal.EmailToken {
+			bot = ts.bots[botID]
+		}
+	}
+	if signal.BotUUID == "" || bot == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid bot_uuid or email_token", nil)
+		return
+	}
+	if !bot.IsEnabled {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Bot is disabled", nil)
+		return
+	}
+
+	pair := signal.Pair
+	if pair == "" && len(bot.Pairs) == 1 {
+		pair = bot.Pairs[0]
+	}
+	if !slices.Contains(bot.Pairs, pair) {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", map[string][]string{
+			"pair": {"is not included in the bot pairs"},
+		})
+		return
+	}
+
+	price, ok := ts.marketPriceLocked(ts.accountMarketLocked(bot.AccountId), pair)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Market price is unknown for "+pair, nil)
+		return
+	}
+
+	switch signal.Action {
+	case "", SignalStartDeal:
+		deal, startAt, err := ts.requestDealStartLocked(bot, pair)
+		switch {
+		case err != nil && err.Field == "":
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, err.Message, nil)
+		case err != nil:
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", err.attributes())
+		case deal == nil:
+			writeJSON(w, http.StatusAccepted, dealStartScheduled{BotID: bot.Id, Pair: pair, StartAt: startAt})
+		default:
+			writeJSON(w, http.StatusOK, ts.dealResponseLocked(deal))
+		}
+
+	case SignalAddFunds:
+		deal := ts.latestActiveDealLocked(bot.Id, pair)
+		if deal == nil {
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, "No active deal on "+pair, nil)
+			return
+		}
+		if err := ts.addSafetyOrderLocked(deal, price); err != nil {
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", err.attributes())
+			return
+		}
+		writeJSON(w, http.StatusOK, ts.dealResponseLocked(deal))
+
+	case SignalCloseAtMarketPrice:
+		deal := ts.latestActiveDealLocked(bot.Id, pair)
+		if deal == nil {
+			writeError(w, http.StatusBadRequest, errorRecordInvalid, "No active deal on "+pair, nil)
+			return
+		}
+		for _, d := range ts.deals {
+			if d.BotId == bot.Id && d.Pair == pair && isActiveDeal(d) {
+				ts.closeDealLocked(d, price, "panic_sold", "Deal closed at market price by signal.")
+			}
+		}
+		writeJSON(w, http.StatusOK, ts.dealResponseLocked(deal))
+
+	default:
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", map[string][]string{
+			"action": {"is not included in the list"},
+		})
+	}
+}
+
+// latestActiveDealLocked returns the most recently created open deal of a bot on pair
+// The caller must hold ts.mu
+func (ts *TestServer) latestActiveDealLocked(botID int, pair string) *tcmock.Deal {
+	var latest *tcmock.Deal
+	for _, deal := range ts.deals {
+		if deal.BotId != botID || deal.Pair != pair || !isActiveDeal(deal) {
+			continue
+		}
+		if latest == nil || deal.CreatedAt.After(latest.CreatedAt) || (deal.CreatedAt.Equal(latest.CreatedAt) && deal.Id > latest.Id) {
+			latest = deal
+		}
+	}
+	return latest
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// addSignalBot adds bot 1 on account 1 with the signal credentials uuid-1/token-1
+// A non-nil account is added first, holding usdt USDT
+func addSignalBot(t *testing.T, ts *TestServer, account *Account, usdt, name string, opts ...BotOption) tcmock.Bot {
+	t.Helper()
+
+	if account != nil {
+		ts.AddAccount(*account)
+		if err := ts.SetBalance(account.Id, "USDT", usdt); err != nil {
+			t.Fatalf("failed to set balance: %v", err)
+		}
+	}
+	bot := NewBot(1, name, 1, true, opts...)
+	ts.AddBot(bot)
+	if err := ts.SetBotSignalCredentials(1, SignalCredentials{BotUUID: "uuid-1", EmailToken: "token-1"}); err != nil {
+		t.Fatalf("failed to set credentials: %v", err)
+	}
+	return bot
+}
+
+// signalBot adds a signal bot trading USDT_BTC and USDT_ETH, without an account
+func signalBot(t *testing.T, ts *TestServer) tcmock.Bot {
+	t.Helper()
+
+	return addSignalBot(t, ts, nil, "", "Signal Bot",
+		WithPairs("USDT_BTC", "USDT_ETH"),
+		WithBaseOrder("100", "quote_currency"),
+		WithSafetyOrders(SafetyOrders{Max: 1, Volume: "50"}),
+		WithDealStart(DealStart{MaxActiveDeals: 2, Cooldown: "300"}),
+		WithTakeProfit("2", tcmock.BotTakeProfitTypeTotal),
+	)
+}
+
+func sendSignal(t *testing.T, ts *TestServer, body string) (*http.Response, tcmock.Deal, tcmock.ErrorResponse) {
+	t.Helper()
+	resp, err := http.Post(ts.URL()+"/trade_signal/trading_view", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to POST signal: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var raw json.RawMessage
+	json.NewDecoder(resp.Body).Decode(&raw)
+	var deal tcmock.Deal
+	var errResp tcmock.ErrorResponse
+	if resp.StatusCode == http.StatusOK {
+		json.Unmarshal(raw, &deal)
+	} else {
+		json.Unmarshal(raw, &errResp)
+	}
+	return resp, deal, errResp
+}
+
+func TestTradeSignal_Lifecycle(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ts.SetClock(start)
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	signalBot(t, ts)
+
+	resp, deal, _ := sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected start_deal to succeed, got %d", resp.StatusCode)
+	}
+	if deal.BotId != 1 || deal.BoughtVolume != "100" || deal.BoughtAmount != "0.002" || deal.TakeProfitPrice != "51000" {
+		t.Fatalf("unexpected deal: volume=%s amount=%s tp=%s", deal.BoughtVolume, deal.BoughtAmount, deal.TakeProfitPrice)
+	}
+	if len(deal.BotEvents) != 2 || !strings.HasPrefix(*deal.BotEvents[1].Message, "Base order executed. Price: 50000 USDT") {
+		t.Fatalf("expected base order events, got %d", len(deal.BotEvents))
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "40000")
+	resp, deal, _ = sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC", "action": "add_funds"}`)
+	if resp.StatusCode != http.StatusOK || deal.CompletedSafetyOrdersCount != 1 || deal.BoughtVolume != "150" {
+		t.Fatalf("expected a safety order, got %d: count=%d volume=%s", resp.StatusCode, deal.CompletedSafetyOrdersCount, deal.BoughtVolume)
+	}
+	resp, _, errResp := sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC", "action": "add_funds"}`)
+	if resp.StatusCode != http.StatusBadRequest || (*errResp.ErrorAttributes)["action"][0] != "Max safety orders reached (1)" {
+		t.Fatalf("expected max safety orders error, got %d", resp.StatusCode)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "45000")
+	resp, deal, _ = sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC", "action": "close_at_market_price"}`)
+	if resp.StatusCode != http.StatusOK || deal.Status != "panic_sold" || !deal.Finished {
+		t.Fatalf("expected the deal to close, got %d status=%s", resp.StatusCode, deal.Status)
+	}
+	// 0.00325 BTC sold at 45000 = 146.25 for 150 spent
+	if deal.FinalProfit != "-3.75" {
+		t.Fatalf("expected final profit -3.75, got %s", deal.FinalProfit)
+	}
+
+	// Cooldown blocks a new deal on the pair for 5 minutes
+	resp, _, errResp = sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(*errResp.ErrorDescription, "Cooldown between deals is active") {
+		t.Fatalf("expected cooldown error, got %d", resp.StatusCode)
+	}
+	ts.AdvanceClock(5 * time.Minute)
+	resp, _, _ = sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a new deal after the cooldown, got %d", resp.StatusCode)
+	}
+}
+
+func TestTradeSignal_MaxActiveDeals(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetMarketPrice("USDT_ETH", "2500")
+	bot := signalBot(t, ts)
+	bot.AllowedDealsOnSamePair = ptr(2)
+	ts.AddBot(bot)
+
+	for i, pair := range []string{"USDT_BTC", "USDT_ETH"} {
+		resp, _, _ := sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "`+pair+`"}`)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("signal %d: expected status 200, got %d", i, resp.StatusCode)
+		}
+	}
+	resp, _, errResp := sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`)
+	if resp.StatusCode != http.StatusBadRequest || *errResp.ErrorDescription != "Max active deals limit reached (2)" {
+		t.Fatalf("expected max active deals error, got %d", resp.StatusCode)
+	}
+	if n := len(ts.GetBotDeals(1)); n != 2 {
+		t.Fatalf("expected 2 deals, got %d", n)
+	}
+}
+
+func TestTradeSignal_Errors(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	signalBot(t, ts)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"bot_uuid": "uuid-1", "email_token": "wrong", "pair": "USDT_BTC"}`, status: http.StatusUnauthorized},
+		{body: `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_DOGE"}`, status: http.StatusBadRequest},
+		{body: `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC", "action": "explode"}`, status: http.StatusBadRequest},
+		{body: `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC", "action": "add_funds"}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, _, _ := sendSignal(t, ts, tt.body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.body, tt.status, resp.StatusCode)
+		}
+	}
+
+	ts.UpdateBotEnabled(1, false)
+	resp, _, _ := sendSignal(t, ts, `{"bot_uuid": "uuid-1", "email_token": "token-1", "pair": "USDT_BTC"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected disabled bot to reject signals, got %d", resp.StatusCode)
+	}
+}
+
+func TestBotSignalCredentials(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	first, err := ts.BotSignalCredentials(1)
+	if err != nil {
+		t.Fatalf("failed to get credentials: %v", err)
+	}
+	second, _ := ts.BotSignalCredentials(1)
+	if first.BotUUID == "" || first != second {
+		t.Fatalf("expected stable generated credentials, got %+v and %+v", first, second)
+	}
+	if _, err := ts.BotSignalCredentials(2); err == nil {
+		t.Fatal("expected an error for an unknown bot")
+	}
+}
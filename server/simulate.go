@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Deal Simulation
+
+// SetDealSimulation enables or disables the deal simulator
+// With simulation enabled, open deals react to market price changes
+// (SetMarketPrice, SetPriceFunc, clock moves) the way a 3Commas DCA bot would:
+// safety orders fill when the price reaches their trigger, the take profit
+// price follows the average entry price, take profit steps execute when
+// the price reaches them and the take profit, trailing take profit and
+// (trailing) stop loss close the deal
+// Orders are rounded to the pair's trading limits; an order violating them, or
+// exceeding the account's available balance, marks the deal with an error
+// instead of filling
+// Simulation is off by default so deals only change when tests change them
+func (ts *TestServer) SetDealSimulation(enabled bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.simulateDeals = enabled
+	if enabled {
+		for _, deal := range ts.deals {
+			ts.tickDealLocked(deal)
+		}
+	}
+}
+
+// simulateDealLocked lets an open deal react to the market price
+// The caller must hold ts.mu
+func (ts *TestServer) simulateDealLocked(deal *tcmock.Deal, price *big.Rat) {
+	if ts.fillSafetyOrdersLocked(deal, price) {
+		ts.updateTakeProfitPriceLocked(deal)
+		ts.updateActualProfitLocked(deal, price)
+	}
+	ts.fillTakeProfitStepsLocked(deal, price)
+	ts.simulateExitLocked(deal, price)
+}
+
+// fillSafetyOrdersLocked fills every safety order whose trigger price was reached
+// Returns whether any order filled
+// The caller must hold ts.mu
+func (ts *TestServer) fillSafetyOrdersLocked(deal *tcmock.Deal, price *big.Rat) bool {
+	basePrice := decimal.Parse(deal.BaseOrderAveragePrice)
+	if basePrice.Sign() <= 0 {
+		return false
+	}
+
+	limits := ts.dealLimitsLocked(deal)
+	filled := false
+	for deal.CompletedSafetyOrdersCount < deal.MaxSafetyOrders {
+		n := deal.CompletedSafetyOrdersCount + 1
+		trigger := safetyOrderPrice(deal, basePrice, n)
+		if dealCmp(deal, price, trigger) > 0 {
+			break
+		}
+
+		volume := safetyOrderVolume(deal, n)
+		amount, orderPrice, err := limits.checkTypedVolume("Safety order", "safety_order_volume", deal.SafetyOrderVolumeType, volume, trigger)
+		if err == nil && amount == nil {
+			// A trigger at or below zero can't fill
+			break
+		}
+		if err == nil {
+			err = ts.checkFundsLocked(deal.AccountId, deal.FromCurrency, orderMargin(deal, new(big.Rat).Mul(amount, orderPrice)), "safety_order_volume")
+		}
+		if err != nil {
+			ts.dealErrorLocked(deal, err.Message)
+			break
+		}
+
+		now := ts.nowLocked()
+		entryFills(deal).add(amount, orderPrice)
+		deal.CompletedSafetyOrdersCount++
+		deal.UpdatedAt = now
+		addBotEventAt(deal, fmt.Sprintf("Averaging order (%d out of %d) executed. %s",
+			n, deal.MaxSafetyOrders, orderSize(deal, amount, orderPrice)), now)
+		ts.dealChangedLocked(DealBotEventAdded, deal, "")
+		filled = true
+	}
+	return filled
+}
+
+// dealErrorLocked flags a deal with an error message and reports it once as a bot event
+// The caller must hold ts.mu
+func (ts *TestServer) dealErrorLocked(deal *tcmock.Deal, message string) {
+	if current, err := deal.ErrorMessage.Get(); deal.DealHasError && err == nil && current == message {
+		return
+	}
+
+	now := ts.nowLocked()
+	deal.DealHasError = true
+	deal.ErrorMessage = nullable.NewNullableWithValue(message)
+	deal.UpdatedAt = now
+	addBotEventAt(deal, "Error: "+message, now)
+	ts.dealChangedLocked(DealBotEventAdded, deal, "")
+}
+
+// updateTakeProfitPriceLocked recomputes the take profit price from the average entry price
+// With take profit steps the price is the one of the next pending step
+// The caller must hold ts.mu
+func (ts *TestServer) updateTakeProfitPriceLocked(deal *tcmock.Deal) {
+	if ts.updateTakeProfitStepsLocked(deal) {
+		deal.TakeProfitPrice = *deal.TakeProfitSteps[nextTakeProfitStep(deal)].Price
+		return
+	}
+
+	tp, err := deal.TakeProfit.Get()
+	if err != nil || decimal.Parse(tp).Sign() <= 0 {
+		return
+	}
+
+	avg := entryFills(deal).averagePrice()
+	target := new(big.Rat).Mul(avg, dealPercentFactor(deal, decimal.Parse(tp)))
+	deal.TakeProfitPrice = decimal.Format(decimal.RoundToStep(target, ts.dealLimitsLocked(deal).priceStep))
+}
+
+// safetyOrderPrice returns the trigger price of the nth safety order (1-based)
+// The deviation from the base price is step% * (1 + c + c^2 + ... + c^(n-1))
+// with c the martingale step coefficient, below the base price for long deals
+// and above it for short ones
+func safetyOrderPrice(deal *tcmock.Deal, basePrice *big.Rat, n int) *big.Rat {
+	step := decimal.Parse(deal.SafetyOrderStepPercentage)
+	coef := decimal.Parse(deal.MartingaleStepCoefficient)
+
+	deviation := new(big.Rat)
+	term := new(big.Rat).Set(step)
+	for i := 0; i < n; i++ {
+		deviation.Add(deviation, term)
+		term.Mul(term, coef)
+	}
+	return new(big.Rat).Mul(basePrice, dealPercentFactor(deal, new(big.Rat).Neg(deviation)))
+}
+
+// safetyOrderVolume returns the quote volume of the nth safety order (1-based)
+// Each order is the previous one times the martingale volume coefficient
+func safetyOrderVolume(deal *tcmock.Deal, n int) *big.Rat {
+	volume := decimal.Parse(deal.SafetyOrderVolume)
+	coef := decimal.Parse(deal.MartingaleVolumeCoefficient)
+	for i := 1; i < n; i++ {
+		volume.Mul(volume, coef)
+	}
+	return volume
+}
+
+// percentFactor returns 1 + pct/100
+func percentFactor(pct *big.Rat) *big.Rat {
+	f := new(big.Rat).Quo(pct, big.NewRat(100, 1))
+	return f.Add(f, big.NewRat(1, 1))
+}
@@ -0,0 +1,1028 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// SmartTrade status types
+const (
+	SmartTradeWaitingPosition  = "waiting_position"
+	SmartTradeWaitingTargets   = "waiting_targets"
+	SmartTradeFinished         = "finished"
+	SmartTradeStopLossFinished = "stop_loss_finished"
+	SmartTradePanicSold        = "panic_sold"
+	SmartTradeCancelled        = "cancelled"
+)
+
+// smartTradeStatuses maps each status type to its basic type and title
+// Basic types are what the status filter of GET /v2/smart_trades matches
+var smartTradeStatuses = map[string][2]string{
+	SmartTradeWaitingPosition:  {"active", "Waiting for position"},
+	SmartTradeWaitingTargets:   {"active", "Waiting for targets"},
+	SmartTradeFinished:         {"successfully_finished", "Finished"},
+	SmartTradeStopLossFinished: {"finished", "Stop loss finished"},
+	SmartTradePanicSold:        {"finished", "Closed at market price"},
+	SmartTradeCancelled:        {"cancelled", "Cancelled"},
+}
+
+// SmartTrade is a SmartTrade v2 position as returned by GET /v2/smart_trades/{id}
+// The generated client has no SmartTrade model, so the mock defines the fields
+// 3Commas returns that clients commonly read
+type SmartTrade struct {
+	Id         int                  `json:"id"`
+	Version    int                  `json:"version"`
+	Account    SmartTradeAccount    `json:"account"`
+	Pair       string               `json:"pair"`
+	Instant    bool                 `json:"instant"`
+	Status     SmartTradeStatus     `json:"status"`
+	Leverage   SmartTradeLeverage   `json:"leverage"`
+	Position   SmartTradePosition   `json:"position"`
+	TakeProfit SmartTradeTakeProfit `json:"take_profit"`
+	StopLoss   SmartTradeStopLoss   `json:"stop_loss"`
+	Note       string               `json:"note"`
+	Data       SmartTradeData       `json:"data"`
+	Profit     SmartTradeProfit     `json:"profit"`
+}
+
+// SmartTradeAccount identifies the exchange account of a smart trade
+type SmartTradeAccount struct {
+	Id   int    `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// SmartTradeStatus is the state of a smart trade, its position or a take profit step
+type SmartTradeStatus struct {
+	Type      string `json:"type"`
+	BasicType string `json:"basic_type"`
+	Title     string `json:"title"`
+}
+
+// SmartTradeLeverage is the leverage setting of a smart trade
+type SmartTradeLeverage struct {
+	Enabled bool   `json:"enabled"`
+	Type    string `json:"type,omitempty"`
+	Value   string `json:"value,omitempty"`
+}
+
+// SmartTradeValue is a decimal value wrapper
+type SmartTradeValue struct {
+	Value string `json:"value"`
+}
+
+// SmartTradePrice is an order price, either absolute or as a percent of the position price
+type SmartTradePrice struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Percent string `json:"percent,omitempty"`
+}
+
+// SmartTradePosition is the entry order of a smart trade
+// Type is "buy" or "sell", OrderType "market" or "limit"
+type SmartTradePosition struct {
+	Type      string           `json:"type"`
+	OrderType string           `json:"order_type"`
+	Units     SmartTradeValue  `json:"units"`
+	Price     SmartTradePrice  `json:"price"`
+	Total     SmartTradeValue  `json:"total"`
+	Status    SmartTradeStatus `json:"status"`
+}
+
+// SmartTradeTakeProfit holds the take profit steps
+type SmartTradeTakeProfit struct {
+	Enabled bool             `json:"enabled"`
+	Steps   []SmartTradeStep `json:"steps"`
+}
+
+// SmartTradeStep is a take profit step closing Volume percent of the position
+type SmartTradeStep struct {
+	Id        int              `json:"id"`
+	OrderType string           `json:"order_type"`
+	Units     SmartTradeValue  `json:"units"`
+	Price     SmartTradePrice  `json:"price"`
+	Volume    string           `json:"volume"`
+	Position  int              `json:"position"`
+	Status    SmartTradeStatus `json:"status"`
+}
+
+// SmartTradeStopLoss closes the position at market once the conditional price is crossed
+type SmartTradeStopLoss struct {
+	Enabled     bool                  `json:"enabled"`
+	OrderType   string                `json:"order_type"`
+	Conditional SmartTradeConditional `json:"conditional"`
+}
+
+// SmartTradeConditional is the trigger of a stop loss
+type SmartTradeConditional struct {
+	Price SmartTradePrice `json:"price"`
+}
+
+// SmartTradeData is the computed state of a smart trade
+type SmartTradeData struct {
+	CurrentPrice       SmartTradeCurrentPrice `json:"current_price"`
+	AverageEnterPrice  string                 `json:"average_enter_price"`
+	AverageClosePrice  string                 `json:"average_close_price"`
+	CancelAvailable    bool                   `json:"cancel_available"`
+	PanicSellAvailable bool                   `json:"panic_sell_available"`
+	Finished           bool                   `json:"finished"`
+	Type               string                 `json:"type"`
+	CreatedAt          time.Time              `json:"created_at"`
+	UpdatedAt          time.Time              `json:"updated_at"`
+	ClosedAt           *time.Time             `json:"closed_at"`
+}
+
+// SmartTradeCurrentPrice is the market price of the pair
+type SmartTradeCurrentPrice struct {
+	Bid  string `json:"bid"`
+	Ask  string `json:"ask"`
+	Last string `json:"last"`
+}
+
+// SmartTradeProfit is the realized plus unrealized profit in the quote currency
+type SmartTradeProfit struct {
+	Volume  string `json:"volume"`
+	Usd     string `json:"usd"`
+	Percent string `json:"percent"`
+}
+
+// SmartTradeOrder is an exchange order of a smart trade as returned by
+// GET /v2/smart_trades/{id}/trades
+// Type is "position", "take_profit", "stop_loss" or "close"
+type SmartTradeOrder struct {
+	Id             int       `json:"id"`
+	AccountId      int       `json:"account_id"`
+	Pair           string    `json:"pair"`
+	Type           string    `json:"type"`
+	Side           string    `json:"side"`
+	OrderType      string    `json:"order_type"`
+	Status         string    `json:"status"`
+	InitialAmount  string    `json:"initial_amount"`
+	InitialPrice   string    `json:"initial_price"`
+	InitialTotal   string    `json:"initial_total"`
+	RealisedAmount string    `json:"realised_amount"`
+	RealisedPrice  string    `json:"realised_price"`
+	RealisedTotal  string    `json:"realised_total"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// smartTradeRequest is the body of POST /v2/smart_trades
+// Numbers may be sent as JSON numbers or strings
+type smartTradeRequest struct {
+	AccountId int    `json:"account_id"`
+	Pair      string `json:"pair"`
+	Instant   bool   `json:"instant"`
+	Note      string `json:"note"`
+	Position  struct {
+		Type      string                 `json:"type"`
+		OrderType string                 `json:"order_type"`
+		Units     smartTradeValueRequest `json:"units"`
+		Price     smartTradePriceRequest `json:"price"`
+	} `json:"position"`
+	TakeProfit struct {
+		Enabled bool                    `json:"enabled"`
+		Steps   []smartTradeStepRequest `json:"steps"`
+	} `json:"take_profit"`
+	StopLoss struct {
+		Enabled     bool   `json:"enabled"`
+		OrderType   string `json:"order_type"`
+		Conditional struct {
+			Price smartTradePriceRequest `json:"price"`
+		} `json:"conditional"`
+	} `json:"stop_loss"`
+}
+
+type smartTradeStepRequest struct {
+	OrderType string                 `json:"order_type"`
+	Price     smartTradePriceRequest `json:"price"`
+	Volume    json.Number            `json:"volume"`
+}
+
+type smartTradeValueRequest struct {
+	Value json.Number `json:"value"`
+}
+
+type smartTradePriceRequest struct {
+	Value   json.Number `json:"value"`
+	Type    string      `json:"type"`
+	Percent json.Number `json:"percent"`
+}
+
+// smartTradeTarget is a resolved take profit step
+type smartTradeTarget struct {
+	price *big.Rat
+	units *big.Rat
+	order int // index into smartTradeEntry.orders, -1 until the position fills
+}
+
+// smartTradeEntry is a smart trade with its orders and exact position state
+type smartTradeEntry struct {
+	trade  SmartTrade
+	orders []SmartTradeOrder
+
+	sign        int64 // 1 for buy positions, -1 for sell positions
+	units       *big.Rat
+	entryPrice  *big.Rat
+	closedUnits *big.Rat
+	closedTotal *big.Rat
+	targets     []smartTradeTarget
+	stopLoss    *big.Rat
+}
+
+// active reports whether the smart trade is still open
+func (e *smartTradeEntry) active() bool {
+	return smartTradeStatuses[e.trade.Status.Type][0] == "active"
+}
+
+// filled reports whether the position order has filled
+func (e *smartTradeEntry) filled() bool {
+	return e.trade.Status.Type != SmartTradeWaitingPosition && e.trade.Position.Status.Type == SmartTradeFinished
+}
+
+// remaining returns the filled units not closed yet
+func (e *smartTradeEntry) remaining() *big.Rat {
+	return new(big.Rat).Sub(e.units, e.closedUnits)
+}
+
+// exitSide returns the order side that closes the position
+func (e *smartTradeEntry) exitSide() string {
+	if e.sign > 0 {
+		return "sell"
+	}
+	return "buy"
+}
+
+// crossed reports whether price moved through target in direction dir
+// dir is 1 for prices in the position's favour and -1 against it
+func (e *smartTradeEntry) crossed(price, target *big.Rat, dir int64) bool {
+	return e.sign*dir*int64(price.Cmp(target)) >= 0
+}
+
+// smartTradeStatus returns the status record of a status type
+func smartTradeStatus(statusType string) SmartTradeStatus {
+	s := smartTradeStatuses[statusType]
+	return SmartTradeStatus{Type: statusType, BasicType: s[0], Title: s[1]}
+}
+
+// GetSmartTrade returns a smart trade by ID
+func (ts *TestServer) GetSmartTrade(id int) (SmartTrade, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry, ok := ts.smartTrades[id]
+	if !ok {
+		return SmartTrade{}, false
+	}
+	return copySmartTrade(entry.trade), true
+}
+
+// GetAllSmartTrades returns all smart trades, newest first
+func (ts *TestServer) GetAllSmartTrades() []SmartTrade {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	return ts.smartTradeListLocked(func(*smartTradeEntry) bool { return true })
+}
+
+// GetSmartTradeOrders returns the exchange orders of a smart trade
+func (ts *TestServer) GetSmartTradeOrders(id int) []SmartTradeOrder {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry, ok := ts.smartTrades[id]
+	if !ok {
+		return nil
+	}
+	return append([]SmartTradeOrder(nil), entry.orders...)
+}
+
+// copySmartTrade returns a copy of a smart trade sharing no steps or times with it
+func copySmartTrade(trade SmartTrade) SmartTrade {
+	trade.TakeProfit.Steps = slices.Clone(trade.TakeProfit.Steps)
+	if trade.Data.ClosedAt != nil {
+		closedAt := *trade.Data.ClosedAt
+		trade.Data.ClosedAt = &closedAt
+	}
+	return trade
+}
+
+// smartTradeListLocked returns the smart trades matching keep, newest first
+// The caller must hold ts.mu
+func (ts *TestServer) smartTradeListLocked(keep func(*smartTradeEntry) bool) []SmartTrade {
+	result := []SmartTrade{}
+	for _, entry := range ts.smartTrades {
+		if keep(entry) {
+			result = append(result, copySmartTrade(entry.trade))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id > result[j].Id })
+	return result
+}
+
+// nextSmartTradeIDLocked returns the ID after the highest existing smart trade ID
+// The caller must hold ts.mu
+func (ts *TestServer) nextSmartTradeIDLocked() int {
+	id := 1
+	for existing := range ts.smartTrades {
+		if existing >= id {
+			id = existing + 1
+		}
+	}
+	return id
+}
+
+// addSmartTradeOrderLocked appends an order to a smart trade and returns its index
+// Order IDs are unique across all smart trades
+// The caller must hold ts.mu
+func (ts *TestServer) addSmartTradeOrderLocked(entry *smartTradeEntry, orderType, side, kind string, amount, price *big.Rat) int {
+	id := 1
+	for _, other := range ts.smartTrades {
+		for _, order := range other.orders {
+			if order.Id >= id {
+				id = order.Id + 1
+			}
+		}
+	}
+	for _, order := range entry.orders {
+		if order.Id >= id {
+			id = order.Id + 1
+		}
+	}
+
+	now := ts.nowLocked()
+	entry.orders = append(entry.orders, SmartTradeOrder{
+		Id:             id,
+		AccountId:      entry.trade.Account.Id,
+		Pair:           entry.trade.Pair,
+		Type:           kind,
+		Side:           side,
+		OrderType:      orderType,
+		Status:         "active",
+		InitialAmount:  decimal.Format(amount),
+		InitialPrice:   decimal.Format(price),
+		InitialTotal:   decimal.Format(new(big.Rat).Mul(amount, price)),
+		RealisedAmount: "0",
+		RealisedPrice:  "0",
+		RealisedTotal:  "0",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	return len(entry.orders) - 1
+}
+
+// fillSmartTradeOrderLocked marks an order as executed at price
+// The caller must hold ts.mu
+func (ts *TestServer) fillSmartTradeOrderLocked(entry *smartTradeEntry, index int, price *big.Rat) {
+	order := &entry.orders[index]
+	amount := decimal.Parse(order.InitialAmount)
+	order.Status = "finished"
+	order.RealisedAmount = order.InitialAmount
+	order.RealisedPrice = decimal.Format(price)
+	order.RealisedTotal = decimal.Format(new(big.Rat).Mul(amount, price))
+	order.UpdatedAt = ts.nowLocked()
+}
+
+// cancelSmartTradeOrdersLocked cancels the orders that have not executed
+// The caller must hold ts.mu
+func (ts *TestServer) cancelSmartTradeOrdersLocked(entry *smartTradeEntry) {
+	now := ts.nowLocked()
+	for i := range entry.orders {
+		if entry.orders[i].Status == "active" {
+			entry.orders[i].Status = "cancelled"
+			entry.orders[i].UpdatedAt = now
+		}
+	}
+	for i := range entry.trade.TakeProfit.Steps {
+		if entry.trade.TakeProfit.Steps[i].Status.Type != SmartTradeFinished {
+			entry.trade.TakeProfit.Steps[i].Status = smartTradeStatus(SmartTradeCancelled)
+		}
+	}
+}
+
+// newSmartTradeLocked validates a create request and builds the smart trade
+// Take profit and stop loss percents are resolved against the position price
+// checkFunds verifies the account can pay for the position
+// Returns the validation errors instead if the request is invalid
+// The caller must hold ts.mu
+func (ts *TestServer) newSmartTradeLocked(req smartTradeRequest, checkFunds bool) (*smartTradeEntry, map[string][]string) {
+	attrs := map[string][]string{}
+	add := func(field, msg string) {
+		attrs[field] = append(attrs[field], msg)
+	}
+
+	account, known := ts.accounts[req.AccountId]
+	if !known && len(ts.accounts) > 0 {
+		add("account_id", "is invalid")
+	}
+	if req.Pair == "" {
+		add("pair", "can't be blank")
+	}
+	side := req.Position.Type
+	if side != "buy" && side != "sell" {
+		add("position.type", "is not included in the list")
+	}
+	orderType := req.Position.OrderType
+	if orderType == "" {
+		orderType = "market"
+	}
+	if orderType != "market" && orderType != "limit" {
+		add("position.order_type", "is not included in the list")
+	}
+	units := decimal.Parse(req.Position.Units.Value.String())
+	if units.Sign() <= 0 {
+		add("position.units.value", "must be greater than 0")
+	}
+	if len(attrs) > 0 {
+		return nil, attrs
+	}
+
+	marketCode := ts.accountMarketLocked(req.AccountId)
+	price, ok := ts.marketPriceLocked(marketCode, req.Pair)
+	if orderType == "limit" {
+		price, ok = decimal.Parse(req.Position.Price.Value.String()), true
+		if price.Sign() <= 0 {
+			add("position.price.value", "can't be blank")
+			return nil, attrs
+		}
+	}
+	if !ok {
+		add("pair", "Market price is unknown for "+req.Pair)
+		return nil, attrs
+	}
+
+	limits := ts.orderLimitsLocked(marketCode, req.Pair)
+	amount, price, err := limits.checkOrder("Position", "position.units.value", units, price)
+	if err == nil && checkFunds {
+		if side == "buy" {
+			err = ts.checkFundsLocked(req.AccountId, limits.quote, new(big.Rat).Mul(amount, price), "position.units.value")
+		} else {
+			err = ts.checkFundsLocked(req.AccountId, limits.base, amount, "position.units.value")
+		}
+	}
+	if err != nil {
+		return nil, err.attributes()
+	}
+
+	entry := &smartTradeEntry{
+		sign:        1,
+		units:       amount,
+		entryPrice:  price,
+		closedUnits: new(big.Rat),
+		closedTotal: new(big.Rat),
+	}
+	if side == "sell" {
+		entry.sign = -1
+	}
+
+	// Take profit steps split the position by volume percent; the last step takes the rest
+	tp := SmartTradeTakeProfit{Enabled: req.TakeProfit.Enabled, Steps: []SmartTradeStep{}}
+	if req.TakeProfit.Enabled {
+		if len(req.TakeProfit.Steps) == 0 {
+			add("take_profit.steps", "can't be blank")
+		}
+		volumeSum := new(big.Rat)
+		allocated := new(big.Rat)
+		for i, step := range req.TakeProfit.Steps {
+			stepPrice := resolveSmartTradePrice(step.Price, price, entry.sign)
+			stepPrice = decimal.RoundToStep(stepPrice, limits.priceStep)
+			if stepPrice.Sign() <= 0 || entry.sign*int64(stepPrice.Cmp(price)) <= 0 {
+				word := "greater"
+				if entry.sign < 0 {
+					word = "less"
+				}
+				add("take_profit.steps", fmt.Sprintf("step %d price must be %s than the position price", i+1, word))
+			}
+			volume := decimal.Parse(step.Volume.String())
+			if volume.Sign() <= 0 {
+				add("take_profit.steps", fmt.Sprintf("step %d volume must be greater than 0", i+1))
+			}
+			volumeSum.Add(volumeSum, volume)
+
+			stepUnits := new(big.Rat).Mul(amount, volume)
+			stepUnits = decimal.FloorToStep(stepUnits.Quo(stepUnits, big.NewRat(100, 1)), limits.lotStep)
+			if i == len(req.TakeProfit.Steps)-1 {
+				stepUnits = new(big.Rat).Sub(amount, allocated)
+			}
+			allocated.Add(allocated, stepUnits)
+
+			stepOrderType := step.OrderType
+			if stepOrderType == "" {
+				stepOrderType = "limit"
+			}
+			entry.targets = append(entry.targets, smartTradeTarget{price: stepPrice, units: stepUnits, order: -1})
+			tp.Steps = append(tp.Steps, SmartTradeStep{
+				Id:        i + 1,
+				OrderType: stepOrderType,
+				Units:     SmartTradeValue{Value: decimal.Format(stepUnits)},
+				Price:     SmartTradePrice{Value: decimal.Format(stepPrice), Type: step.Price.Type, Percent: step.Price.Percent.String()},
+				Volume:    decimal.Format(volume),
+				Position:  i + 1,
+				Status:    smartTradeStatus(SmartTradeWaitingTargets),
+			})
+		}
+		if len(req.TakeProfit.Steps) > 0 && volumeSum.Cmp(big.NewRat(100, 1)) != 0 {
+			add("take_profit.steps", "volume sum must be 100")
+		}
+	}
+
+	sl := SmartTradeStopLoss{Enabled: req.StopLoss.Enabled, OrderType: req.StopLoss.OrderType}
+	if sl.OrderType == "" {
+		sl.OrderType = "market"
+	}
+	if req.StopLoss.Enabled {
+		trigger := resolveSmartTradePrice(req.StopLoss.Conditional.Price, price, -entry.sign)
+		trigger = decimal.RoundToStep(trigger, limits.priceStep)
+		if trigger.Sign() <= 0 || entry.sign*int64(trigger.Cmp(price)) >= 0 {
+			word := "less"
+			if entry.sign < 0 {
+				word = "greater"
+			}
+			add("stop_loss.conditional.price.value", fmt.Sprintf("must be %s than the position price", word))
+		}
+		entry.stopLoss = trigger
+		sl.Conditional.Price = SmartTradePrice{
+			Value:   decimal.Format(trigger),
+			Type:    req.StopLoss.Conditional.Price.Type,
+			Percent: req.StopLoss.Conditional.Price.Percent.String(),
+		}
+	}
+	if len(attrs) > 0 {
+		return nil, attrs
+	}
+
+	now := ts.nowLocked()
+	entry.trade = SmartTrade{
+		Version:  2,
+		Account:  SmartTradeAccount{Id: req.AccountId},
+		Pair:     req.Pair,
+		Instant:  req.Instant,
+		Status:   smartTradeStatus(SmartTradeWaitingPosition),
+		Leverage: SmartTradeLeverage{},
+		Position: SmartTradePosition{
+			Type:      side,
+			OrderType: orderType,
+			Units:     SmartTradeValue{Value: decimal.Format(amount)},
+			Price:     SmartTradePrice{Value: decimal.Format(price)},
+			Total:     SmartTradeValue{Value: decimal.Format(new(big.Rat).Mul(amount, price))},
+			Status:    smartTradeStatus(SmartTradeWaitingPosition),
+		},
+		TakeProfit: tp,
+		StopLoss:   sl,
+		Note:       req.Note,
+		Data: SmartTradeData{
+			Type:      "smart_trade",
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+	if known {
+		entry.trade.Account.Type = account.account.MarketCode
+		entry.trade.Account.Name = account.account.Name
+	}
+	return entry, nil
+}
+
+// resolveSmartTradePrice returns a request price, computing it from the percent
+// offset to base when no value is given; the sign of the percent is ignored
+// dir is 1 when the percent moves the price in the position's favour
+func resolveSmartTradePrice(p smartTradePriceRequest, base *big.Rat, dir int64) *big.Rat {
+	if value := decimal.Parse(p.Value.String()); value.Sign() > 0 {
+		return value
+	}
+	pct := decimal.Parse(p.Percent.String())
+	if pct.Sign() == 0 {
+		return new(big.Rat)
+	}
+	pct.Abs(pct)
+	if dir < 0 {
+		pct.Neg(pct)
+	}
+	return new(big.Rat).Mul(base, percentFactor(pct))
+}
+
+// openSmartTradeLocked stores a new smart trade and places its position order
+// With fill the position executes immediately, otherwise it waits for the price
+// The caller must hold ts.mu
+func (ts *TestServer) openSmartTradeLocked(entry *smartTradeEntry, fill bool) {
+	entry.trade.Id = ts.nextSmartTradeIDLocked()
+	ts.smartTrades[entry.trade.Id] = entry
+
+	side := entry.trade.Position.Type
+	index := ts.addSmartTradeOrderLocked(entry, entry.trade.Position.OrderType, side, "position", entry.units, entry.entryPrice)
+	if fill {
+		ts.fillSmartTradePositionLocked(entry, index)
+	}
+	ts.tickSmartTradeLocked(entry, true)
+}
+
+// fillSmartTradePositionLocked executes the position order and places the take profit orders
+// The caller must hold ts.mu
+func (ts *TestServer) fillSmartTradePositionLocked(entry *smartTradeEntry, index int) {
+	ts.fillSmartTradeOrderLocked(entry, index, entry.entryPrice)
+	entry.trade.Position.Status = smartTradeStatus(SmartTradeFinished)
+	entry.trade.Status = smartTradeStatus(SmartTradeWaitingTargets)
+	entry.trade.Data.AverageEnterPrice = decimal.Format(entry.entryPrice)
+
+	for i := range entry.targets {
+		target := &entry.targets[i]
+		target.order = ts.addSmartTradeOrderLocked(entry, entry.trade.TakeProfit.Steps[i].OrderType,
+			entry.exitSide(), "take_profit", target.units, target.price)
+	}
+}
+
+// closeSmartTradeUnitsLocked books the exit of units at price
+// The caller must hold ts.mu
+func (ts *TestServer) closeSmartTradeUnitsLocked(entry *smartTradeEntry, units, price *big.Rat) {
+	entry.closedUnits.Add(entry.closedUnits, units)
+	entry.closedTotal.Add(entry.closedTotal, new(big.Rat).Mul(units, price))
+	if entry.closedUnits.Sign() > 0 {
+		avg := new(big.Rat).Quo(entry.closedTotal, entry.closedUnits)
+		entry.trade.Data.AverageClosePrice = decimal.Format(decimal.RoundToStep(avg, averagePriceStep))
+	}
+}
+
+// finishSmartTradeLocked moves a smart trade to a final status
+// Closed positions book their profit on the account's quote balance
+// The caller must hold ts.mu
+func (ts *TestServer) finishSmartTradeLocked(entry *smartTradeEntry, status string) {
+	ts.cancelSmartTradeOrdersLocked(entry)
+	if !entry.filled() {
+		entry.trade.Position.Status = smartTradeStatus(SmartTradeCancelled)
+	}
+	now := ts.nowLocked()
+	entry.trade.Status = smartTradeStatus(status)
+	entry.trade.Data.Finished = true
+	entry.trade.Data.ClosedAt = &now
+
+	if status != SmartTradeCancelled {
+		if account, ok := ts.accounts[entry.trade.Account.Id]; ok {
+			quote, _ := splitPair(entry.trade.Pair)
+			if position, ok := account.balances[quote]; ok {
+				position.Add(position, entry.realizedProfit())
+			}
+		}
+	}
+}
+
+// realizedProfit returns the profit of the closed units in the quote currency
+func (e *smartTradeEntry) realizedProfit() *big.Rat {
+	cost := new(big.Rat).Mul(e.closedUnits, e.entryPrice)
+	profit := new(big.Rat).Sub(e.closedTotal, cost)
+	if e.sign < 0 {
+		profit.Neg(profit)
+	}
+	return profit
+}
+
+// tickSmartTradeLocked lets a smart trade react to the current market price
+// A pending limit position fills once the price reaches it; a filled position
+// hits its stop loss or its take profit steps in order
+// changed forces a stream update even if no order executed
+// The caller must hold ts.mu
+func (ts *TestServer) tickSmartTradeLocked(entry *smartTradeEntry, changed bool) {
+	price, known := ts.marketPriceLocked(ts.accountMarketLocked(entry.trade.Account.Id), entry.trade.Pair)
+	if known && entry.active() {
+		if entry.trade.Status.Type == SmartTradeWaitingPosition && entry.crossed(price, entry.entryPrice, -1) {
+			ts.fillSmartTradePositionLocked(entry, 0)
+			changed = true
+		}
+		if entry.trade.Status.Type == SmartTradeWaitingTargets {
+			changed = ts.smartTradeTargetsLocked(entry, price) || changed
+		}
+	}
+
+	ts.refreshSmartTradeLocked(entry, price, known)
+	if changed {
+		entry.trade.Data.UpdatedAt = ts.nowLocked()
+		ts.ws.broadcast(SmartTradesChannel, entry.trade)
+	}
+}
+
+// smartTradeTargetsLocked checks the stop loss and take profit steps of a filled position
+// Returns whether an order executed
+// The caller must hold ts.mu
+func (ts *TestServer) smartTradeTargetsLocked(entry *smartTradeEntry, price *big.Rat) bool {
+	if entry.stopLoss != nil && entry.crossed(price, entry.stopLoss, -1) {
+		remaining := entry.remaining()
+		index := ts.addSmartTradeOrderLocked(entry, entry.trade.StopLoss.OrderType, entry.exitSide(), "stop_loss", remaining, price)
+		ts.fillSmartTradeOrderLocked(entry, index, price)
+		ts.closeSmartTradeUnitsLocked(entry, remaining, price)
+		ts.finishSmartTradeLocked(entry, SmartTradeStopLossFinished)
+		return true
+	}
+
+	executed := false
+	for i := range entry.targets {
+		target := entry.targets[i]
+		step := &entry.trade.TakeProfit.Steps[i]
+		if step.Status.Type == SmartTradeFinished || !entry.crossed(price, target.price, 1) {
+			continue
+		}
+		ts.fillSmartTradeOrderLocked(entry, target.order, target.price)
+		ts.closeSmartTradeUnitsLocked(entry, target.units, target.price)
+		step.Status = smartTradeStatus(SmartTradeFinished)
+		executed = true
+	}
+	if executed && entry.remaining().Sign() <= 0 {
+		ts.finishSmartTradeLocked(entry, SmartTradeFinished)
+	}
+	return executed
+}
+
+// refreshSmartTradeLocked updates the current price, profit and available actions
+// The caller must hold ts.mu
+func (ts *TestServer) refreshSmartTradeLocked(entry *smartTradeEntry, price *big.Rat, known bool) {
+	if known {
+		if market, ok := ts.lookupMarketLocked(ts.accountMarketLocked(entry.trade.Account.Id), entry.trade.Pair); ok {
+			entry.trade.Data.CurrentPrice = SmartTradeCurrentPrice{
+				Bid:  market.rates.Bid,
+				Ask:  market.rates.Ask,
+				Last: market.rates.Last,
+			}
+		}
+	}
+
+	profit := entry.realizedProfit()
+	if known && entry.active() && entry.filled() {
+		unrealized := new(big.Rat).Mul(entry.remaining(), new(big.Rat).Sub(price, entry.entryPrice))
+		if entry.sign < 0 {
+			unrealized.Neg(unrealized)
+		}
+		profit.Add(profit, unrealized)
+	}
+
+	entry.trade.Profit = SmartTradeProfit{Volume: decimal.Format(profit), Usd: "0", Percent: "0"}
+	if cost := new(big.Rat).Mul(entry.units, entry.entryPrice); cost.Sign() > 0 {
+		pct := new(big.Rat).Quo(profit, cost)
+		entry.trade.Profit.Percent = decimal.FormatFixed(pct.Mul(pct, big.NewRat(100, 1)), 2)
+	}
+	quote, _ := splitPair(entry.trade.Pair)
+	if usd, ok := ts.usdRateLocked(ts.accountMarketLocked(entry.trade.Account.Id), quote); ok {
+		entry.trade.Profit.Usd = decimal.Format(new(big.Rat).Mul(profit, usd))
+	}
+
+	entry.trade.Data.CancelAvailable = entry.active()
+	entry.trade.Data.PanicSellAvailable = entry.active() && entry.filled()
+}
+
+// priceChangedSmartTradesLocked lets the open smart trades priced by a changed market entry react to its new price
+// The caller must hold ts.mu
+func (ts *TestServer) priceChangedSmartTradesLocked(changed *marketEntry, pair string) {
+	for _, id := range slices.Sorted(maps.Keys(ts.smartTrades)) {
+		entry := ts.smartTrades[id]
+		if entry.trade.Pair != pair || !entry.active() {
+			continue
+		}
+		if market, ok := ts.lookupMarketLocked(ts.accountMarketLocked(entry.trade.Account.Id), pair); ok && market == changed {
+			ts.tickSmartTradeLocked(entry, false)
+		}
+	}
+}
+
+// convertDealLocked turns an open deal's position into a smart trade
+// The deal's remaining amount becomes a filled position at the deal's average
+// price with its take profit price and stop loss; the deal is finished as "switched"
+// Returns the validation errors instead if the position violates the trading limits
+// The caller must hold ts.mu
+func (ts *TestServer) convertDealLocked(deal *tcmock.Deal) (*smartTradeEntry, map[string][]string) {
+	units := openAmount(deal)
+	entryPrice := entryFills(deal).averagePrice()
+
+	req := smartTradeRequest{AccountId: deal.AccountId, Pair: deal.Pair}
+	req.Position.Type = "buy"
+	if isShortDeal(deal) {
+		req.Position.Type = "sell"
+	}
+	req.Position.OrderType = "limit"
+	req.Position.Units.Value = json.Number(decimal.Format(units))
+	req.Position.Price.Value = json.Number(decimal.Format(entryPrice))
+	if tp := decimal.Parse(deal.TakeProfitPrice); tp.Sign() > 0 && dealCmp(deal, tp, entryPrice) > 0 {
+		req.TakeProfit.Enabled = true
+		req.TakeProfit.Steps = []smartTradeStepRequest{{
+			OrderType: "limit",
+			Price:     smartTradePriceRequest{Value: json.Number(decimal.Format(tp))},
+			Volume:    "100",
+		}}
+	}
+	if sl := decimal.Parse(deal.StopLossPercentage); sl.Sign() > 0 {
+		req.StopLoss.Enabled = true
+		req.StopLoss.Conditional.Price.Percent = json.Number(decimal.Format(sl))
+	}
+	req.Note = fmt.Sprintf("Converted from deal #%d", deal.Id)
+
+	// The deal's funds move to the smart trade, so they are not checked against the balance
+	entry, attrs := ts.newSmartTradeLocked(req, false)
+	if attrs != nil {
+		return nil, attrs
+	}
+	ts.openSmartTradeLocked(entry, true)
+
+	now := ts.nowLocked()
+	previous := deal.Status
+	setDealStatus(deal, "switched", now)
+	addBotEventAt(deal, fmt.Sprintf("Deal converted to SmartTrade #%d.", entry.trade.Id), now)
+	ts.dealChangedLocked(DealStatusChanged, deal, previous)
+	return entry, nil
+}
+
+// handleListSmartTrades serves GET /v2/smart_trades
+// Filters: account_id, pair and status (all, active, finished,
+// successfully_finished, cancelled); pagination with page and per_page
+func (ts *TestServer) handleListSmartTrades(w http.ResponseWriter, r *http.Request) {
+	accountID, err := queryInt(r, "account_id")
+	if err == nil {
+		_, err = queryInt(r, "page")
+	}
+	if err == nil {
+		_, err = queryInt(r, "per_page")
+	}
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+	query := r.URL.Query()
+	pair := query.Get("pair")
+	status := query.Get("status")
+	if status == "" {
+		status = "all"
+	}
+	page, perPage := 1, 10
+	if v, _ := strconv.Atoi(query.Get("page")); v > 0 {
+		page = v
+	}
+	if v, _ := strconv.Atoi(query.Get("per_page")); v > 0 {
+		perPage = min(v, 100)
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	result := ts.smartTradeListLocked(func(entry *smartTradeEntry) bool {
+		if accountID != nil && entry.trade.Account.Id != *accountID {
+			return false
+		}
+		if pair != "" && entry.trade.Pair != pair {
+			return false
+		}
+		basic := entry.trade.Status.BasicType
+		switch status {
+		case "all":
+			return true
+		case "finished":
+			return basic != "active"
+		default:
+			return basic == status
+		}
+	})
+
+	start := min((page-1)*perPage, len(result))
+	end := min(start+perPage, len(result))
+	writeJSON(w, http.StatusOK, result[start:end])
+}
+
+// handleCreateSmartTrade serves POST /v2/smart_trades
+func (ts *TestServer) handleCreateSmartTrade(w http.ResponseWriter, r *http.Request) {
+	var req smartTradeRequest
+	if _, ok := decodeJSONBody(w, r, &req); !ok {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry, attrs := ts.newSmartTradeLocked(req, true)
+	if attrs != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", attrs)
+		return
+	}
+	ts.openSmartTradeLocked(entry, entry.trade.Position.OrderType == "market")
+	writeJSON(w, http.StatusCreated, entry.trade)
+}
+
+// lookupSmartTradeLocked finds the smart trade of an {id} request
+// Writes the error response and returns nil if the ID is invalid or unknown
+// The caller must hold ts.mu
+func (ts *TestServer) lookupSmartTradeLocked(w http.ResponseWriter, r *http.Request) *smartTradeEntry {
+	id, err := pathInt(r, "id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return nil
+	}
+	entry, ok := ts.smartTrades[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, errorNotFound, "Smart trade not found", nil)
+		return nil
+	}
+	return entry
+}
+
+// handleGetSmartTrade serves GET /v2/smart_trades/{id}
+func (ts *TestServer) handleGetSmartTrade(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry := ts.lookupSmartTradeLocked(w, r)
+	if entry == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, entry.trade)
+}
+
+// handleCancelSmartTrade serves DELETE /v2/smart_trades/{id}
+// Open orders are cancelled; a filled position stays on the account unsold
+func (ts *TestServer) handleCancelSmartTrade(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.lookupSmartTradeLocked(w, r)
+	if entry == nil {
+		return
+	}
+	if !entry.active() {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Smart trade is already finished", nil)
+		return
+	}
+
+	ts.finishSmartTradeLocked(entry, SmartTradeCancelled)
+	ts.tickSmartTradeLocked(entry, true)
+	writeJSON(w, http.StatusOK, entry.trade)
+}
+
+// handleCloseSmartTradeByMarket serves POST /v2/smart_trades/{id}/close_by_market
+// The remaining position is closed at the market price
+func (ts *TestServer) handleCloseSmartTradeByMarket(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.lookupSmartTradeLocked(w, r)
+	if entry == nil {
+		return
+	}
+	if !entry.active() {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Smart trade is already finished", nil)
+		return
+	}
+	if !entry.filled() {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Position is not filled yet", nil)
+		return
+	}
+	price, ok := ts.marketPriceLocked(ts.accountMarketLocked(entry.trade.Account.Id), entry.trade.Pair)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Market price is unknown for "+entry.trade.Pair, nil)
+		return
+	}
+
+	remaining := entry.remaining()
+	index := ts.addSmartTradeOrderLocked(entry, "market", entry.exitSide(), "close", remaining, price)
+	ts.fillSmartTradeOrderLocked(entry, index, price)
+	ts.closeSmartTradeUnitsLocked(entry, remaining, price)
+	ts.finishSmartTradeLocked(entry, SmartTradePanicSold)
+	ts.tickSmartTradeLocked(entry, true)
+	writeJSON(w, http.StatusOK, entry.trade)
+}
+
+// handleSmartTradeOrders serves GET /v2/smart_trades/{id}/trades
+func (ts *TestServer) handleSmartTradeOrders(w http.ResponseWriter, r *http.Request) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	entry := ts.lookupSmartTradeLocked(w, r)
+	if entry == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, entry.orders)
+}
+
+// handleConvertDeal serves POST /ver1/deals/{deal_id}/convert_to_smart_trade
+// Only open deals with smart_trade_convertable set can be converted
+func (ts *TestServer) handleConvertDeal(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal := ts.lookupDealLocked(w, r)
+	if deal == nil {
+		return
+	}
+	if !deal.SmartTradeConvertable || !isActiveDeal(deal) || openAmount(deal).Sign() <= 0 {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Deal can't be converted to a smart trade", nil)
+		return
+	}
+
+	entry, attrs := ts.convertDealLocked(deal)
+	if attrs != nil {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", attrs)
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry.trade)
+}
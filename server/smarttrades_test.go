@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createSmartTrade posts a smart trade and decodes the response
+func createSmartTrade(t *testing.T, ts *TestServer, body string) (*http.Response, SmartTrade) {
+	t.Helper()
+
+	resp, err := http.Post(ts.URL()+"/v2/smart_trades", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to POST smart trade: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var trade SmartTrade
+	if resp.StatusCode == http.StatusCreated {
+		if err := json.NewDecoder(resp.Body).Decode(&trade); err != nil {
+			t.Fatalf("failed to decode smart trade: %v", err)
+		}
+	}
+	return resp, trade
+}
+
+func TestSmartTrade_TakeProfitSteps(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.AddAccount(NewAccount(1, "Main", "binance"))
+	ts.SetBalance(1, "USDT", "1000")
+
+	resp, trade := createSmartTrade(t, ts, `{
+		"account_id": 1,
+		"pair": "USDT_BTC",
+		"position": {"type": "buy", "order_type": "market", "units": {"value": "0.01"}},
+		"take_profit": {"enabled": true, "steps": [
+			{"order_type": "limit", "price": {"value": "52000", "type": "bid"}, "volume": 50},
+			{"order_type": "limit", "price": {"percent": 10, "type": "bid"}, "volume": 50}
+		]},
+		"stop_loss": {"enabled": true, "conditional": {"price": {"percent": -5, "type": "bid"}}}
+	}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", resp.StatusCode)
+	}
+	if trade.Status.Type != SmartTradeWaitingTargets || trade.Account.Name != "Main" {
+		t.Fatalf("expected a filled position, got status %q", trade.Status.Type)
+	}
+	if trade.TakeProfit.Steps[1].Price.Value != "55000" || trade.StopLoss.Conditional.Price.Value != "47500" {
+		t.Fatalf("expected resolved targets 55000 / 47500, got %s / %s",
+			trade.TakeProfit.Steps[1].Price.Value, trade.StopLoss.Conditional.Price.Value)
+	}
+
+	balance, _ := ts.GetBalance(1, "USDT")
+	if balance.OnOrders != "500" {
+		t.Fatalf("expected 500 USDT locked by the position, got %s", balance.OnOrders)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "52500")
+	trade, _ = ts.GetSmartTrade(trade.Id)
+	if trade.Status.Type != SmartTradeWaitingTargets || trade.TakeProfit.Steps[0].Status.Type != SmartTradeFinished {
+		t.Fatalf("expected the first step to fill, got %q / %q", trade.Status.Type, trade.TakeProfit.Steps[0].Status.Type)
+	}
+	// 0.005 closed at 52000 (+10) and 0.005 open at 52500 (+12.5)
+	if trade.Profit.Volume != "22.5" {
+		t.Fatalf("expected profit 22.5, got %s", trade.Profit.Volume)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "56000")
+	trade, _ = ts.GetSmartTrade(trade.Id)
+	if trade.Status.Type != SmartTradeFinished || !trade.Data.Finished || trade.Profit.Volume != "35" {
+		t.Fatalf("expected the trade to finish with profit 35, got %q %s", trade.Status.Type, trade.Profit.Volume)
+	}
+	if trade.Data.AverageClosePrice != "53500" {
+		t.Fatalf("expected average close price 53500, got %s", trade.Data.AverageClosePrice)
+	}
+
+	// Returned trades don't share their steps or times with the mock
+	trade.TakeProfit.Steps[0].Volume = "0"
+	*trade.Data.ClosedAt = trade.Data.ClosedAt.Add(time.Hour)
+	stored, _ := ts.GetSmartTrade(trade.Id)
+	for _, got := range []SmartTrade{stored, ts.GetAllSmartTrades()[0]} {
+		if got.TakeProfit.Steps[0].Volume != "50" || !got.Data.ClosedAt.Before(*trade.Data.ClosedAt) {
+			t.Fatalf("expected the stored trade to be unchanged, got volume %s", got.TakeProfit.Steps[0].Volume)
+		}
+	}
+
+	balance, _ = ts.GetBalance(1, "USDT")
+	if balance.OnOrders != "0" || balance.Position != "1035" {
+		t.Fatalf("expected released funds and booked profit, got %+v", balance)
+	}
+
+	orders := ts.GetSmartTradeOrders(trade.Id)
+	if len(orders) != 3 || orders[0].Type != "position" || orders[2].Status != "finished" || orders[2].RealisedPrice != "55000" {
+		t.Fatalf("unexpected orders: %+v", orders)
+	}
+}
+
+func TestSmartTrade_LimitPositionAndStopLoss(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_ETH", "2600")
+
+	resp, trade := createSmartTrade(t, ts, `{
+		"pair": "USDT_ETH",
+		"position": {"type": "buy", "order_type": "limit", "units": {"value": "1"}, "price": {"value": "2500"}},
+		"stop_loss": {"enabled": true, "conditional": {"price": {"value": "2400"}}}
+	}`)
+	if resp.StatusCode != http.StatusCreated || trade.Status.Type != SmartTradeWaitingPosition {
+		t.Fatalf("expected a pending position, got %d %q", resp.StatusCode, trade.Status.Type)
+	}
+
+	ts.SetMarketPrice("USDT_ETH", "2490")
+	trade, _ = ts.GetSmartTrade(trade.Id)
+	if trade.Status.Type != SmartTradeWaitingTargets || trade.Data.AverageEnterPrice != "2500" {
+		t.Fatalf("expected the limit position to fill at 2500, got %q %s", trade.Status.Type, trade.Data.AverageEnterPrice)
+	}
+
+	ts.SetMarketPrice("USDT_ETH", "2350")
+	trade, _ = ts.GetSmartTrade(trade.Id)
+	if trade.Status.Type != SmartTradeStopLossFinished || trade.Profit.Volume != "-150" {
+		t.Fatalf("expected the stop loss to close at 2350, got %q %s", trade.Status.Type, trade.Profit.Volume)
+	}
+}
+
+func TestSmartTrade_CancelAndCloseByMarket(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+
+	_, pending := createSmartTrade(t, ts, `{"pair": "USDT_BTC", "position": {"type": "buy", "order_type": "limit", "units": {"value": "0.01"}, "price": {"value": "45000"}}}`)
+	_, open := createSmartTrade(t, ts, `{"pair": "USDT_BTC", "position": {"type": "sell", "order_type": "market", "units": {"value": "0.01"}}}`)
+
+	resp, err := http.Post(ts.URL()+"/v2/smart_trades/"+strconv.Itoa(pending.Id)+"/close_by_market", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed to POST close_by_market: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an unfilled position to be rejected, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL()+"/v2/smart_trades/"+strconv.Itoa(pending.Id), nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to DELETE smart trade: %v", err)
+	}
+	resp.Body.Close()
+	if trade, _ := ts.GetSmartTrade(pending.Id); trade.Status.Type != SmartTradeCancelled {
+		t.Fatalf("expected the trade to be cancelled, got %q", trade.Status.Type)
+	}
+
+	// A sell position profits when the price drops
+	ts.SetMarketPrice("USDT_BTC", "48000")
+	resp, err = http.Post(ts.URL()+"/v2/smart_trades/"+strconv.Itoa(open.Id)+"/close_by_market", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed to POST close_by_market: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var closed SmartTrade
+	if err := json.NewDecoder(resp.Body).Decode(&closed); err != nil {
+		t.Fatalf("failed to decode smart trade: %v", err)
+	}
+	if closed.Status.Type != SmartTradePanicSold || closed.Profit.Volume != "20" {
+		t.Fatalf("expected panic_sold with profit 20, got %q %s", closed.Status.Type, closed.Profit.Volume)
+	}
+
+	resp, err = http.Get(ts.URL() + "/v2/smart_trades?status=cancelled")
+	if err != nil {
+		t.Fatalf("failed to GET smart trades: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var trades []SmartTrade
+	if err := json.NewDecoder(resp.Body).Decode(&trades); err != nil {
+		t.Fatalf("failed to decode smart trades: %v", err)
+	}
+	if len(trades) != 1 || trades[0].Id != pending.Id {
+		t.Fatalf("expected only the cancelled trade, got %d", len(trades))
+	}
+}
+
+func TestSmartTrade_Validation(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"unknown side", `{"pair": "USDT_BTC", "position": {"type": "hold", "units": {"value": "1"}}}`, "position.type"},
+		{"no units", `{"pair": "USDT_BTC", "position": {"type": "buy"}}`, "position.units.value"},
+		{"unknown price", `{"pair": "USDT_DOGE", "position": {"type": "buy", "units": {"value": "1"}}}`, "pair"},
+		{"volume sum", `{"pair": "USDT_BTC", "position": {"type": "buy", "units": {"value": "1"}},
+			"take_profit": {"enabled": true, "steps": [{"price": {"value": "60000"}, "volume": 60}]}}`, "take_profit.steps"},
+		{"stop loss above entry", `{"pair": "USDT_BTC", "position": {"type": "buy", "units": {"value": "1"}},
+			"stop_loss": {"enabled": true, "conditional": {"price": {"value": "51000"}}}}`, "stop_loss.conditional.price.value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL()+"/v2/smart_trades", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("failed to POST smart trade: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var errResp struct {
+				ErrorAttributes map[string][]string `json:"error_attributes"`
+			}
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			if resp.StatusCode != http.StatusBadRequest || len(errResp.ErrorAttributes[tt.field]) == 0 {
+				t.Fatalf("expected a %s error, got %d %+v", tt.field, resp.StatusCode, errResp.ErrorAttributes)
+			}
+		})
+	}
+}
+
+func TestConvertDealToSmartTrade(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	bot := NewBot(1, "Bot", 1, true)
+	bot.Pairs = []string{"USDT_BTC"}
+	bot.BaseOrderVolume = ptr("100")
+	bot.TakeProfit = ptr("2")
+	ts.AddBot(bot)
+	creds, _ := ts.BotSignalCredentials(1)
+
+	resp, err := http.Post(ts.URL()+"/trade_signal/trading_view", "application/json",
+		strings.NewReader(`{"bot_uuid": "`+creds.BotUUID+`", "email_token": "`+creds.EmailToken+`"}`))
+	if err != nil {
+		t.Fatalf("failed to POST signal: %v", err)
+	}
+	resp.Body.Close()
+	dealID := ts.GetBotDeals(1)[0].Id
+
+	resp, err = http.Post(ts.URL()+"/ver1/deals/"+strconv.Itoa(dealID)+"/convert_to_smart_trade", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed to POST convert_to_smart_trade: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var trade SmartTrade
+	if err := json.NewDecoder(resp.Body).Decode(&trade); err != nil {
+		t.Fatalf("failed to decode smart trade: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated || trade.Status.Type != SmartTradeWaitingTargets {
+		t.Fatalf("expected a filled smart trade, got %d %q", resp.StatusCode, trade.Status.Type)
+	}
+	if trade.Position.Units.Value != "0.002" || trade.TakeProfit.Steps[0].Price.Value != "51000" {
+		t.Fatalf("expected the deal's position and take profit, got %s / %s",
+			trade.Position.Units.Value, trade.TakeProfit.Steps[0].Price.Value)
+	}
+
+	deal, _ := ts.GetDealByID(dealID)
+	if deal.Status != "switched" || !deal.Finished || deal.SmartTradeConvertable {
+		t.Fatalf("expected the deal to be switched, got %q", deal.Status)
+	}
+
+	resp, err = http.Post(ts.URL()+"/ver1/deals/"+strconv.Itoa(dealID)+"/convert_to_smart_trade", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed to POST convert_to_smart_trade: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a second conversion to fail, got %d", resp.StatusCode)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/recomma/3commas-mock/tcmock"
 )
@@ -9,10 +10,20 @@ import (
 // Bot Management
 
 // AddBot adds a bot to the mock server's state
+// The account_name is taken from the bot's account if it was added with AddAccount
 func (ts *TestServer) AddBot(bot tcmock.Bot) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
+	ts.addBotLocked(bot)
+}
+
+// addBotLocked adds a bot, taking its account name from the account
+// The caller must hold ts.mu
+func (ts *TestServer) addBotLocked(bot tcmock.Bot) {
+	if name, ok := ts.accountNameLocked(bot.AccountId); ok {
+		bot.AccountName = name
+	}
 	ts.bots[bot.Id] = &bot
 }
 
@@ -64,11 +75,16 @@ func (ts *TestServer) RemoveBot(botID int) {
 	defer ts.mu.Unlock()
 
 	delete(ts.bots, botID)
+	delete(ts.botReinvested, botID)
+	ts.pendingStarts = slices.DeleteFunc(ts.pendingStarts, func(start pendingDealStart) bool {
+		return start.botID == botID
+	})
 
 	// Remove all deals for this bot
 	for dealID, deal := range ts.deals {
 		if deal.BotId == botID {
 			delete(ts.deals, dealID)
+			delete(ts.dealExtensions, dealID)
 		}
 	}
 }
@@ -88,16 +104,53 @@ func (ts *TestServer) GetAllBots() []tcmock.Bot {
 // Deal Management
 
 // AddDeal adds a deal to the mock server's state
-func (ts *TestServer) AddDeal(deal tcmock.Deal) error {
+// The account_name is taken from the deal's account if it was added with AddAccount
+// With SetEventDrivenFinancials, the deal's financials are recomputed from its bot events
+// Options apply to the deal like NewDeal's and also set its extension, such as the
+// deviation of WithTrailing
+func (ts *TestServer) AddDeal(deal tcmock.Deal, opts ...DealOption) error {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
+	for _, opt := range opts {
+		opt.applyDeal(&deal)
+	}
+	return ts.addDealLocked(deal, "0", opts...)
+}
+
+// addDealLocked adds a deal to its bot, holding reserved quote volume for its placed orders
+// opts set the deal extension; they have already been applied to the deal
+// The caller must hold ts.mu
+func (ts *TestServer) addDealLocked(deal tcmock.Deal, reserved string, opts ...DealOption) error {
 	// Check if bot exists
 	if _, ok := ts.bots[deal.BotId]; !ok {
 		return fmt.Errorf("bot %d not found", deal.BotId)
 	}
 
+	if name, ok := ts.accountNameLocked(deal.AccountId); ok {
+		deal.AccountName = name
+	}
+
 	ts.deals[deal.Id] = &deal
+	ext := newDealExtension(ts.bots[deal.BotId])
+	ext.ReservedBaseCoin = reserved
+	for _, opt := range opts {
+		if opt, ok := opt.(extensionOption); ok {
+			opt.applyExtension(ext)
+		}
+	}
+	ts.dealExtensions[deal.Id] = ext
+	if ts.eventFinancials {
+		ts.replayBotEventsLocked(&deal)
+	}
+	if isActiveDeal(&deal) {
+		ts.updateTakeProfitStepsLocked(&deal)
+	}
+	price, priced := ts.repriceDealLocked(&deal)
+	ts.dealChangedLocked(DealCreated, &deal, "")
+	if priced && ts.simulateDeals {
+		ts.simulateDealLocked(&deal, price)
+	}
 	return nil
 }
 
@@ -113,23 +166,9 @@ func (ts *TestServer) GetDealByID(dealID int) (tcmock.Deal, bool) {
 	return *deal, true
 }
 
-// UpdateDealStatus updates a deal's status
-func (ts *TestServer) UpdateDealStatus(dealID int, status string) error {
-	ts.mu.Lock()
-	defer ts.mu.Unlock()
-
-	deal, ok := ts.deals[dealID]
-	if !ok {
-		return fmt.Errorf("deal %d not found", dealID)
-	}
-
-	deal.Status = tcmock.DealStatus(status)
-
-	return nil
-}
-
 // AddBotEventToDeal adds a new bot event to an existing deal
 // message: Human-readable event description
+// With SetEventDrivenFinancials, order messages update the deal's financials
 func (ts *TestServer) AddBotEventToDeal(dealID int, message string) error {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
@@ -139,7 +178,9 @@ func (ts *TestServer) AddBotEventToDeal(dealID int, message string) error {
 		return fmt.Errorf("deal %d not found", dealID)
 	}
 
-	AddBotEvent(deal, message)
+	addBotEventAt(deal, message, ts.nowLocked())
+	ts.applyBotEventLocked(deal, message)
+	ts.dealChangedLocked(DealBotEventAdded, deal, "")
 	return nil
 }
 
@@ -149,6 +190,7 @@ func (ts *TestServer) RemoveDeal(dealID int) {
 	defer ts.mu.Unlock()
 
 	delete(ts.deals, dealID)
+	delete(ts.dealExtensions, dealID)
 }
 
 // GetAllDeals returns all deals in the mock
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// usdCurrencies are quote currencies counted as dollars in USD aggregates
+var usdCurrencies = map[string]bool{
+	"USD":   true,
+	"USDT":  true,
+	"USDC":  true,
+	"BUSD":  true,
+	"TUSD":  true,
+	"FDUSD": true,
+	"DAI":   true,
+}
+
+// isActiveDeal reports whether a deal is still open
+func isActiveDeal(deal *tcmock.Deal) bool {
+	return !deal.Finished && !dealStatuses[deal.Status].finished
+}
+
+// isProfitRealized reports whether a deal closed with a realized profit or loss
+func isProfitRealized(deal *tcmock.Deal) bool {
+	switch deal.Status {
+	case "completed", "panic_sold", "stop_loss_finished", "liquidated":
+		return true
+	}
+	return false
+}
+
+// dealUsdProfit returns the USD profit of a deal: final for closed deals, actual for open ones
+func dealUsdProfit(deal *tcmock.Deal) *big.Rat {
+	if isActiveDeal(deal) {
+		if v, err := deal.ActualUsdProfit.Get(); err == nil {
+			return decimal.Parse(v)
+		}
+		return new(big.Rat)
+	}
+	return decimal.Parse(deal.UsdFinalProfit)
+}
+
+// dealQuoteProfit returns the profit of a deal in its quote currency
+// Base-currency profits are converted at the deal's current (or closing) price
+func dealQuoteProfit(deal *tcmock.Deal) *big.Rat {
+	profit := decimal.Parse(deal.FinalProfit)
+	if isActiveDeal(deal) {
+		profit = new(big.Rat)
+		if v, err := deal.ActualProfit.Get(); err == nil {
+			profit = decimal.Parse(v)
+		}
+	}
+	if profitInBase(deal) {
+		profit.Mul(profit, decimal.Parse(deal.CurrentPrice))
+	}
+	return profit
+}
+
+// dealBtcProfit returns the BTC profit of a deal, zero unless it is quoted in BTC
+func dealBtcProfit(deal *tcmock.Deal) *big.Rat {
+	if deal.FromCurrency != "BTC" {
+		return new(big.Rat)
+	}
+	return dealQuoteProfit(deal)
+}
+
+// dealClosedAt returns when a deal closed, falling back to its last update
+func dealClosedAt(deal *tcmock.Deal) time.Time {
+	if closedAt, err := deal.ClosedAt.Get(); err == nil {
+		return closedAt
+	}
+	return deal.UpdatedAt
+}
+
+// startOfDay truncates t to midnight UTC
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// botsStatsLocked aggregates deals matching the account and bot filters
+// The caller must hold ts.mu
+func (ts *TestServer) botsStatsLocked(accountID, botID *int, now time.Time) tcmock.BotsStats {
+	overall := map[string]*big.Rat{}
+	today := map[string]*big.Rat{}
+	activeUsd := new(big.Rat)
+	lockedUsd := new(big.Rat)
+	overallUsd := new(big.Rat)
+	todayUsd := new(big.Rat)
+	midnight := startOfDay(now)
+
+	for _, deal := range ts.deals {
+		if accountID != nil && deal.AccountId != *accountID {
+			continue
+		}
+		if botID != nil && deal.BotId != *botID {
+			continue
+		}
+
+		if isActiveDeal(deal) {
+			activeUsd.Add(activeUsd, dealUsdProfit(deal))
+			if usdCurrencies[deal.FromCurrency] {
+				lockedUsd.Add(lockedUsd, dealMargin(deal))
+			}
+			continue
+		}
+		if !isProfitRealized(deal) {
+			continue
+		}
+
+		profit := dealQuoteProfit(deal)
+		if overall[deal.FromCurrency] == nil {
+			overall[deal.FromCurrency] = new(big.Rat)
+		}
+		overall[deal.FromCurrency].Add(overall[deal.FromCurrency], profit)
+		overallUsd.Add(overallUsd, dealUsdProfit(deal))
+
+		if !dealClosedAt(deal).Before(midnight) {
+			if today[deal.FromCurrency] == nil {
+				today[deal.FromCurrency] = new(big.Rat)
+			}
+			today[deal.FromCurrency].Add(today[deal.FromCurrency], profit)
+			todayUsd.Add(todayUsd, dealUsdProfit(deal))
+		}
+	}
+
+	stats := tcmock.BotsStats{
+		OverallStats: formatCurrencyMap(overall),
+		TodayStats:   formatCurrencyMap(today),
+	}
+	stats.ProfitsInUsd = &struct {
+		ActiveDealsUsdProfit     float32 `json:"active_deals_usd_profit"`
+		FundsLockedInActiveDeals float32 `json:"funds_locked_in_active_deals"`
+		OverallUsdProfit         float32 `json:"overall_usd_profit"`
+		TodayUsdProfit           float32 `json:"today_usd_profit"`
+	}{}
+	// The generated model uses float32 here; conversion happens only at the edge
+	stats.ProfitsInUsd.ActiveDealsUsdProfit, _ = activeUsd.Float32()
+	stats.ProfitsInUsd.FundsLockedInActiveDeals, _ = lockedUsd.Float32()
+	stats.ProfitsInUsd.OverallUsdProfit, _ = overallUsd.Float32()
+	stats.ProfitsInUsd.TodayUsdProfit, _ = todayUsd.Float32()
+
+	return stats
+}
+
+// dealsStatsLocked aggregates a bot's deals
+// The caller must hold ts.mu
+func (ts *TestServer) dealsStatsLocked(botID int) tcmock.DealsStats {
+	var active, completed, panicSold int
+	activeUsd, activeBtc := new(big.Rat), new(big.Rat)
+	completedUsd, completedBtc := new(big.Rat), new(big.Rat)
+	locked, lockedBtc := new(big.Rat), new(big.Rat)
+	dollars := true
+
+	for _, deal := range ts.deals {
+		if deal.BotId != botID {
+			continue
+		}
+		if !usdCurrencies[deal.FromCurrency] {
+			dollars = false
+		}
+
+		switch {
+		case isActiveDeal(deal):
+			active++
+			activeUsd.Add(activeUsd, dealUsdProfit(deal))
+			activeBtc.Add(activeBtc, dealBtcProfit(deal))
+			if deal.FromCurrency == "BTC" {
+				lockedBtc.Add(lockedBtc, dealMargin(deal))
+			} else {
+				locked.Add(locked, dealMargin(deal))
+			}
+		case isProfitRealized(deal):
+			if deal.Status == "panic_sold" {
+				panicSold++
+			} else {
+				completed++
+			}
+			completedUsd.Add(completedUsd, dealUsdProfit(deal))
+			completedBtc.Add(completedBtc, dealBtcProfit(deal))
+		}
+	}
+
+	return tcmock.DealsStats{
+		Active:                      &active,
+		Completed:                   &completed,
+		PanicSold:                   &panicSold,
+		ActiveDealsUsdProfit:        ptr(decimal.Format(activeUsd)),
+		ActiveDealsBtcProfit:        ptr(decimal.Format(activeBtc)),
+		CompletedDealsUsdProfit:     ptr(decimal.Format(completedUsd)),
+		CompletedDealsBtcProfit:     ptr(decimal.Format(completedBtc)),
+		FundsLockedInActiveDeals:    ptr(decimal.Format(locked)),
+		BtcFundsLockedInActiveDeals: ptr(decimal.Format(lockedBtc)),
+		FromCurrencyIsDollars:       &dollars,
+	}
+}
+
+// profitByDayLocked buckets a bot's realized profits into the last days days (UTC), oldest first
+// The caller must hold ts.mu
+func (ts *TestServer) profitByDayLocked(botID int, days int, now time.Time) tcmock.ProfitByDay {
+	first := startOfDay(now).AddDate(0, 0, -(days - 1))
+
+	usd := make([]*big.Rat, days)
+	btc := make([]*big.Rat, days)
+	for i := range usd {
+		usd[i], btc[i] = new(big.Rat), new(big.Rat)
+	}
+
+	for _, deal := range ts.deals {
+		if deal.BotId != botID || !isProfitRealized(deal) {
+			continue
+		}
+		closedAt := dealClosedAt(deal)
+		if closedAt.Before(first) {
+			continue
+		}
+		i := int(startOfDay(closedAt).Sub(first) / (24 * time.Hour))
+		if i >= days {
+			continue
+		}
+		usd[i].Add(usd[i], dealUsdProfit(deal))
+		btc[i].Add(btc[i], dealBtcProfit(deal))
+	}
+
+	result := make(tcmock.ProfitByDay, days)
+	for i := range result {
+		day := first.AddDate(0, 0, i)
+		result[i].SDate = openapi_types.Date{Time: day}
+		result[i].UnixTimestamp = int(day.Unix())
+		result[i].Profit.Usd = ptr(decimal.Format(usd[i]))
+		result[i].Profit.Btc = ptr(decimal.Format(btc[i]))
+	}
+	return result
+}
+
+// formatCurrencyMap formats per-currency totals
+func formatCurrencyMap(totals map[string]*big.Rat) *map[string]string {
+	result := make(map[string]string, len(totals))
+	for currency, total := range totals {
+		result[currency] = decimal.Format(total)
+	}
+	return &result
+}
+
+// handleBotsStats serves GET /ver1/bots/stats
+func (ts *TestServer) handleBotsStats(w http.ResponseWriter, r *http.Request) {
+	accountID, err := queryInt(r, "account_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+	botID, err := queryInt(r, "bot_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, ts.botsStatsLocked(accountID, botID, ts.nowLocked()))
+}
+
+// handleDealsStats serves GET /ver1/bots/{bot_id}/deals_stats
+func (ts *TestServer) handleDealsStats(w http.ResponseWriter, r *http.Request) {
+	botID, err := pathInt(r, "bot_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	if _, ok := ts.bots[botID]; !ok {
+		writeError(w, http.StatusNotFound, "bot not found", "", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ts.dealsStatsLocked(botID))
+}
+
+// maxProfitDays is the largest days value of profit_by_day
+const maxProfitDays = 365
+
+// handleProfitByDay serves GET /ver1/bots/{bot_id}/profit_by_day
+// The optional days parameter defaults to 30 and goes up to maxProfitDays
+func (ts *TestServer) handleProfitByDay(w http.ResponseWriter, r *http.Request) {
+	botID, err := pathInt(r, "bot_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+	days, err := queryInt(r, "days")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+	if days == nil {
+		days = ptr(30)
+	}
+	if *days < 1 {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", map[string][]string{
+			"days": {"must be greater than 0"},
+		})
+		return
+	}
+	if *days > maxProfitDays {
+		writeError(w, http.StatusBadRequest, errorRecordInvalid, "Invalid parameters", map[string][]string{
+			"days": {fmt.Sprintf("must be less than or equal to %d", maxProfitDays)},
+		})
+		return
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	if _, ok := ts.bots[botID]; !ok {
+		writeError(w, http.StatusNotFound, "bot not found", "", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ts.profitByDayLocked(botID, *days, ts.nowLocked()))
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// closedDeal builds a finished deal with a realized profit
+func closedDeal(id, botID int, pair, status, profit string, closedAt time.Time) tcmock.Deal {
+	deal := NewDeal(id, botID, pair, status)
+	deal.Finished = true
+	deal.FinalProfit = profit
+	deal.UsdFinalProfit = profit
+	deal.ClosedAt = nullable.NewNullableWithValue(closedAt)
+	return deal
+}
+
+// seedStatsDeals adds a bot with two active and three closed deals
+func seedStatsDeals(ts *TestServer) {
+	now := time.Now().UTC()
+
+	ts.AddBot(NewBot(1, "Stats Bot", 123, true))
+	ts.AddBot(NewBot(2, "Other Bot", 456, true))
+
+	active := NewDeal(101, 1, "USDT_BTC", "bought")
+	active.BoughtVolume = "100.10"
+	active.ActualUsdProfit = nullable.NewNullableWithValue("-1.5")
+	ts.AddDeal(active)
+
+	active = NewDeal(102, 1, "USDT_ETH", "bought")
+	active.BoughtVolume = "50.05"
+	active.ActualUsdProfit = nullable.NewNullableWithValue("0.25")
+	ts.AddDeal(active)
+
+	ts.AddDeal(closedDeal(103, 1, "USDT_BTC", "completed", "1.1", now))
+	ts.AddDeal(closedDeal(104, 1, "USDT_BTC", "panic_sold", "-0.3", now.AddDate(0, 0, -2)))
+	ts.AddDeal(closedDeal(105, 1, "USDT_BTC", "cancelled", "0", now))
+	ts.AddDeal(closedDeal(201, 2, "USDT_BTC", "completed", "9.9", now))
+}
+
+func TestDealsStats(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+	seedStatsDeals(ts)
+
+	resp, err := http.Get(ts.URL() + "/ver1/bots/1/deals_stats")
+	if err != nil {
+		t.Fatalf("failed to GET deals_stats: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var stats tcmock.DealsStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+
+	if *stats.Active != 2 || *stats.Completed != 1 || *stats.PanicSold != 1 {
+		t.Fatalf("expected 2 active, 1 completed, 1 panic sold, got %d/%d/%d", *stats.Active, *stats.Completed, *stats.PanicSold)
+	}
+	if *stats.CompletedDealsUsdProfit != "0.8" {
+		t.Errorf("expected completed USD profit 0.8, got %s", *stats.CompletedDealsUsdProfit)
+	}
+	if *stats.ActiveDealsUsdProfit != "-1.25" {
+		t.Errorf("expected active USD profit -1.25, got %s", *stats.ActiveDealsUsdProfit)
+	}
+	if *stats.FundsLockedInActiveDeals != "150.15" {
+		t.Errorf("expected funds locked 150.15, got %s", *stats.FundsLockedInActiveDeals)
+	}
+	if !*stats.FromCurrencyIsDollars {
+		t.Error("expected from_currency_is_dollars")
+	}
+}
+
+func TestDealsStats_BotNotFound(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL() + "/ver1/bots/999/deals_stats")
+	if err != nil {
+		t.Fatalf("failed to GET deals_stats: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestBotsStats(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+	seedStatsDeals(ts)
+
+	resp, err := http.Get(ts.URL() + "/ver1/bots/stats?bot_id=1")
+	if err != nil {
+		t.Fatalf("failed to GET bots/stats: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var stats tcmock.BotsStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+
+	if (*stats.OverallStats)["USDT"] != "0.8" {
+		t.Errorf("expected overall USDT 0.8, got %v", *stats.OverallStats)
+	}
+	if (*stats.TodayStats)["USDT"] != "1.1" {
+		t.Errorf("expected today USDT 1.1, got %v", *stats.TodayStats)
+	}
+	if stats.ProfitsInUsd.OverallUsdProfit != 0.8 {
+		t.Errorf("expected overall USD profit 0.8, got %v", stats.ProfitsInUsd.OverallUsdProfit)
+	}
+}
+
+func TestProfitByDay(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+	seedStatsDeals(ts)
+
+	resp, err := http.Get(ts.URL() + "/ver1/bots/1/profit_by_day?days=3")
+	if err != nil {
+		t.Fatalf("failed to GET profit_by_day: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var days tcmock.ProfitByDay
+	if err := json.NewDecoder(resp.Body).Decode(&days); err != nil {
+		t.Fatalf("failed to decode profit_by_day: %v", err)
+	}
+
+	if len(days) != 3 {
+		t.Fatalf("expected 3 buckets, got %d", len(days))
+	}
+	want := []string{"-0.3", "0", "1.1"}
+	for i, day := range days {
+		if *day.Profit.Usd != want[i] {
+			t.Errorf("bucket %d (%s): expected %s, got %s", i, day.SDate, want[i], *day.Profit.Usd)
+		}
+	}
+	if days[2].SDate.Time != startOfDay(time.Now()) {
+		t.Errorf("expected last bucket to be today, got %s", days[2].SDate)
+	}
+
+	for _, query := range []string{"days=0", "days=366", "days=1000000000"} {
+		resp, err := http.Get(ts.URL() + "/ver1/bots/1/profit_by_day?" + query)
+		if err != nil {
+			t.Fatalf("failed to GET profit_by_day: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
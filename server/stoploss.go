@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// stopLossAndDisableBot is the stop loss type that also disables the deal's bot
+const stopLossAndDisableBot = "stop_loss_and_disable_bot"
+
+// simulateStopLossLocked closes a deal as stop_loss_finished once the price reaches its stop loss
+// The stop loss sits stop_loss_percentage below the average entry price, with
+// tsl_enabled below the highest price since the deal opened, and after a take
+// profit step with sl_to_breakeven_enabled at least at the breakeven price;
+// short deals mirror this above the price
+// With stop_loss_timeout_enabled the price must stay beyond the stop loss
+// for stop_loss_timeout_in_seconds of mock time; a recovery restarts the timeout
+// Returns whether the deal closed
+// The caller must hold ts.mu
+func (ts *TestServer) simulateStopLossLocked(deal *tcmock.Deal, price *big.Rat) bool {
+	stop, ok := ts.updateStopLossPriceLocked(deal, price)
+	if !ok {
+		return false
+	}
+
+	ext := ts.dealExtensionLocked(deal)
+	if dealCmp(deal, price, stop) > 0 {
+		ext.stopLossBreachedAt = nil
+		return false
+	}
+	now := ts.nowLocked()
+	if deal.StopLossTimeoutEnabled && deal.StopLossTimeoutInSeconds > 0 {
+		if ext.stopLossBreachedAt == nil {
+			ext.stopLossBreachedAt = &now
+		}
+		if now.Sub(*ext.stopLossBreachedAt) < time.Duration(deal.StopLossTimeoutInSeconds)*time.Second {
+			return false
+		}
+	}
+	ext.stopLossBreachedAt = nil
+
+	message := "Stop Loss triggered."
+	if deal.TslEnabled {
+		message = "Trailing Stop Loss triggered."
+	}
+	ts.closeDealLocked(deal, price, "stop_loss_finished", message)
+
+	if bot, ok := ts.bots[deal.BotId]; ok && deal.StopLossType == stopLossAndDisableBot && bot.IsEnabled {
+		bot.IsEnabled = false
+		bot.UpdatedAt = now
+		addBotEventAt(deal, "Bot disabled by Stop Loss.", now)
+		ts.dealChangedLocked(DealBotEventAdded, deal, "")
+	}
+	return true
+}
+
+// tickStopLossTimeoutsLocked re-evaluates the deals waiting out a stop loss timeout
+// The caller must hold ts.mu
+func (ts *TestServer) tickStopLossTimeoutsLocked() {
+	if !ts.simulateDeals {
+		return
+	}
+	for dealID, ext := range ts.dealExtensions {
+		if deal, ok := ts.deals[dealID]; ok && ext.stopLossBreachedAt != nil {
+			ts.tickDealLocked(deal)
+		}
+	}
+}
+
+// updateStopLossPriceLocked moves the trailing stop loss maximum and recomputes
+// the stop loss price of a deal
+// Returns the stop loss price, or false when the deal has no stop loss
+// The caller must hold ts.mu
+func (ts *TestServer) updateStopLossPriceLocked(deal *tcmock.Deal, price *big.Rat) (*big.Rat, bool) {
+	pct := decimal.Parse(deal.StopLossPercentage)
+	base := entryFills(deal).averagePrice()
+	if pct.Sign() <= 0 || base.Sign() <= 0 {
+		return nil, false
+	}
+
+	if deal.TslEnabled {
+		ext := ts.dealExtensionLocked(deal)
+		if ext.TslMaxPrice != nil {
+			if high := decimal.Parse(*ext.TslMaxPrice); dealCmp(deal, high, base) > 0 {
+				base = high
+			}
+		}
+		if dealCmp(deal, price, base) > 0 {
+			base = price
+		}
+		ext.TslMaxPrice = ptr(decimal.Format(base))
+	}
+
+	priceStep := ts.dealLimitsLocked(deal).priceStep
+	stop := decimal.RoundToStep(new(big.Rat).Mul(base, dealPercentFactor(deal, new(big.Rat).Neg(pct))), priceStep)
+	if breakeven, ok := breakevenPrice(deal, priceStep); ok && dealCmp(deal, breakeven, stop) > 0 {
+		stop = breakeven
+	}
+	deal.StopLossPrice = decimal.Format(stop)
+	return stop, true
+}
+
+// moveStopLossToBreakevenLocked reports the stop loss move to breakeven after
+// the first take profit step of a deal executed
+// The caller must hold ts.mu
+func (ts *TestServer) moveStopLossToBreakevenLocked(deal *tcmock.Deal) {
+	if decimal.Parse(deal.StopLossPercentage).Sign() <= 0 || finishedTakeProfitSteps(deal) != 1 {
+		return
+	}
+	breakeven, ok := breakevenPrice(deal, ts.dealLimitsLocked(deal).priceStep)
+	if !ok {
+		return
+	}
+
+	deal.StopLossPrice = decimal.Format(breakeven)
+	addBotEventAt(deal, fmt.Sprintf("Stop Loss moved to breakeven. Price: %s %s",
+		deal.StopLossPrice, deal.FromCurrency), ts.nowLocked())
+}
+
+// breakevenPrice returns the stop loss price of a deal moved to breakeven
+// That is the average entry price raised by the upper_breakeven_limit percentage
+// of sl_to_breakeven_data; false until sl_to_breakeven_enabled deals executed a
+// take profit step
+func breakevenPrice(deal *tcmock.Deal, priceStep *big.Rat) (*big.Rat, bool) {
+	if !deal.SlToBreakevenEnabled || finishedTakeProfitSteps(deal) == 0 {
+		return nil, false
+	}
+
+	limit := new(big.Rat)
+	if data, err := deal.SlToBreakevenData.Get(); err == nil {
+		if v, ok := data["upper_breakeven_limit"]; ok && v != nil {
+			limit = decimal.Parse(fmt.Sprint(v))
+		}
+	}
+	avg := entryFills(deal).averagePrice()
+	return decimal.RoundToStep(new(big.Rat).Mul(avg, dealPercentFactor(deal, limit)), priceStep), true
+}
+
+// finishedTakeProfitSteps returns the number of executed take profit steps of a deal
+func finishedTakeProfitSteps(deal *tcmock.Deal) int {
+	n := 0
+	for _, step := range deal.TakeProfitSteps {
+		if step.Status != nil && *step.Status == takeProfitStepFinished {
+			n++
+		}
+	}
+	return n
+}
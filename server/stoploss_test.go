@@ -0,0 +1,86 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestStopLoss_TimeoutAndDisableBot(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts.SetClock(start)
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	trailingDeal(t, ts, WithStopLoss(StopLoss{Percentage: "5", Type: "stop_loss_and_disable_bot", TimeoutSeconds: 60}))
+
+	ts.SetMarketPrice("USDT_BTC", "47000")
+	ts.AdvanceClock(30 * time.Second)
+	deal, _ := ts.GetDealByID(100)
+	if deal.Status != "bought" || deal.StopLossPrice != "47500" {
+		t.Fatalf("expected the timeout to hold the stop loss, got %s sl=%s", deal.Status, deal.StopLossPrice)
+	}
+
+	// A recovery restarts the timeout
+	ts.SetMarketPrice("USDT_BTC", "48000")
+	ts.SetMarketPrice("USDT_BTC", "47000")
+	ts.AdvanceClock(59 * time.Second)
+	if deal, _ = ts.GetDealByID(100); deal.Status != "bought" {
+		t.Fatalf("expected the restarted timeout to hold the stop loss, got %s", deal.Status)
+	}
+
+	ts.AdvanceClock(time.Second)
+	deal, _ = ts.GetDealByID(100)
+	if deal.Status != "stop_loss_finished" || deal.FinalProfit != "-30" || deal.ClosedAt.IsNull() {
+		t.Fatalf("expected a stop loss exit with profit -30, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if closedAt, _ := deal.ClosedAt.Get(); !closedAt.Equal(start.Add(90 * time.Second)) {
+		t.Fatalf("expected the deal to close at the mock time, got %v", closedAt)
+	}
+	if msg := lastBotEvent(t, ts, 100); msg != "Bot disabled by Stop Loss." {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+	if bot, _ := ts.GetBot(1); bot.IsEnabled {
+		t.Fatal("expected the bot to be disabled")
+	}
+}
+
+func TestStopLoss_Breakeven(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	trailingDeal(t, ts,
+		WithStopLoss(StopLoss{Percentage: "5", ToBreakeven: true}),
+		WithDeal(func(deal *tcmock.Deal) {
+			deal.SlToBreakevenData = nullable.NewNullableWithValue(map[string]interface{}{"upper_breakeven_limit": 0.5})
+		}),
+	)
+	ts.SetDealTakeProfitSteps(100, []tcmock.TakeProfitStep{
+		{AmountPercentage: ptr(50), ProfitPercentage: ptr(2)},
+		{AmountPercentage: ptr(50), ProfitPercentage: ptr(4)},
+	})
+
+	ts.SetMarketPrice("USDT_BTC", "51000")
+	deal, _ := ts.GetDealByID(100)
+	if deal.Status != "bought" || deal.StopLossPrice != "50250" {
+		t.Fatalf("expected the stop loss to move to 50250, got %s sl=%s", deal.Status, deal.StopLossPrice)
+	}
+	if msg := lastBotEvent(t, ts, 100); msg != "Stop Loss moved to breakeven. Price: 50250 USDT" {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "50200")
+	deal, _ = ts.GetDealByID(100)
+	if deal.Status != "stop_loss_finished" || deal.FinalProfit != "6" {
+		t.Fatalf("expected a stop loss exit with profit 6, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if bot, _ := ts.GetBot(1); !bot.IsEnabled {
+		t.Fatal("expected the bot to stay enabled")
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Strategy option schema types
+const (
+	strategyOptionSelect = "select"
+	strategyOptionInput  = "input"
+)
+
+// startOnlyStrategies can open deals but cannot close them or trigger safety orders
+var startOnlyStrategies = []tcmock.StrategyConfigStrategy{tcmock.Manual, tcmock.Nonstop}
+
+// DefaultStrategyList is the strategy catalog served by GET /ver1/bots/strategy_list
+// Option schemas list each option's name, type ("select" or "input"), default
+// and either the allowed values or a numeric min/max
+var DefaultStrategyList = tcmock.StrategyList{
+	string(tcmock.Manual): {
+		Name:         "Manually/API (Bot won't open new trades automatically)",
+		StrategyType: "manual",
+	},
+	string(tcmock.Nonstop): {
+		Name:         "Open new trade asap",
+		StrategyType: "nonstop",
+	},
+	string(tcmock.Rsi): {
+		Name:         "RSI-7",
+		StrategyType: "indicator",
+		Options: nullable.NewNullableWithValue(map[string]interface{}{
+			"time": map[string]interface{}{
+				"name":    "Indicator time interval",
+				"type":    strategyOptionSelect,
+				"default": "5m",
+				"values":  []interface{}{"1m", "5m", "15m", "30m", "1h", "2h", "4h", "1d"},
+			},
+			"points": map[string]interface{}{
+				"name":    "Signal value",
+				"type":    strategyOptionInput,
+				"default": "30",
+				"min":     0.0,
+				"max":     100.0,
+			},
+			"trigger_condition": map[string]interface{}{
+				"name":    "Trigger condition",
+				"type":    strategyOptionSelect,
+				"default": "less",
+				"values":  []interface{}{"less", "greater"},
+			},
+		}),
+	},
+	"tv_custom_signal": {
+		Name:         "Custom TradingView signal",
+		StrategyType: "signal",
+	},
+	string(tcmock.TradingView): {
+		Name:         "TradingView custom signal",
+		StrategyType: "signal",
+		Options: nullable.NewNullableWithValue(map[string]interface{}{
+			"time": map[string]interface{}{
+				"name":    "Indicator time interval",
+				"type":    strategyOptionSelect,
+				"default": "5m",
+				"values":  []interface{}{"1m", "5m", "15m", "30m", "1h", "2h", "4h", "1d"},
+			},
+			"type": map[string]interface{}{
+				"name":    "Signal type",
+				"type":    strategyOptionSelect,
+				"default": "buy_or_strong",
+				"values":  []interface{}{"buy_or_strong", "strong", "buy_or_strong_buy"},
+			},
+		}),
+	},
+}
+
+// SetStrategyList replaces the strategy catalog
+// Bot create and update requests are validated against it
+func (ts *TestServer) SetStrategyList(list tcmock.StrategyList) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.strategyList = list
+}
+
+// validateStrategiesLocked checks a strategy list against the catalog
+// field is the bot attribute the list was sent in; errors are added to attrs
+// The caller must hold ts.mu
+func (ts *TestServer) validateStrategiesLocked(field string, list *[]tcmock.StrategyConfig, attrs map[string][]string) {
+	if list == nil {
+		return
+	}
+
+	for _, config := range *list {
+		if config.Strategy == nil || *config.Strategy == "" {
+			attrs[field] = append(attrs[field], "strategy can't be blank")
+			continue
+		}
+		strategy := *config.Strategy
+
+		definition, ok := ts.strategyList[string(strategy)]
+		if !ok {
+			attrs[field] = append(attrs[field], fmt.Sprintf("%s is not a valid strategy", strategy))
+			continue
+		}
+		if field != "strategy_list" && slices.Contains(startOnlyStrategies, strategy) {
+			attrs[field] = append(attrs[field], fmt.Sprintf("%s can't be used in %s", strategy, field))
+			continue
+		}
+
+		schema, _ := definition.Options.Get()
+		if config.Options == nil {
+			continue
+		}
+		options := *config.Options
+		keys := make([]string, 0, len(options))
+		for key := range options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if msg := validateStrategyOption(schema, key, options[key]); msg != "" {
+				attrs[field] = append(attrs[field], fmt.Sprintf("%s: %s", strategy, msg))
+			}
+		}
+	}
+}
+
+// validateStrategyOption checks one option value against a strategy's option schema
+// Returns the problem or "" if the value is valid
+func validateStrategyOption(schema map[string]interface{}, key string, value interface{}) string {
+	raw, ok := schema[key]
+	if !ok {
+		return fmt.Sprintf("unknown option %s", key)
+	}
+	option, ok := raw.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	switch option["type"] {
+	case strategyOptionSelect:
+		values, _ := option["values"].([]interface{})
+		for _, allowed := range values {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%s is not included in the list", key)
+	case strategyOptionInput:
+		var v *big.Rat
+		switch value := value.(type) {
+		case float64:
+			v = decimal.FromFloat64(value)
+		case string:
+			if !decimal.Valid(value) {
+				return fmt.Sprintf("%s is not a number", key)
+			}
+			v = decimal.Parse(value)
+		default:
+			return fmt.Sprintf("%s is not a number", key)
+		}
+		if lo, ok := option["min"].(float64); ok && v.Cmp(decimal.FromFloat64(lo)) < 0 {
+			return fmt.Sprintf("%s must be greater than or equal to %v", key, lo)
+		}
+		if hi, ok := option["max"].(float64); ok && v.Cmp(decimal.FromFloat64(hi)) > 0 {
+			return fmt.Sprintf("%s must be less than or equal to %v", key, hi)
+		}
+	}
+	return ""
+}
+
+// handleStrategyList serves GET /ver1/bots/strategy_list
+// With account_id, strategies whose accounts_whitelist excludes the account's
+// market are left out
+func (ts *TestServer) handleStrategyList(w http.ResponseWriter, r *http.Request) {
+	accountID, err := queryInt(r, "account_id")
+	if err != nil {
+		ts.handleParamError(w, r, err)
+		return
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	marketCode := ""
+	if accountID != nil {
+		if entry, ok := ts.accounts[*accountID]; ok {
+			marketCode = entry.account.MarketCode
+		}
+	}
+
+	result := tcmock.StrategyList{}
+	for key, definition := range ts.strategyList {
+		if whitelist, err := definition.AccountsWhitelist.Get(); err == nil && marketCode != "" && !slices.Contains(whitelist, marketCode) {
+			continue
+		}
+		result[key] = definition
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestStrategyList(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL() + "/ver1/bots/strategy_list")
+	if err != nil {
+		t.Fatalf("failed to GET strategy_list: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var list tcmock.StrategyList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode strategy list: %v", err)
+	}
+	for _, key := range []string{"manual", "nonstop", "rsi", "trading_view"} {
+		if _, ok := list[key]; !ok {
+			t.Errorf("expected strategy %s in the catalog", key)
+		}
+	}
+	options, err := list["rsi"].Options.Get()
+	if err != nil || options["points"] == nil {
+		t.Fatalf("expected rsi option schema, got %v", options)
+	}
+}
+
+func TestStrategyList_AccountWhitelist(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddAccount(NewAccount(1, "Main", "binance"))
+	ts.AddAccount(NewAccount(2, "Kraken", "kraken"))
+	ts.SetStrategyList(tcmock.StrategyList{
+		"nonstop": {Name: "Open new trade asap", StrategyType: "nonstop"},
+		"binance_only": {
+			Name:              "Binance signal",
+			StrategyType:      "signal",
+			AccountsWhitelist: nullable.NewNullableWithValue([]string{"binance"}),
+		},
+	})
+
+	for accountID, want := range map[string]int{"1": 2, "2": 1} {
+		resp, err := http.Get(ts.URL() + "/ver1/bots/strategy_list?account_id=" + accountID)
+		if err != nil {
+			t.Fatalf("failed to GET strategy_list: %v", err)
+		}
+		var list tcmock.StrategyList
+		json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if len(list) != want {
+			t.Errorf("account %s: expected %d strategies, got %d", accountID, want, len(list))
+		}
+	}
+}
+
+func TestCreateBot_StrategyValidation(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	body := `{"account_id": 1, "pairs": ["USDT_BTC"], "base_order_volume": "10",
+		"strategy_list": [{"strategy": "rsi", "options": {"time": "7m", "points": "120"}},
+			{"strategy": "rsi", "options": {"time": "5m", "points": "1/3"}}, {"strategy": "macd"}],
+		"close_strategy_list": [{"strategy": "nonstop"}],
+		"safety_strategy_list": [{"strategy": "rsi", "options": {"period": "14"}}]}`
+	resp, err := http.Post(ts.URL()+"/ver1/bots/create_bot", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to POST create_bot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", resp.StatusCode)
+	}
+	var errResp tcmock.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	attrs := *errResp.ErrorAttributes
+
+	want := map[string][]string{
+		"strategy_list": {
+			"rsi: points must be less than or equal to 100",
+			"rsi: time is not included in the list",
+			"rsi: points is not a number",
+			"macd is not a valid strategy",
+		},
+		"close_strategy_list":  {"nonstop can't be used in close_strategy_list"},
+		"safety_strategy_list": {"rsi: unknown option period"},
+	}
+	for field, messages := range want {
+		if strings.Join(attrs[field], "|") != strings.Join(messages, "|") {
+			t.Errorf("%s: expected %v, got %v", field, messages, attrs[field])
+		}
+	}
+}
+
+func TestUpdateBot(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	bot := NewBot(1, "Bot", 1, true)
+	bot.Pairs = tcmock.Pairs{"USDT_BTC"}
+	bot.BaseOrderVolume = ptr("10")
+	ts.AddBot(bot)
+
+	patch := func(body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL()+"/ver1/bots/1/update_bot", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to PATCH update_bot: %v", err)
+		}
+		return resp
+	}
+
+	resp := patch(`{"strategy_list": [{"strategy": "unknown"}]}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an unknown strategy, got %d", resp.StatusCode)
+	}
+	if got, _ := ts.GetBot(1); got.StrategyList != nil {
+		t.Fatal("invalid update should not change the bot")
+	}
+
+	resp = patch(`{"name": "Renamed", "strategy_list": [{"strategy": "rsi", "options": {"time": "15m", "points": 25}}]}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	got, _ := ts.GetBot(1)
+	if *got.Name != "Renamed" || len(*got.StrategyList) != 1 || !got.IsEnabled || *got.BaseOrderVolume != "10" {
+		t.Fatalf("unexpected bot after update: %+v", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodPatch, ts.URL()+"/ver1/bots/99/update_bot", strings.NewReader(`{}`))
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown bot, got %d", resp.StatusCode)
+	}
+}
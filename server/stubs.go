@@ -0,0 +1,257 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Expectation is a programmable canned response registered with On.
+// Matching requests are answered with the configured status and body instead
+// of the state-backed handlers, which makes it possible to serve payloads that
+// cannot be expressed as a tcmock.Bot or tcmock.Deal.
+type Expectation struct {
+	ts *TestServer
+
+	method string
+	path   string
+	query  url.Values
+
+	times int // 0 means unlimited
+	calls int
+
+	status  int
+	body    []byte
+	headers http.Header
+}
+
+// On registers a new expectation for the given method and path
+// Example: ts.On(http.MethodGet, "/ver1/deals/101/show").Times(2).Respond(200, `{"id":`)
+// Until Respond is called the expectation answers with 200 and an empty body
+func (ts *TestServer) On(method, path string) *Expectation {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	e := &Expectation{
+		ts:      ts,
+		method:  strings.ToUpper(method),
+		path:    path,
+		query:   url.Values{},
+		status:  http.StatusOK,
+		headers: http.Header{},
+	}
+	ts.expectations = append(ts.expectations, e)
+	return e
+}
+
+// WithQuery requires the request to carry the given query parameter value
+// Can be called multiple times; all parameters must match
+func (e *Expectation) WithQuery(key, value string) *Expectation {
+	e.ts.mu.Lock()
+	defer e.ts.mu.Unlock()
+
+	e.query.Add(key, value)
+	return e
+}
+
+// Times limits how many requests the expectation answers
+// Once exhausted, requests fall through to the state-backed handlers and are
+// reported as unexpected by AssertExpectations
+func (e *Expectation) Times(n int) *Expectation {
+	e.ts.mu.Lock()
+	defer e.ts.mu.Unlock()
+
+	e.times = n
+	return e
+}
+
+// Once is shorthand for Times(1)
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+// WithHeader adds a response header to the canned response
+func (e *Expectation) WithHeader(key, value string) *Expectation {
+	e.ts.mu.Lock()
+	defer e.ts.mu.Unlock()
+
+	e.headers.Add(key, value)
+	return e
+}
+
+// Respond sets the canned response
+// body can be a string or []byte (written verbatim, so malformed JSON is allowed)
+// or any other value, which is JSON encoded
+func (e *Expectation) Respond(status int, body interface{}) *Expectation {
+	var raw []byte
+	switch b := body.(type) {
+	case nil:
+		raw = nil
+	case string:
+		raw = []byte(b)
+	case []byte:
+		raw = b
+	case json.RawMessage:
+		raw = b
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			panic(fmt.Sprintf("server: cannot encode stub body for %s %s: %v", e.method, e.path, err))
+		}
+		raw = encoded
+	}
+
+	e.ts.mu.Lock()
+	defer e.ts.mu.Unlock()
+
+	e.status = status
+	e.body = raw
+	return e
+}
+
+// Calls returns how many requests the expectation has answered
+func (e *Expectation) Calls() int {
+	e.ts.mu.RLock()
+	defer e.ts.mu.RUnlock()
+	return e.calls
+}
+
+// String describes the expectation for assertion messages
+func (e *Expectation) String() string {
+	s := e.method + " " + e.path
+	if len(e.query) > 0 {
+		s += "?" + e.query.Encode()
+	}
+	return s
+}
+
+// matchesRoute reports whether the request targets the expectation's method and path
+// Paths are compared without their base URL, so a stub for "/ver1/bots" also
+// answers "/public/api/ver1/bots"
+func (e *Expectation) matchesRoute(r *http.Request) bool {
+	return e.method == r.Method && e.ts.trimBaseURL(e.path) == e.ts.trimBaseURL(r.URL.Path)
+}
+
+// matchesQuery reports whether the request carries all required query values
+func (e *Expectation) matchesQuery(r *http.Request) bool {
+	got := r.URL.Query()
+	for key, want := range e.query {
+		have := got[key]
+		for _, v := range want {
+			if !slices.Contains(have, v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// exhausted reports whether the expectation has used up its Times budget
+func (e *Expectation) exhausted() bool {
+	return e.times > 0 && e.calls >= e.times
+}
+
+// unmet reports whether the expectation still waits for calls
+func (e *Expectation) unmet() bool {
+	if e.times > 0 {
+		return e.calls < e.times
+	}
+	return e.calls == 0
+}
+
+// write sends the canned response
+func (e *Expectation) write(w http.ResponseWriter) {
+	for key, values := range e.headers {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(e.status)
+	if len(e.body) > 0 {
+		w.Write(e.body)
+	}
+}
+
+// stubMiddleware answers requests matching a registered expectation
+// Requests that hit a stubbed route without matching (wrong query or exhausted
+// Times) are recorded as unexpected and served by the next handler
+func (ts *TestServer) stubMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		var matched *Expectation
+		routeStubbed := false
+		for _, e := range ts.expectations {
+			if !e.matchesRoute(r) {
+				continue
+			}
+			routeStubbed = true
+			if e.exhausted() || !e.matchesQuery(r) {
+				continue
+			}
+			matched = e
+			break
+		}
+		if matched != nil {
+			matched.calls++
+		} else if routeStubbed {
+			ts.unexpectedCalls = append(ts.unexpectedCalls, r.Method+" "+r.URL.RequestURI())
+		}
+		ts.mu.Unlock()
+
+		if matched != nil {
+			matched.write(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AssertExpectations reports expectations that were not called as often as
+// required and requests that hit a stubbed route without matching any expectation
+func (ts *TestServer) AssertExpectations(t testing.TB) bool {
+	t.Helper()
+
+	failures := ts.expectationFailures()
+	for _, failure := range failures {
+		t.Error(failure)
+	}
+	return len(failures) == 0
+}
+
+// expectationFailures lists unmet expectations and unexpected calls
+func (ts *TestServer) expectationFailures() []string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	var failures []string
+	for _, e := range ts.expectations {
+		if !e.unmet() {
+			continue
+		}
+		if e.times > 0 {
+			failures = append(failures, fmt.Sprintf("expectation %s: expected %d call(s), got %d", e, e.times, e.calls))
+		} else {
+			failures = append(failures, fmt.Sprintf("expectation %s: expected at least 1 call, got none", e))
+		}
+	}
+	for _, call := range ts.unexpectedCalls {
+		failures = append(failures, "unexpected call: "+call)
+	}
+	return failures
+}
+
+// ClearExpectations removes all registered expectations and recorded unexpected calls
+func (ts *TestServer) ClearExpectations() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.expectations = nil
+	ts.unexpectedCalls = nil
+}
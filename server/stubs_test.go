@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+func TestStub_OverridesStateBackedHandler(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+
+	ts.On(http.MethodGet, "/ver1/deals/101/show").Times(2).Respond(http.StatusOK, `{"id": 101, "pair": `)
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(ts.URL() + "/ver1/deals/101/show")
+		if err != nil {
+			t.Fatalf("failed to GET deal: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != `{"id": 101, "pair": ` {
+			t.Fatalf("call %d: expected canned malformed body, got %q", i+1, body)
+		}
+	}
+
+	// Third call falls through to the state-backed handler
+	resp, err := http.Get(ts.URL() + "/ver1/deals/101/show")
+	if err != nil {
+		t.Fatalf("failed to GET deal: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var deal tcmock.Deal
+	if err := json.NewDecoder(resp.Body).Decode(&deal); err != nil {
+		t.Fatalf("expected state-backed deal after stub exhausted: %v", err)
+	}
+	if deal.Pair != "USDT_BTC" {
+		t.Fatalf("expected pair USDT_BTC, got %s", deal.Pair)
+	}
+
+	failures := ts.expectationFailures()
+	if len(failures) != 1 || failures[0] != "unexpected call: GET /ver1/deals/101/show" {
+		t.Fatalf("expected one unexpected call, got %v", failures)
+	}
+}
+
+func TestStub_WithQuery(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.On(http.MethodGet, "/ver1/bots").WithQuery("scope", "enabled").
+		Respond(http.StatusTeapot, map[string]string{"error": "brewing"})
+
+	resp, err := http.Get(ts.URL() + "/ver1/bots?scope=enabled")
+	if err != nil {
+		t.Fatalf("failed to GET bots: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Fatalf("expected status 418, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON content type, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Get(ts.URL() + "/ver1/bots?scope=disabled")
+	if err != nil {
+		t.Fatalf("failed to GET bots: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected state-backed status 200, got %d", resp.StatusCode)
+	}
+
+	// Query mismatch on a stubbed route is reported as unexpected
+	failures := ts.expectationFailures()
+	if len(failures) != 1 || failures[0] != "unexpected call: GET /ver1/bots?scope=disabled" {
+		t.Fatalf("expected one unexpected call, got %v", failures)
+	}
+
+	ts.ClearExpectations()
+	if failures := ts.expectationFailures(); len(failures) != 0 {
+		t.Fatalf("expected no failures after clear, got %v", failures)
+	}
+}
+
+func TestStub_UnmetExpectations(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.On(http.MethodGet, "/ver1/deals").Respond(http.StatusOK, "[]")
+	ts.On(http.MethodGet, "/ver1/bots").Times(3).Respond(http.StatusOK, "[]")
+
+	resp, err := http.Get(ts.URL() + "/ver1/bots")
+	if err != nil {
+		t.Fatalf("failed to GET bots: %v", err)
+	}
+	resp.Body.Close()
+
+	failures := ts.expectationFailures()
+	want := []string{
+		"expectation GET /ver1/deals: expected at least 1 call, got none",
+		"expectation GET /ver1/bots: expected 3 call(s), got 1",
+	}
+	if len(failures) != len(want) {
+		t.Fatalf("expected %d failures, got %v", len(want), failures)
+	}
+	for i := range want {
+		if failures[i] != want[i] {
+			t.Errorf("failure %d: expected %q, got %q", i, want[i], failures[i])
+		}
+	}
+}
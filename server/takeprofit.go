@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/oapi-codegen/nullable"
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// Take profit step statuses
+const (
+	takeProfitStepActive   = "active"
+	takeProfitStepFinished = "finished"
+)
+
+// maxTakeProfitSteps is the number of steps 3Commas allows per deal
+const maxTakeProfitSteps = 4
+
+// SetDealTakeProfitSteps configures multi-step take profit on an open deal
+// Each step sells amount_percentage of the position once the price reaches
+// profit_percentage above the average entry price; executed steps are kept and
+// the new steps replace the pending ones
+func (ts *TestServer) SetDealTakeProfitSteps(dealID int, steps []tcmock.TakeProfitStep) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		return fmt.Errorf("deal %d not found", dealID)
+	}
+	if err := ts.setTakeProfitStepsLocked(deal, steps); err != nil {
+		return err
+	}
+	ts.dealChangedLocked(DealUpdated, deal, "")
+	return nil
+}
+
+// ExecuteTakeProfitStep executes the next pending take profit step of a deal at its price
+// The deal completes when its last step executes
+func (ts *TestServer) ExecuteTakeProfitStep(dealID int) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		return fmt.Errorf("deal %d not found", dealID)
+	}
+	if !isActiveDeal(deal) {
+		return fmt.Errorf("deal %d is not active", dealID)
+	}
+	i := nextTakeProfitStep(deal)
+	if i < 0 {
+		return fmt.Errorf("deal %d has no pending take profit step", dealID)
+	}
+	ts.executeTakeProfitStepLocked(deal, i)
+	return nil
+}
+
+// setTakeProfitStepsLocked validates steps and replaces the pending steps of a deal
+// Steps whose id matches an executed step are skipped; the amount percentages of
+// the executed and new steps must add up to exactly 100
+// The caller must hold ts.mu
+func (ts *TestServer) setTakeProfitStepsLocked(deal *tcmock.Deal, steps []tcmock.TakeProfitStep) *orderError {
+	invalid := func(format string, args ...interface{}) *orderError {
+		return &orderError{Field: "take_profit_steps", Message: fmt.Sprintf(format, args...)}
+	}
+
+	kept := []dealTakeProfitStep{}
+	executed := map[int]bool{}
+	total := new(big.Rat)
+	lastID := 0
+	for _, step := range deal.TakeProfitSteps {
+		if step.Id != nil {
+			lastID = max(lastID, *step.Id)
+		}
+		if step.Status != nil && *step.Status == takeProfitStepFinished {
+			kept = append(kept, step)
+			if step.Id != nil {
+				executed[*step.Id] = true
+			}
+			total.Add(total, decimal.FromFloat32(stepPercentage(step.AmountPercentage)))
+		}
+	}
+
+	var added []dealTakeProfitStep
+	for _, step := range steps {
+		if step.Id != nil && executed[*step.Id] {
+			continue
+		}
+		amount, profit := 0, 0
+		if step.AmountPercentage != nil {
+			amount = *step.AmountPercentage
+		}
+		if step.ProfitPercentage != nil {
+			profit = *step.ProfitPercentage
+		}
+		if amount <= 0 {
+			return invalid("amount_percentage must be greater than 0")
+		}
+		if profit <= 0 {
+			return invalid("profit_percentage must be greater than 0")
+		}
+		total.Add(total, big.NewRat(int64(amount), 1))
+		added = append(added, dealTakeProfitStep{
+			AmountPercentage: ptr(float32(amount)),
+			ProfitPercentage: ptr(float32(profit)),
+			Status:           ptr(takeProfitStepActive),
+			Editable:         ptr(true),
+			PanicSellable:    ptr(true),
+		})
+	}
+	if len(kept)+len(added) > maxTakeProfitSteps {
+		return invalid("can't have more than %d steps", maxTakeProfitSteps)
+	}
+	if len(added) > 0 && total.Cmp(big.NewRat(100, 1)) != 0 {
+		return invalid("amount percentages must add up to 100")
+	}
+
+	// New steps are numbered after every step the deal has had, so no id is reused
+	for i := range added {
+		added[i].Id = ptr(lastID + i + 1)
+	}
+	deal.TakeProfitSteps = append(kept, added...)
+	if len(added) > 0 {
+		deal.TakeProfit = nullable.NewNullNullable[string]()
+	}
+	deal.UpdatedAt = ts.nowLocked()
+	ts.updateTakeProfitPriceLocked(deal)
+	return nil
+}
+
+// updateTakeProfitStepsLocked reprices and resizes the pending steps of a deal
+// Pending steps trigger at profit_percentage above the average entry price and
+// sell amount_percentage of the bought amount; the last step sells whatever is left.
+// Steps without an id are numbered by position, so fixture steps are normalized too
+// Returns whether the deal has pending steps
+// The caller must hold ts.mu
+func (ts *TestServer) updateTakeProfitStepsLocked(deal *tcmock.Deal) bool {
+	last := -1
+	for i, step := range deal.TakeProfitSteps {
+		if step.Status != nil && *step.Status == takeProfitStepActive {
+			last = i
+		}
+	}
+	if last < 0 {
+		return false
+	}
+
+	limits := ts.dealLimitsLocked(deal)
+	avg := entryFills(deal).averagePrice()
+	bought := entryFills(deal).filledAmount()
+	left := openAmount(deal)
+	for i := range deal.TakeProfitSteps {
+		step := &deal.TakeProfitSteps[i]
+		if step.Id == nil {
+			step.Id = ptr(i + 1)
+		}
+		if step.Status == nil || *step.Status != takeProfitStepActive {
+			continue
+		}
+
+		profit := decimal.FromFloat32(stepPercentage(step.ProfitPercentage))
+		price := decimal.RoundToStep(new(big.Rat).Mul(avg, dealPercentFactor(deal, profit)), limits.priceStep)
+		amount := new(big.Rat).Mul(bought, decimal.FromFloat32(stepPercentage(step.AmountPercentage)))
+		amount = decimal.FloorToStep(amount.Quo(amount, big.NewRat(100, 1)), limits.lotStep)
+		if i == last || amount.Cmp(left) > 0 {
+			amount = new(big.Rat).Set(left)
+		}
+		left.Sub(left, amount)
+
+		step.Price = ptr(decimal.Format(price))
+		step.InitialAmount = ptr(decimal.Format(amount))
+	}
+	return true
+}
+
+// stepPercentage returns an optional step percentage, zero when unset
+func stepPercentage(p *float32) float32 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// nextTakeProfitStep returns the index of the first pending step, or -1
+func nextTakeProfitStep(deal *tcmock.Deal) int {
+	for i, step := range deal.TakeProfitSteps {
+		if step.Status != nil && *step.Status == takeProfitStepActive {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextTakeProfitTradeIDLocked returns a trade ID not used by any executed step
+// The caller must hold ts.mu
+func (ts *TestServer) nextTakeProfitTradeIDLocked() int {
+	id := 1
+	for _, deal := range ts.deals {
+		for _, step := range deal.TakeProfitSteps {
+			if step.TradeId != nil && *step.TradeId >= id {
+				id = *step.TradeId + 1
+			}
+		}
+	}
+	return id
+}
+
+// executeTakeProfitStepLocked exits a pending step's amount at its price
+// The pending steps are repriced first, so steps added without a price or amount get one
+// The deal completes when no pending step is left
+// The caller must hold ts.mu
+func (ts *TestServer) executeTakeProfitStepLocked(deal *tcmock.Deal, i int) {
+	ts.updateTakeProfitStepsLocked(deal)
+	step := &deal.TakeProfitSteps[i]
+	amount := decimal.Parse(*step.InitialAmount)
+	price := decimal.Parse(*step.Price)
+	now := ts.nowLocked()
+
+	exitFills(deal).add(amount, price)
+
+	step.Status = ptr(takeProfitStepFinished)
+	step.Editable = ptr(false)
+	step.PanicSellable = ptr(false)
+	step.ExecutionTimestamp = nullable.NewNullableWithValue(now)
+	step.TradeId = ptr(ts.nextTakeProfitTradeIDLocked())
+	deal.UpdatedAt = now
+	addBotEventAt(deal, fmt.Sprintf("Take profit step %d (%v%%) executed. %s",
+		*step.Id, stepPercentage(step.AmountPercentage), orderSize(deal, amount, price)), now)
+
+	if nextTakeProfitStep(deal) < 0 {
+		ts.closeDealLocked(deal, price, "completed", "Take profit executed.")
+		return
+	}
+	ts.moveStopLossToBreakevenLocked(deal)
+	ts.updateTakeProfitPriceLocked(deal)
+	ts.updateActualProfitLocked(deal, price)
+	ts.dealChangedLocked(DealBotEventAdded, deal, "")
+}
+
+// fillTakeProfitStepsLocked executes, one at a time, the pending steps whose price was reached
+// Returns whether the deal completed
+// The caller must hold ts.mu
+func (ts *TestServer) fillTakeProfitStepsLocked(deal *tcmock.Deal, price *big.Rat) bool {
+	for isActiveDeal(deal) {
+		i := nextTakeProfitStep(deal)
+		if i >= 0 && deal.TakeProfitSteps[i].Price == nil {
+			ts.updateTakeProfitStepsLocked(deal)
+		}
+		if i < 0 || dealCmp(deal, price, decimal.Parse(*deal.TakeProfitSteps[i].Price)) < 0 {
+			return false
+		}
+		ts.executeTakeProfitStepLocked(deal, i)
+	}
+	return true
+}
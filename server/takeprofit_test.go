@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// boughtDeal adds bot 1 and its deal 100, bought 0.01 BTC at 50000
+// opts apply after the defaults, when the deal is added
+func boughtDeal(t *testing.T, ts *TestServer, opts ...DealOption) {
+	t.Helper()
+
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	deal := NewDeal(100, 1, "USDT_BTC", "bought",
+		WithBought("0.01", "500", "50000"),
+		WithDeal(func(deal *tcmock.Deal) { deal.BaseOrderAveragePrice = "50000" }),
+	)
+	if err := ts.AddDeal(deal, opts...); err != nil {
+		t.Fatalf("failed to add deal: %v", err)
+	}
+}
+
+func TestTakeProfitSteps_Execute(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	boughtDeal(t, ts)
+	err := ts.SetDealTakeProfitSteps(100, []tcmock.TakeProfitStep{
+		{AmountPercentage: ptr(30), ProfitPercentage: ptr(2)},
+		{AmountPercentage: ptr(70), ProfitPercentage: ptr(4)},
+	})
+	if err != nil {
+		t.Fatalf("failed to set steps: %v", err)
+	}
+
+	deal, _ := ts.GetDealByID(100)
+	if len(deal.TakeProfitSteps) != 2 || *deal.TakeProfitSteps[0].Price != "51000" || *deal.TakeProfitSteps[1].InitialAmount != "0.007" {
+		t.Fatalf("unexpected steps: %+v", deal.TakeProfitSteps)
+	}
+	if deal.TakeProfitPrice != "51000" || !deal.TakeProfit.IsNull() {
+		t.Fatalf("expected the next step's price and a null take_profit, got %s", deal.TakeProfitPrice)
+	}
+
+	if err := ts.ExecuteTakeProfitStep(100); err != nil {
+		t.Fatalf("failed to execute step: %v", err)
+	}
+	deal, _ = ts.GetDealByID(100)
+	if deal.Status != "bought" || deal.SoldAmount != "0.003" || deal.SoldVolume != "153" {
+		t.Fatalf("expected a partial exit, got %s sold=%s/%s", deal.Status, deal.SoldAmount, deal.SoldVolume)
+	}
+	step := deal.TakeProfitSteps[0]
+	if *step.Status != "finished" || step.TradeId == nil || step.ExecutionTimestamp.IsNull() {
+		t.Fatalf("expected the first step to be finished, got %+v", step)
+	}
+	last := *deal.BotEvents[len(deal.BotEvents)-1].Message
+	if !strings.HasPrefix(last, "Take profit step 1 (30%) executed. Price: 51000 USDT") {
+		t.Fatalf("unexpected bot event: %s", last)
+	}
+
+	if err := ts.ExecuteTakeProfitStep(100); err != nil {
+		t.Fatalf("failed to execute step: %v", err)
+	}
+	deal, _ = ts.GetDealByID(100)
+	// 153 + 0.007 * 52000 = 517 for 500 spent
+	if deal.Status != "completed" || !deal.Finished || deal.FinalProfit != "17" || deal.SoldAmount != "0.01" {
+		t.Fatalf("expected a completed deal with profit 17, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if err := ts.ExecuteTakeProfitStep(100); err == nil {
+		t.Fatal("expected an error for a finished deal")
+	}
+}
+
+func TestTakeProfitSteps_Simulation(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	boughtDeal(t, ts)
+	ts.SetDealTakeProfitSteps(100, []tcmock.TakeProfitStep{
+		{AmountPercentage: ptr(50), ProfitPercentage: ptr(1)},
+		{AmountPercentage: ptr(25), ProfitPercentage: ptr(2)},
+		{AmountPercentage: ptr(25), ProfitPercentage: ptr(3)},
+	})
+
+	ts.SetMarketPrice("USDT_BTC", "51200")
+	deal, _ := ts.GetDealByID(100)
+	if deal.Status != "bought" || deal.SoldAmount != "0.0075" || deal.TakeProfitPrice != "51500" {
+		t.Fatalf("expected two steps to execute, got sold=%s tp=%s", deal.SoldAmount, deal.TakeProfitPrice)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "51600")
+	deal, _ = ts.GetDealByID(100)
+	if deal.Status != "completed" || deal.FinalProfit != "8.75" {
+		t.Fatalf("expected completion with profit 8.75, got %s %s", deal.Status, deal.FinalProfit)
+	}
+}
+
+func TestTakeProfitSteps_ReplaceAfterExecuted(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	// Step 2 executed before step 1, so new steps must be numbered after it
+	boughtDeal(t, ts, WithTakeProfitSteps(
+		TakeProfitStep{ProfitPercentage: 3, AmountPercentage: 50},
+		TakeProfitStep{ProfitPercentage: 2, AmountPercentage: 50, Status: takeProfitStepFinished},
+	))
+	if err := ts.SetDealTakeProfitSteps(100, []tcmock.TakeProfitStep{{AmountPercentage: ptr(50), ProfitPercentage: ptr(4)}}); err != nil {
+		t.Fatalf("failed to set steps: %v", err)
+	}
+	deal, _ := ts.GetDealByID(100)
+	if len(deal.TakeProfitSteps) != 2 || *deal.TakeProfitSteps[0].Id != 2 || *deal.TakeProfitSteps[1].Id != 3 {
+		t.Fatalf("expected the new step to get id 3, got %+v", deal.TakeProfitSteps)
+	}
+
+	// Fractional executed percentages count in full
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought", WithBought("0.01", "500", "50000")), WithTakeProfitSteps(
+		TakeProfitStep{ProfitPercentage: 2, AmountPercentage: 50.5, Status: takeProfitStepFinished},
+		TakeProfitStep{ProfitPercentage: 3, AmountPercentage: 49.5},
+	))
+	err := ts.SetDealTakeProfitSteps(101, []tcmock.TakeProfitStep{{AmountPercentage: ptr(50), ProfitPercentage: ptr(4)}})
+	if err == nil || err.Error() != "amount percentages must add up to 100" {
+		t.Fatalf("expected 50.5%% + 50%% to be rejected, got %v", err)
+	}
+}
+
+// fixtureStepsDeal returns a bought deal of 0.01 BTC at 50000 whose steps only carry percentages
+func fixtureStepsDeal() tcmock.Deal {
+	deal := NewDeal(100, 1, "USDT_BTC", "bought")
+	deal.BoughtAmount = "0.01"
+	deal.BoughtVolume = "500"
+	deal.BoughtAveragePrice = "50000"
+	for _, profit := range []float32{2, 4} {
+		deal.TakeProfitSteps = append(deal.TakeProfitSteps, dealTakeProfitStep{
+			ProfitPercentage: ptr(profit),
+			AmountPercentage: ptr(float32(50)),
+			Status:           ptr(takeProfitStepActive),
+		})
+	}
+	return deal
+}
+
+func TestTakeProfitSteps_FixtureSteps(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Bot", 1, true))
+	if err := ts.AddDeal(fixtureStepsDeal()); err != nil {
+		t.Fatalf("failed to add deal: %v", err)
+	}
+	deal, _ := ts.GetDealByID(100)
+	if step := deal.TakeProfitSteps[1]; step.Id == nil || *step.Id != 2 || *step.Price != "52000" || *step.InitialAmount != "0.005" {
+		t.Fatalf("expected the steps to be normalized when added, got %+v", step)
+	}
+	if err := ts.ExecuteTakeProfitStep(100); err != nil {
+		t.Fatalf("failed to execute step: %v", err)
+	}
+	if deal, _ = ts.GetDealByID(100); deal.SoldAmount != "0.005" || deal.SoldVolume != "255" {
+		t.Fatalf("expected the first step to sell 0.005 at 51000, got %s/%s", deal.SoldAmount, deal.SoldVolume)
+	}
+
+	// Simulated deals execute fixture steps as the price moves
+	sim := NewTestServer(t)
+	defer sim.Close()
+
+	sim.SetMarketPrice("USDT_BTC", "50000")
+	sim.SetDealSimulation(true)
+	sim.AddBot(NewBot(1, "Bot", 1, true))
+	if err := sim.AddDeal(fixtureStepsDeal()); err != nil {
+		t.Fatalf("failed to add deal: %v", err)
+	}
+	sim.SetMarketPrice("USDT_BTC", "52000")
+	if deal, _ = sim.GetDealByID(100); deal.Status != "completed" || deal.SoldAmount != "0.01" {
+		t.Fatalf("expected both steps to execute, got %s sold=%s", deal.Status, deal.SoldAmount)
+	}
+}
+
+func TestUpdateDeal(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	boughtDeal(t, ts)
+	ts.SetDealTakeProfitSteps(100, []tcmock.TakeProfitStep{
+		{AmountPercentage: ptr(50), ProfitPercentage: ptr(2)},
+		{AmountPercentage: ptr(50), ProfitPercentage: ptr(4)},
+	})
+	ts.ExecuteTakeProfitStep(100)
+
+	patch := func(body string) (*http.Response, tcmock.Deal, tcmock.ErrorResponse) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL()+"/ver1/deals/100/update_deal", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to PATCH update_deal: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var raw json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&raw)
+		var deal tcmock.Deal
+		var errResp tcmock.ErrorResponse
+		json.Unmarshal(raw, &deal)
+		json.Unmarshal(raw, &errResp)
+		return resp, deal, errResp
+	}
+
+	// The executed step stays; the new steps must complete it to 100%
+	resp, _, errResp := patch(`{"take_profit_type": "total", "take_profit_steps": [{"amount_percentage": 30, "profit_percentage": 3}]}`)
+	if resp.StatusCode != http.StatusBadRequest || (*errResp.ErrorAttributes)["take_profit_steps"][0] != "amount percentages must add up to 100" {
+		t.Fatalf("expected a percentage error, got %d", resp.StatusCode)
+	}
+
+	resp, deal, _ := patch(`{"take_profit_type": "total", "take_profit_steps": [
+		{"id": 1, "amount_percentage": 50, "profit_percentage": 2},
+		{"amount_percentage": 20, "profit_percentage": 3},
+		{"amount_percentage": 30, "profit_percentage": 5}
+	], "stop_loss_percentage": 2.5, "max_safety_orders": 3}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if len(deal.TakeProfitSteps) != 3 || *deal.TakeProfitSteps[0].Status != "finished" || *deal.TakeProfitSteps[2].Price != "52500" {
+		t.Fatalf("unexpected steps: %+v", deal.TakeProfitSteps)
+	}
+	if *deal.TakeProfitSteps[1].InitialAmount != "0.002" || *deal.TakeProfitSteps[2].InitialAmount != "0.003" {
+		t.Fatalf("expected the remaining 0.005 split 0.002/0.003, got %s/%s",
+			*deal.TakeProfitSteps[1].InitialAmount, *deal.TakeProfitSteps[2].InitialAmount)
+	}
+	if deal.StopLossPercentage != "2.5" || deal.MaxSafetyOrders != 3 {
+		t.Fatalf("expected stop loss 2.5 and 3 safety orders, got %s %d", deal.StopLossPercentage, deal.MaxSafetyOrders)
+	}
+
+	resp, _, errResp = patch(`{"take_profit_type": "total", "take_profit": 1.5, "take_profit_steps": [{"amount_percentage": 50, "profit_percentage": 3}]}`)
+	if resp.StatusCode != http.StatusBadRequest || len((*errResp.ErrorAttributes)["take_profit"]) == 0 {
+		t.Fatalf("expected a take_profit error, got %d", resp.StatusCode)
+	}
+
+	// A single take profit replaces the pending steps
+	resp, deal, _ = patch(`{"take_profit_type": "total", "take_profit": 1.5}`)
+	if resp.StatusCode != http.StatusOK || len(deal.TakeProfitSteps) != 1 || deal.TakeProfitPrice != "50750" {
+		t.Fatalf("expected a single take profit at 50750, got %d steps, tp=%s", len(deal.TakeProfitSteps), deal.TakeProfitPrice)
+	}
+}
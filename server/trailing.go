@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/recomma/3commas-mock/decimal"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// dealStatusTTPActivated is the status of a deal whose trailing take profit is following the price
+const dealStatusTTPActivated tcmock.DealStatus = "ttp_activated"
+
+// SetDealTrailingDeviation sets the trailing take profit deviation of a deal in percent
+func (ts *TestServer) SetDealTrailingDeviation(dealID int, deviation string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	deal, ok := ts.deals[dealID]
+	if !ok {
+		return fmt.Errorf("deal %d not found", dealID)
+	}
+	ts.dealExtensionLocked(deal).TrailingDeviation = decimal.Format(decimal.Parse(deviation))
+	deal.UpdatedAt = ts.nowLocked()
+	ts.dealChangedLocked(DealUpdated, deal, "")
+	return nil
+}
+
+// simulateExitLocked closes a deal when the price reaches its stop loss or take profit
+// Without trailing the deal closes at the take profit price; with trailing the
+// deal switches to ttp_activated, follows the highest price (the lowest for short
+// deals) in trailing_max_price and closes once the price moves trailing_deviation
+// percent back from it
+// Liquidation, the stop loss and close strategies are evaluated first (see
+// simulateLiquidationLocked, simulateStopLossLocked and simulateCloseStrategiesLocked)
+// Take profit steps take precedence over the single take profit
+// The caller must hold ts.mu
+func (ts *TestServer) simulateExitLocked(deal *tcmock.Deal, price *big.Rat) {
+	if !isActiveDeal(deal) {
+		return
+	}
+
+	if ts.simulateLiquidationLocked(deal, price) || ts.simulateStopLossLocked(deal, price) ||
+		ts.simulateCloseStrategiesLocked(deal, price) {
+		return
+	}
+
+	if nextTakeProfitStep(deal) >= 0 {
+		return
+	}
+	ext := ts.dealExtensionLocked(deal)
+	if deal.Status != dealStatusTTPActivated {
+		target := decimal.Parse(deal.TakeProfitPrice)
+		if target.Sign() <= 0 || dealCmp(deal, price, target) < 0 {
+			return
+		}
+		if !deal.TrailingEnabled || decimal.Parse(ext.TrailingDeviation).Sign() <= 0 {
+			ts.closeDealLocked(deal, price, "completed", "Take profit executed.")
+			return
+		}
+
+		now := ts.nowLocked()
+		previous := deal.Status
+		setDealStatus(deal, dealStatusTTPActivated, now)
+		ext.TrailingMaxPrice = ptr(decimal.Format(price))
+		addBotEventAt(deal, fmt.Sprintf("Trailing Take Profit activated. Price: %s %s",
+			decimal.Format(price), deal.FromCurrency), now)
+		ts.dealChangedLocked(DealStatusChanged, deal, previous)
+		return
+	}
+
+	high := decimal.Parse(*ext.TrailingMaxPrice)
+	if dealCmp(deal, price, high) > 0 {
+		ext.TrailingMaxPrice = ptr(decimal.Format(price))
+		deal.UpdatedAt = ts.nowLocked()
+		ts.dealChangedLocked(DealUpdated, deal, "")
+		return
+	}
+	deviation := new(big.Rat).Neg(decimal.Parse(ext.TrailingDeviation))
+	if dealCmp(deal, price, new(big.Rat).Mul(high, dealPercentFactor(deal, deviation))) <= 0 {
+		ts.closeDealLocked(deal, price, "completed", "Trailing Take Profit triggered.")
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// trailingDeal adds the deal of boughtDeal with a 2% take profit
+// opts apply after the defaults
+func trailingDeal(t *testing.T, ts *TestServer, opts ...DealOption) {
+	t.Helper()
+
+	boughtDeal(t, ts, append([]DealOption{
+		WithTakeProfit("2", tcmock.BotTakeProfitTypeTotal),
+		WithPrices("", "51000", ""),
+	}, opts...)...)
+}
+
+// lastBotEvent returns the message of the most recent bot event of a deal
+func lastBotEvent(t *testing.T, ts *TestServer, dealID int) string {
+	t.Helper()
+
+	deal, _ := ts.GetDealByID(dealID)
+	if len(deal.BotEvents) == 0 {
+		t.Fatalf("deal %d has no bot events", dealID)
+	}
+	return *deal.BotEvents[len(deal.BotEvents)-1].Message
+}
+
+func TestTakeProfit_Simulation(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	trailingDeal(t, ts)
+
+	ts.SetMarketPrice("USDT_BTC", "51000")
+	deal, _ := ts.GetDealByID(100)
+	if deal.Status != "completed" || deal.FinalProfit != "10" || deal.ClosedAt.IsNull() {
+		t.Fatalf("expected completion with profit 10, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if msg := lastBotEvent(t, ts, 100); !strings.HasPrefix(msg, "Take profit executed.") {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+}
+
+func TestTrailingTakeProfit_Simulation(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	trailingDeal(t, ts, WithTrailing("2"))
+	if err := ts.SetDealTrailingDeviation(100, "1"); err != nil {
+		t.Fatalf("failed to set deviation: %v", err)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "51500")
+	deal, _ := ts.GetDealByID(100)
+	ext, _ := ts.GetDealExtension(100)
+	if deal.Status != "ttp_activated" || ext.TrailingMaxPrice == nil || *ext.TrailingMaxPrice != "51500" {
+		t.Fatalf("expected trailing to activate, got %s", deal.Status)
+	}
+	if msg := lastBotEvent(t, ts, 100); msg != "Trailing Take Profit activated. Price: 51500 USDT" {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "52000")
+	ts.SetMarketPrice("USDT_BTC", "51600")
+	deal, _ = ts.GetDealByID(100)
+	ext, _ = ts.GetDealExtension(100)
+	if deal.Status != "ttp_activated" || *ext.TrailingMaxPrice != "52000" {
+		t.Fatalf("expected trailing to follow the high, got %s max=%s", deal.Status, *ext.TrailingMaxPrice)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "51400")
+	deal, _ = ts.GetDealByID(100)
+	if deal.Status != "completed" || deal.FinalProfit != "14" || deal.ClosedAt.IsNull() {
+		t.Fatalf("expected completion with profit 14, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if msg := lastBotEvent(t, ts, 100); !strings.HasPrefix(msg, "Trailing Take Profit triggered.") {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+}
+
+func TestTrailingStopLoss_Simulation(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	ts.SetDealSimulation(true)
+	trailingDeal(t, ts,
+		WithStopLoss(StopLoss{Percentage: "5", Trailing: true}),
+		WithPrices("", "60000", ""),
+	)
+
+	deal, _ := ts.GetDealByID(100)
+	if deal.StopLossPrice != "47500" {
+		t.Fatalf("expected stop loss price 47500, got %s", deal.StopLossPrice)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "54000")
+	resp, err := http.Get(ts.URL() + "/ver1/deals/100/show")
+	if err != nil {
+		t.Fatalf("failed to get deal: %v", err)
+	}
+	var body DealResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if body.TslMaxPrice == nil || *body.TslMaxPrice != "54000" || body.StopLossPrice != "51300" {
+		t.Fatalf("expected the stop loss to trail to 51300, got %s", body.StopLossPrice)
+	}
+
+	ts.SetMarketPrice("USDT_BTC", "51000")
+	deal, _ = ts.GetDealByID(100)
+	if deal.Status != "stop_loss_finished" || deal.FinalProfit != "10" || deal.ClosedAt.IsNull() {
+		t.Fatalf("expected a stop loss exit with profit 10, got %s %s", deal.Status, deal.FinalProfit)
+	}
+	if msg := lastBotEvent(t, ts, 100); !strings.HasPrefix(msg, "Trailing Stop Loss triggered.") {
+		t.Fatalf("unexpected bot event %q", msg)
+	}
+}
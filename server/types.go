@@ -11,12 +11,12 @@ import (
 // Helper types and functions for creating test data with the full generated types
 
 // NewBot creates a minimal Bot with required fields populated
-// This is a helper to make it easier to create test bots
-func NewBot(id int, name string, accountID int, enabled bool) tcmock.Bot {
+// This is a helper to make it easier to create test bots; options fill in the rest
+func NewBot(id int, name string, accountID int, enabled bool, opts ...BotOption) tcmock.Bot {
 	now := time.Now()
 	namePtr := &name
 	strategy := tcmock.BotStrategyLong
-	return tcmock.Bot{
+	bot := tcmock.Bot{
 		Id:                          id,
 		Name:                        namePtr,
 		AccountId:                   accountID,
@@ -34,11 +34,16 @@ func NewBot(id int, name string, accountID int, enabled bool) tcmock.Bot {
 		Deletable:                   true,
 		Strategy:                    &strategy,
 	}
+	for _, opt := range opts {
+		opt.applyBot(&bot)
+	}
+	return bot
 }
 
 // NewDeal creates a minimal Deal with required fields populated
-// This is a helper to make it easier to create test deals
-func NewDeal(id int, botID int, pair string, status string) tcmock.Deal {
+// This is a helper to make it easier to create test deals; options fill in the rest
+// Known statuses set the derived fields (finished, closed_at, cancellable, ...) like UpdateDealStatus
+func NewDeal(id int, botID int, pair string, status string, opts ...DealOption) tcmock.Deal {
 	now := time.Now()
 	// Extract currency from pair (format like "USDT_BTC" or "BTC_USD")
 	toCurrency := "BTC"
@@ -52,7 +57,7 @@ func NewDeal(id int, botID int, pair string, status string) tcmock.Deal {
 			toCurrency = upper
 		}
 	}
-	return tcmock.Deal{
+	deal := tcmock.Deal{
 		Id:        id,
 		BotId:     botID,
 		Pair:      pair,
@@ -113,26 +118,60 @@ func NewDeal(id int, botID int, pair string, status string) tcmock.Deal {
 		SafetyStrategyList:               []map[string]interface{}{},
 		SlToBreakevenEnabled:             false,
 		CloseStrategyList:                []map[string]interface{}{},
-		TakeProfitSteps: []struct {
-			AmountPercentage   *float32                     `json:"amount_percentage,omitempty"`
-			Editable           *bool                        `json:"editable,omitempty"`
-			ExecutionTimestamp nullable.Nullable[time.Time] `json:"execution_timestamp,omitempty"`
-			Id                 *int                         `json:"id,omitempty"`
-			InitialAmount      *string                      `json:"initial_amount,omitempty"`
-			PanicSellable      *bool                        `json:"panic_sellable,omitempty"`
-			Price              *string                      `json:"price,omitempty"`
-			ProfitPercentage   *float32                     `json:"profit_percentage,omitempty"`
-			Status             *string                      `json:"status,omitempty"`
-			TradeId            *int                         `json:"trade_id,omitempty"`
-		}{},
-		Type: "simple",
+		TakeProfitSteps:                  []dealTakeProfitStep{},
+		Type:                             "simple",
+	}
+	setDealStatus(&deal, deal.Status, now)
+	for _, opt := range opts {
+		opt.applyDeal(&deal)
+	}
+	return deal
+}
+
+// dealTakeProfitStep is the element type of tcmock.Deal.TakeProfitSteps,
+// which the generated model declares inline
+type dealTakeProfitStep = struct {
+	AmountPercentage   *float32                     `json:"amount_percentage,omitempty"`
+	Editable           *bool                        `json:"editable,omitempty"`
+	ExecutionTimestamp nullable.Nullable[time.Time] `json:"execution_timestamp,omitempty"`
+	Id                 *int                         `json:"id,omitempty"`
+	InitialAmount      *string                      `json:"initial_amount,omitempty"`
+	PanicSellable      *bool                        `json:"panic_sellable,omitempty"`
+	Price              *string                      `json:"price,omitempty"`
+	ProfitPercentage   *float32                     `json:"profit_percentage,omitempty"`
+	Status             *string                      `json:"status,omitempty"`
+	TradeId            *int                         `json:"trade_id,omitempty"`
+}
+
+// NewAccount creates an exchange account with empty totals
+// This is a helper to make it easier to create test accounts
+func NewAccount(id int, name string, marketCode string) Account {
+	now := time.Now()
+	return Account{
+		Id:                   id,
+		Name:                 name,
+		MarketCode:           marketCode,
+		ExchangeName:         marketCode,
+		MarketIcon:           "https://3commas.io/img/exchanges/" + marketCode + ".png",
+		SupportedMarketTypes: []string{"spot"},
+		BtcAmount:            "0",
+		UsdAmount:            "0",
+		DayProfitBtc:         "0",
+		DayProfitUsd:         "0",
+		CreatedAt:            now,
+		UpdatedAt:            now,
 	}
 }
 
 // AddBotEvent adds a bot event to a deal
 // message: Human-readable event description
 func AddBotEvent(deal *tcmock.Deal, message string) {
-	now := time.Now()
+	addBotEventAt(deal, message, time.Now())
+}
+
+// addBotEventAt adds a bot event stamped with the given time
+func addBotEventAt(deal *tcmock.Deal, message string, at time.Time) {
+	now := at
 	msg := message
 	deal.BotEvents = append(deal.BotEvents, struct {
 		CreatedAt *time.Time `json:"created_at,omitempty"`
@@ -142,3 +181,8 @@ func AddBotEvent(deal *tcmock.Deal, message string) {
 		Message:   &msg,
 	})
 }
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook request headers
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookAttemptHeader   = "X-Webhook-Attempt"
+)
+
+// WebhookRetryPolicy controls how failed webhook deliveries are retried
+// A delivery fails when the request errors or the receiver answers with a non-2xx status
+type WebhookRetryPolicy struct {
+	MaxAttempts    int           // Total attempts including the first one
+	InitialBackoff time.Duration // Delay before the first retry
+	MaxBackoff     time.Duration // Upper bound for the doubling backoff
+	Timeout        time.Duration // Per-attempt HTTP timeout
+}
+
+// DefaultWebhookRetryPolicy is used unless SetWebhookRetryPolicy is called
+var DefaultWebhookRetryPolicy = WebhookRetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Timeout:        5 * time.Second,
+}
+
+// WebhookPayload is the JSON body POSTed to registered webhooks
+type WebhookPayload struct {
+	ID string `json:"id"`
+	DealChange
+}
+
+// WebhookAttempt records a single delivery attempt
+type WebhookAttempt struct {
+	At         time.Time
+	StatusCode int    // 0 if the request failed before a response
+	Error      string // Transport error or non-2xx description
+}
+
+// WebhookDeliveryStatus is the state of a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookPending   WebhookDeliveryStatus = "pending"
+	WebhookDelivered WebhookDeliveryStatus = "delivered"
+	WebhookFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an entry of the delivery log
+type WebhookDelivery struct {
+	ID        string
+	WebhookID int
+	URL       string
+	Kind      DealChangeKind
+	DealID    int
+	Payload   []byte
+	Status    WebhookDeliveryStatus
+	Attempts  []WebhookAttempt
+}
+
+// webhookEndpoint is a registered receiver with its own delivery queue
+// Deliveries to one endpoint are sent in order
+type webhookEndpoint struct {
+	id     int
+	url    string
+	secret string
+
+	queue []*WebhookDelivery
+	wake  chan struct{}
+	stop  chan struct{}
+}
+
+// webhookDispatcher delivers deal changes to registered endpoints
+// It has its own lock because deal listeners run with ts.mu held
+type webhookDispatcher struct {
+	mu         sync.Mutex
+	policy     WebhookRetryPolicy
+	endpoints  map[int]*webhookEndpoint
+	nextID     int
+	deliveries []*WebhookDelivery
+	seq        int
+	// pending counts queued and in-progress deliveries; idle is closed once it drops to zero
+	pending int
+	idle    chan struct{}
+}
+
+// newWebhookDispatcher creates a dispatcher with the default retry policy
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		policy:    DefaultWebhookRetryPolicy,
+		endpoints: make(map[int]*webhookEndpoint),
+	}
+}
+
+// RegisterWebhook registers a callback URL that receives a signed POST for every
+// deal change (creation, status change, new bot event)
+// The signature is the hex HMAC-SHA256 of the body keyed with secret, sent as
+// "sha256=<hex>" in the X-Webhook-Signature header
+// Returns the webhook ID for UnregisterWebhook
+func (ts *TestServer) RegisterWebhook(url, secret string) int {
+	return ts.webhooks.register(url, secret)
+}
+
+// UnregisterWebhook stops deliveries to a webhook
+// Deliveries still queued for it are dropped and stay pending in the log
+func (ts *TestServer) UnregisterWebhook(id int) {
+	ts.webhooks.unregister(id)
+}
+
+// SetWebhookRetryPolicy replaces the retry policy for future deliveries
+func (ts *TestServer) SetWebhookRetryPolicy(policy WebhookRetryPolicy) {
+	ts.webhooks.mu.Lock()
+	defer ts.webhooks.mu.Unlock()
+
+	ts.webhooks.policy = policy
+}
+
+// WebhookDeliveries returns a snapshot of the delivery log in dispatch order
+func (ts *TestServer) WebhookDeliveries() []WebhookDelivery {
+	ts.webhooks.mu.Lock()
+	defer ts.webhooks.mu.Unlock()
+
+	result := make([]WebhookDelivery, 0, len(ts.webhooks.deliveries))
+	for _, d := range ts.webhooks.deliveries {
+		snapshot := *d
+		snapshot.Attempts = append([]WebhookAttempt(nil), d.Attempts...)
+		result = append(result, snapshot)
+	}
+	return result
+}
+
+// WaitForWebhooks blocks until every queued delivery was delivered or gave up
+func (ts *TestServer) WaitForWebhooks(timeout time.Duration) error {
+	ts.webhooks.mu.Lock()
+	if ts.webhooks.pending == 0 {
+		ts.webhooks.mu.Unlock()
+		return nil
+	}
+	idle := ts.webhooks.idle
+	ts.webhooks.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("webhook deliveries still pending after %s", timeout)
+	}
+}
+
+// SignWebhookPayload returns the X-Webhook-Signature value for body
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks an X-Webhook-Signature value against body
+func VerifyWebhookSignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, body)), []byte(signature))
+}
+
+// register adds an endpoint and starts its delivery worker
+func (d *webhookDispatcher) register(url, secret string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	ep := &webhookEndpoint{
+		id:     d.nextID,
+		url:    url,
+		secret: secret,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	d.endpoints[ep.id] = ep
+	go d.run(ep)
+
+	return ep.id
+}
+
+// unregister removes an endpoint and stops its worker
+func (d *webhookDispatcher) unregister(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if ep, ok := d.endpoints[id]; ok {
+		d.stopEndpointLocked(ep)
+		delete(d.endpoints, id)
+	}
+}
+
+// stopEndpointLocked stops the worker and releases its queued deliveries
+func (d *webhookDispatcher) stopEndpointLocked(ep *webhookEndpoint) {
+	close(ep.stop)
+	for range ep.queue {
+		d.donePendingLocked()
+	}
+	ep.queue = nil
+}
+
+// addPendingLocked counts a queued delivery
+// The caller must hold d.mu
+func (d *webhookDispatcher) addPendingLocked() {
+	if d.pending == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.pending++
+}
+
+// donePendingLocked counts a delivery that finished or was dropped
+// The caller must hold d.mu
+func (d *webhookDispatcher) donePendingLocked() {
+	d.pending--
+	if d.pending == 0 {
+		close(d.idle)
+	}
+}
+
+// reset unregisters all endpoints and clears the delivery log
+func (d *webhookDispatcher) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id, ep := range d.endpoints {
+		d.stopEndpointLocked(ep)
+		delete(d.endpoints, id)
+	}
+	d.deliveries = nil
+	d.policy = DefaultWebhookRetryPolicy
+}
+
+// dealChanged is the deal listener that queues a delivery per endpoint
+func (d *webhookDispatcher) dealChanged(change DealChange) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, ep := range d.endpoints {
+		d.seq++
+		payload := WebhookPayload{
+			ID:         "whd_" + strconv.Itoa(d.seq),
+			DealChange: change,
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			continue
+		}
+
+		delivery := &WebhookDelivery{
+			ID:        payload.ID,
+			WebhookID: ep.id,
+			URL:       ep.url,
+			Kind:      change.Kind,
+			DealID:    change.Deal.Id,
+			Payload:   body,
+			Status:    WebhookPending,
+		}
+		d.deliveries = append(d.deliveries, delivery)
+		d.addPendingLocked()
+		ep.queue = append(ep.queue, delivery)
+
+		select {
+		case ep.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run delivers an endpoint's queue in order until the endpoint is stopped
+func (d *webhookDispatcher) run(ep *webhookEndpoint) {
+	for {
+		d.mu.Lock()
+		var next *WebhookDelivery
+		if len(ep.queue) > 0 {
+			next = ep.queue[0]
+			ep.queue = ep.queue[1:]
+		}
+		policy := d.policy
+		d.mu.Unlock()
+
+		if next == nil {
+			select {
+			case <-ep.wake:
+				continue
+			case <-ep.stop:
+				return
+			}
+		}
+
+		d.deliver(ep, next, policy)
+		d.mu.Lock()
+		d.donePendingLocked()
+		d.mu.Unlock()
+	}
+}
+
+// deliver POSTs a delivery, retrying with exponential backoff
+func (d *webhookDispatcher) deliver(ep *webhookEndpoint, delivery *WebhookDelivery, policy WebhookRetryPolicy) {
+	backoff := policy.InitialBackoff
+	client := &http.Client{Timeout: policy.Timeout}
+
+	for attempt := 1; ; attempt++ {
+		result := d.attempt(client, ep, delivery, attempt)
+
+		d.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		switch {
+		case result.Error == "":
+			delivery.Status = WebhookDelivered
+		case attempt >= policy.MaxAttempts:
+			delivery.Status = WebhookFailed
+		}
+		status := delivery.Status
+		d.mu.Unlock()
+
+		if status != WebhookPending {
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ep.stop:
+			return
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// attempt sends a single delivery request
+func (d *webhookDispatcher) attempt(client *http.Client, ep *webhookEndpoint, delivery *WebhookDelivery, attempt int) WebhookAttempt {
+	result := WebhookAttempt{At: time.Now()}
+
+	req, err := http.NewRequest(http.MethodPost, ep.url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(delivery.Kind))
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookAttemptHeader, strconv.Itoa(attempt))
+	if ep.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(ep.secret, delivery.Payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Error = fmt.Sprintf("receiver answered %d", resp.StatusCode)
+	}
+	return result
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records webhook requests and fails the first failures requests
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if wr.failures > 0 {
+		wr.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	wr.bodies = append(wr.bodies, body)
+	wr.headers = append(wr.headers, r.Header.Clone())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func fastRetryPolicy() WebhookRetryPolicy {
+	return WebhookRetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Timeout:        time.Second,
+	}
+}
+
+func TestWebhooks_DeliversSignedDealChanges(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	receiver := &webhookReceiver{}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	ts.SetClock(now)
+	ts.RegisterWebhook(hook.URL, "s3cret")
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+	ts.AddBotEventToDeal(101, "Placing safety order. Price: 48750.0 USDT Size: 0.0004 BTC")
+	ts.UpdateDealStatus(101, "completed")
+
+	if err := ts.WaitForWebhooks(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	if len(receiver.bodies) != 3 {
+		t.Fatalf("expected 3 webhook deliveries, got %d", len(receiver.bodies))
+	}
+
+	wantKinds := []DealChangeKind{DealCreated, DealBotEventAdded, DealStatusChanged}
+	for i, body := range receiver.bodies {
+		if !VerifyWebhookSignature("s3cret", body, receiver.headers[i].Get(WebhookSignatureHeader)) {
+			t.Errorf("delivery %d: invalid signature", i)
+		}
+
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("delivery %d: failed to decode payload: %v", i, err)
+		}
+		if payload.Kind != wantKinds[i] {
+			t.Errorf("delivery %d: expected kind %s, got %s", i, wantKinds[i], payload.Kind)
+		}
+		if payload.Deal.Id != 101 {
+			t.Errorf("delivery %d: expected deal 101, got %d", i, payload.Deal.Id)
+		}
+		if !payload.OccurredAt.Equal(now) {
+			t.Errorf("delivery %d: expected the change at the mock time, got %v", i, payload.OccurredAt)
+		}
+	}
+
+	var last WebhookPayload
+	json.Unmarshal(receiver.bodies[2], &last)
+	if last.PreviousStatus != "bought" || last.Deal.Status != "completed" {
+		t.Errorf("expected bought -> completed, got %s -> %s", last.PreviousStatus, last.Deal.Status)
+	}
+}
+
+func TestWebhooks_RetriesWithBackoff(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	receiver := &webhookReceiver{failures: 2}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+
+	ts.SetWebhookRetryPolicy(fastRetryPolicy())
+	ts.RegisterWebhook(hook.URL, "")
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+
+	if err := ts.WaitForWebhooks(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := ts.WebhookDeliveries()
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != WebhookDelivered {
+		t.Fatalf("expected delivered, got %s", d.Status)
+	}
+	if len(d.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(d.Attempts))
+	}
+	if d.Attempts[0].StatusCode != http.StatusServiceUnavailable || d.Attempts[2].StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected attempt status codes: %+v", d.Attempts)
+	}
+}
+
+func TestWebhooks_GivesUpAfterMaxAttempts(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	receiver := &webhookReceiver{failures: 10}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+
+	ts.SetWebhookRetryPolicy(fastRetryPolicy())
+	ts.RegisterWebhook(hook.URL, "")
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+
+	if err := ts.WaitForWebhooks(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	d := ts.WebhookDeliveries()[0]
+	if d.Status != WebhookFailed {
+		t.Fatalf("expected failed, got %s", d.Status)
+	}
+	if len(d.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(d.Attempts))
+	}
+}
+
+func TestWebhooks_Unregister(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	receiver := &webhookReceiver{}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+
+	id := ts.RegisterWebhook(hook.URL, "")
+	ts.UnregisterWebhook(id)
+
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+
+	if err := ts.WaitForWebhooks(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.WebhookDeliveries()) != 0 {
+		t.Fatalf("expected no deliveries after unregister, got %d", len(ts.WebhookDeliveries()))
+	}
+}
+
+func TestWebhooks_WaitWhileDealsChange(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	receiver := &webhookReceiver{failures: 1000}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+
+	ts.SetWebhookRetryPolicy(WebhookRetryPolicy{MaxAttempts: 1000, InitialBackoff: time.Hour, Timeout: time.Second})
+	id := ts.RegisterWebhook(hook.URL, "")
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+
+	// Waits overlap with new deliveries; a stuck delivery times the wait out
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ts.AddDeal(NewDeal(100+i, 1, "USDT_BTC", "bought"))
+		}()
+		go func() {
+			defer wg.Done()
+			ts.WaitForWebhooks(time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	if err := ts.WaitForWebhooks(10 * time.Millisecond); err == nil {
+		t.Fatal("expected the retried delivery to keep the wait pending")
+	}
+
+	ts.UnregisterWebhook(id)
+	if err := ts.WaitForWebhooks(time.Second); err != nil {
+		t.Fatalf("expected unregistering to release the queued deliveries, got %v", err)
+	}
+}
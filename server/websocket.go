@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// WebSocket channels, matching the 3Commas ActionCable stream
+const (
+	DealsChannel       = "DealsChannel"
+	SmartTradesChannel = "SmartTradesChannel"
+)
+
+// channelPaths are the paths signed by subscribers for each channel
+var channelPaths = map[string]string{
+	DealsChannel:       "/deals",
+	SmartTradesChannel: "/smart_trades",
+}
+
+// DefaultWebSocketPingInterval matches the ActionCable heartbeat
+const DefaultWebSocketPingInterval = 3 * time.Second
+
+// cableFrame is an ActionCable protocol frame
+// Server frames use Type/Message/Identifier, client frames use Command/Identifier
+type cableFrame struct {
+	Type       string          `json:"type,omitempty"`
+	Command    string          `json:"command,omitempty"`
+	Identifier string          `json:"identifier,omitempty"`
+	Message    json.RawMessage `json:"message,omitempty"`
+}
+
+// cableIdentifier is the decoded subscription identifier
+// Example: {"channel":"DealsChannel","users":[{"api_key":"...","signature":"..."}]}
+type cableIdentifier struct {
+	Channel string `json:"channel"`
+	Users   []struct {
+		APIKey    string `json:"api_key"`
+		Signature string `json:"signature"`
+	} `json:"users"`
+}
+
+// wsClient is a connected stream consumer
+type wsClient struct {
+	conn   *websocket.Conn
+	send   chan []byte
+	cancel context.CancelFunc
+
+	// Subscribed identifiers by channel
+	subs map[string]string
+}
+
+// wsHub fans deal changes out to subscribed stream clients
+// It has its own lock because deal listeners run with ts.mu held
+type wsHub struct {
+	mu           sync.Mutex
+	clients      map[*wsClient]struct{}
+	pingInterval time.Duration
+	apiKey       string
+	secret       string
+}
+
+// newWSHub creates a hub with the default heartbeat
+func newWSHub() *wsHub {
+	return &wsHub{
+		clients:      make(map[*wsClient]struct{}),
+		pingInterval: DefaultWebSocketPingInterval,
+	}
+}
+
+// WebSocketURL returns the ws:// URL of the ActionCable stream
+func (ts *TestServer) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(ts.server.URL, "http") + "/websocket"
+}
+
+// SetWebSocketCredentials requires subscribers to sign their subscription
+// The identifier must list a user with this api_key and a signature equal to
+// the hex HMAC-SHA256 of the channel path ("/deals", "/smart_trades") keyed with secret
+// An empty apiKey disables the check
+func (ts *TestServer) SetWebSocketCredentials(apiKey, secret string) {
+	ts.ws.mu.Lock()
+	defer ts.ws.mu.Unlock()
+
+	ts.ws.apiKey = apiKey
+	ts.ws.secret = secret
+}
+
+// SetWebSocketPingInterval changes the heartbeat interval for new connections
+// A zero or negative interval restores DefaultWebSocketPingInterval
+func (ts *TestServer) SetWebSocketPingInterval(interval time.Duration) {
+	ts.ws.mu.Lock()
+	defer ts.ws.mu.Unlock()
+
+	if interval <= 0 {
+		interval = DefaultWebSocketPingInterval
+	}
+	ts.ws.pingInterval = interval
+}
+
+// SignWebSocketChannel returns the subscription signature for a channel path
+func SignWebSocketChannel(secret, path string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(path))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleWebSocket upgrades the connection and runs the ActionCable protocol
+func (ts *TestServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols:       []string{"actioncable-v1-json"},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &wsClient{
+		conn:   conn,
+		send:   make(chan []byte, 256),
+		cancel: cancel,
+		subs:   make(map[string]string),
+	}
+
+	ts.ws.mu.Lock()
+	ts.ws.clients[client] = struct{}{}
+	pingInterval := ts.ws.pingInterval
+	ts.ws.mu.Unlock()
+
+	defer func() {
+		ts.ws.remove(client)
+		conn.CloseNow()
+	}()
+
+	go client.writeLoop(ctx, pingInterval)
+	client.enqueue(cableFrame{Type: "welcome"})
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		var frame cableFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			continue
+		}
+
+		switch frame.Command {
+		case "subscribe":
+			ts.ws.subscribe(client, frame.Identifier)
+		case "unsubscribe":
+			ts.ws.unsubscribe(client, frame.Identifier)
+		}
+	}
+}
+
+// subscribe confirms or rejects a subscription request
+func (h *wsHub) subscribe(client *wsClient, identifier string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var id cableIdentifier
+	if err := json.Unmarshal([]byte(identifier), &id); err != nil || !h.authorizedLocked(id) {
+		client.enqueue(cableFrame{Type: "reject_subscription", Identifier: identifier})
+		return
+	}
+
+	client.subs[id.Channel] = identifier
+	client.enqueue(cableFrame{Type: "confirm_subscription", Identifier: identifier})
+}
+
+// authorizedLocked checks the channel and, if credentials are configured, the signature
+func (h *wsHub) authorizedLocked(id cableIdentifier) bool {
+	path, ok := channelPaths[id.Channel]
+	if !ok {
+		return false
+	}
+	if h.apiKey == "" {
+		return true
+	}
+
+	want := SignWebSocketChannel(h.secret, path)
+	for _, user := range id.Users {
+		if user.APIKey == h.apiKey && hmac.Equal([]byte(user.Signature), []byte(want)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsubscribe drops a subscription
+func (h *wsHub) unsubscribe(client *wsClient, identifier string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for channel, id := range client.subs {
+		if id == identifier {
+			delete(client.subs, channel)
+		}
+	}
+}
+
+// broadcast sends message to every client subscribed to channel
+func (h *wsHub) broadcast(channel string, message interface{}) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if identifier, ok := client.subs[channel]; ok {
+			client.enqueue(cableFrame{Identifier: identifier, Message: body})
+		}
+	}
+}
+
+// dealChanged is the deal listener that streams the updated deal
+func (h *wsHub) dealChanged(change DealChange) {
+	h.broadcast(DealsChannel, change.Deal)
+}
+
+// remove forgets a client
+func (h *wsHub) remove(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, client)
+	client.cancel()
+}
+
+// close disconnects all clients
+func (h *wsHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		client.cancel()
+		client.conn.CloseNow()
+	}
+	h.clients = make(map[*wsClient]struct{})
+}
+
+// enqueue queues a frame for the writer; slow clients are disconnected
+func (c *wsClient) enqueue(frame cableFrame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		c.cancel()
+	}
+}
+
+// writeLoop writes queued frames and heartbeats until the connection ends
+func (c *wsClient) writeLoop(ctx context.Context, pingInterval time.Duration) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.conn.CloseNow()
+			return
+		case data := <-c.send:
+			if err := c.conn.Write(ctx, websocket.MessageText, data); err != nil {
+				c.cancel()
+				return
+			}
+		case now := <-ticker.C:
+			ping, _ := json.Marshal(struct {
+				Type    string `json:"type"`
+				Message int64  `json:"message"`
+			}{Type: "ping", Message: now.Unix()})
+			if err := c.conn.Write(ctx, websocket.MessageText, ping); err != nil {
+				c.cancel()
+				return
+			}
+		}
+	}
+}
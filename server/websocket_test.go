@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/recomma/3commas-mock/tcmock"
+)
+
+// dialCable connects to the mock stream and consumes the welcome frame
+func dialCable(t *testing.T, ts *TestServer) (*websocket.Conn, context.Context) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	conn, _, err := websocket.Dial(ctx, ts.WebSocketURL(), &websocket.DialOptions{
+		Subprotocols: []string{"actioncable-v1-json"},
+	})
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+
+	if frame := readCable(t, ctx, conn); frame.Type != "welcome" {
+		t.Fatalf("expected welcome frame, got %+v", frame)
+	}
+	return conn, ctx
+}
+
+// readCable reads the next non-ping frame
+func readCable(t *testing.T, ctx context.Context, conn *websocket.Conn) cableFrame {
+	t.Helper()
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		var frame cableFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("failed to decode frame %s: %v", data, err)
+		}
+		if frame.Type != "ping" {
+			return frame
+		}
+	}
+}
+
+// subscribeCable sends a subscribe command
+func subscribeCable(t *testing.T, ctx context.Context, conn *websocket.Conn, identifier string) {
+	t.Helper()
+
+	data, _ := json.Marshal(cableFrame{Command: "subscribe", Identifier: identifier})
+	if err := conn.Write(ctx, websocket.MessageText, data); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+}
+
+func TestWebSocket_StreamsDealUpdates(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.AddBot(NewBot(1, "Test Bot", 123, true))
+	ts.AddDeal(NewDeal(101, 1, "USDT_BTC", "bought"))
+
+	conn, ctx := dialCable(t, ts)
+
+	identifier := `{"channel":"DealsChannel","users":[{"api_key":"key","signature":"sig"}]}`
+	subscribeCable(t, ctx, conn, identifier)
+
+	confirm := readCable(t, ctx, conn)
+	if confirm.Type != "confirm_subscription" || confirm.Identifier != identifier {
+		t.Fatalf("expected confirm_subscription, got %+v", confirm)
+	}
+
+	if err := ts.UpdateDealStatus(101, "completed"); err != nil {
+		t.Fatalf("failed to update deal: %v", err)
+	}
+
+	update := readCable(t, ctx, conn)
+	if update.Identifier != identifier {
+		t.Fatalf("expected message for %s, got %+v", identifier, update)
+	}
+	var deal tcmock.Deal
+	if err := json.Unmarshal(update.Message, &deal); err != nil {
+		t.Fatalf("failed to decode deal: %v", err)
+	}
+	if deal.Id != 101 || deal.Status != "completed" {
+		t.Fatalf("expected deal 101 completed, got %d %s", deal.Id, deal.Status)
+	}
+}
+
+func TestWebSocket_StreamsSmartTradeUpdates(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetMarketPrice("USDT_BTC", "50000")
+	conn, ctx := dialCable(t, ts)
+
+	identifier := `{"channel":"SmartTradesChannel","users":[{"api_key":"key","signature":"sig"}]}`
+	subscribeCable(t, ctx, conn, identifier)
+	if confirm := readCable(t, ctx, conn); confirm.Type != "confirm_subscription" {
+		t.Fatalf("expected confirm_subscription, got %+v", confirm)
+	}
+
+	createSmartTrade(t, ts, `{"pair": "USDT_BTC", "position": {"type": "buy", "units": {"value": "0.01"}}}`)
+
+	update := readCable(t, ctx, conn)
+	var trade SmartTrade
+	if err := json.Unmarshal(update.Message, &trade); err != nil {
+		t.Fatalf("failed to decode smart trade: %v", err)
+	}
+	if trade.Id != 1 || trade.Status.Type != SmartTradeWaitingTargets {
+		t.Fatalf("expected smart trade 1 waiting for targets, got %d %s", trade.Id, trade.Status.Type)
+	}
+}
+
+func TestWebSocket_Heartbeat(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetWebSocketPingInterval(10 * time.Millisecond)
+	conn, ctx := dialCable(t, ts)
+
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("failed to read ping: %v", err)
+	}
+	var ping struct {
+		Type    string `json:"type"`
+		Message int64  `json:"message"`
+	}
+	if err := json.Unmarshal(data, &ping); err != nil {
+		t.Fatalf("failed to decode ping: %v", err)
+	}
+	if ping.Type != "ping" || ping.Message == 0 {
+		t.Fatalf("expected ping with timestamp, got %s", data)
+	}
+}
+
+func TestWebSocket_HeartbeatDefault(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetWebSocketPingInterval(10 * time.Millisecond)
+	ts.SetWebSocketPingInterval(0)
+	dialCable(t, ts)
+
+	ts.ws.mu.Lock()
+	defer ts.ws.mu.Unlock()
+	if ts.ws.pingInterval != DefaultWebSocketPingInterval {
+		t.Fatalf("expected the default ping interval, got %v", ts.ws.pingInterval)
+	}
+}
+
+func TestWebSocket_RejectsSubscriptions(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ts.SetWebSocketCredentials("key", "secret")
+	conn, ctx := dialCable(t, ts)
+
+	tests := []struct {
+		name       string
+		identifier string
+		want       string
+	}{
+		{
+			name:       "unknown channel",
+			identifier: `{"channel":"ChatChannel"}`,
+			want:       "reject_subscription",
+		},
+		{
+			name:       "bad signature",
+			identifier: `{"channel":"DealsChannel","users":[{"api_key":"key","signature":"nope"}]}`,
+			want:       "reject_subscription",
+		},
+		{
+			name:       "signed",
+			identifier: `{"channel":"DealsChannel","users":[{"api_key":"key","signature":"` + SignWebSocketChannel("secret", "/deals") + `"}]}`,
+			want:       "confirm_subscription",
+		},
+	}
+
+	for _, tt := range tests {
+		subscribeCable(t, ctx, conn, tt.identifier)
+		frame := readCable(t, ctx, conn)
+		if frame.Type != tt.want {
+			t.Errorf("%s: expected %s, got %+v", tt.name, tt.want, frame)
+		}
+	}
+}